@@ -0,0 +1,31 @@
+package docs
+
+import (
+	_ "embed"
+	"net/http"
+
+	httpSwagger "github.com/swaggo/http-swagger"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Spec returns the OpenAPI 3 description of the HTTP API.
+func Spec() []byte {
+	return spec
+}
+
+func ShowSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// RedirectUI sends /docs to the Swagger UI index page.
+func RedirectUI(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/docs/index.html", http.StatusMovedPermanently)
+}
+
+// UI serves Swagger UI, it must be mounted under /docs/.
+func UI() http.HandlerFunc {
+	return httpSwagger.Handler(httpSwagger.URL("/openapi.json"))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/a-castellano/AlarmManager/webtls"
+)
+
+// pinHeader carries the PIN required to change state.
+const pinHeader = "X-Alarm-PIN"
+
+type clientResponse struct {
+	Success    bool   `json:"success"`
+	Identity   string `json:"identity"`
+	Permission string `json:"permission"`
+	// PINRequired tells clients to send the PIN in pinHeader with requests
+	// which may change state
+	PINRequired bool `json:"pin_required"`
+}
+
+type pinResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"msg"`
+}
+
+// showClient serves who sent the request and what it is allowed to do, so
+// clients know before a change is refused. Every client can write when
+// authorizer is nil.
+func showClient(authorizer *webtls.Authorizer, pin string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := clientResponse{Success: true, Identity: webtls.Identity(r), Permission: webtls.WritePermission, PINRequired: pin != ""}
+		if authorizer != nil {
+			response.Permission = authorizer.Permission(response.Identity)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		jsonString, _ := json.Marshal(response)
+		w.Write([]byte(jsonString))
+	}
+}
+
+// requirePIN refuses requests which may change state unless they carry pin
+// in pinHeader.
+func requirePIN(pin string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || subtle.ConstantTimeCompare([]byte(r.Header.Get(pinHeader)), []byte(pin)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			response := pinResponse{Message: "A valid PIN is required in " + pinHeader + " header to change state."}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(403)
+			jsonString, _ := json.Marshal(response)
+			w.Write([]byte(jsonString))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-castellano/AlarmManager/webtls"
+)
+
+func TestShowClient(t *testing.T) {
+	services := testServices()
+	services.authorizer = &webtls.Authorizer{Permissions: map[string]string{"kitchen-panel": webtls.ReadPermission}}
+	services.pin = "4721"
+	router := newRouter("test", services)
+
+	request := httptest.NewRequest("GET", "/whoami", nil)
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "kitchen-panel"}}}}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	var client clientResponse
+	json.Unmarshal(recorder.Body.Bytes(), &client)
+	if recorder.Code != 200 || client.Identity != "kitchen-panel" || client.Permission != webtls.ReadPermission || !client.PINRequired {
+		t.Errorf("Client should be kitchen-panel with read permission and PIN required, response was %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/whoami", nil))
+	client = clientResponse{}
+	json.Unmarshal(recorder.Body.Bytes(), &client)
+	if client.Permission != webtls.WritePermission || client.PINRequired {
+		t.Errorf("Every client should write without authorizer nor PIN, response was '%s'.", recorder.Body.String())
+	}
+}
+
+func TestRouterRequiresPIN(t *testing.T) {
+	services := testServices()
+	services.pin = "4721"
+	router := newRouter("test", services)
+
+	cases := []struct {
+		method      string
+		path        string
+		pin         string
+		expected    int
+		description string
+	}{
+		{"GET", "/version", "", 200, "Requests which don't change state should not require PIN"},
+		{"PUT", "/devices/status/home123", "", 403, "Mode changes without PIN should be refused"},
+		{"PUT", "/devices/status/home123", "1234", 403, "Mode changes with a wrong PIN should be refused"},
+		{"PUT", "/devices/status/home123", "4721", 404, "Mode changes with PIN should reach devices"},
+		{"PUT", "/groups/ground", "", 403, "Group mode changes without PIN should be refused"},
+	}
+	for _, testCase := range cases {
+		request := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader(`{"mode": "Armed"}`))
+		if testCase.pin != "" {
+			request.Header.Set(pinHeader, testCase.pin)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.expected {
+			t.Errorf("%s, status was %d '%s'.", testCase.description, recorder.Code, recorder.Body.String())
+		}
+	}
+}
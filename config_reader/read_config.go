@@ -2,8 +2,14 @@ package config
 
 import (
 	"errors"
+	"os"
 	"reflect"
+	"strconv"
+	"time"
 
+	"github.com/a-castellano/AlarmManager/listeners"
+	"github.com/a-castellano/AlarmManager/logging"
+	"github.com/a-castellano/AlarmManager/webtls"
 	viperLib "github.com/spf13/viper"
 )
 
@@ -14,11 +20,145 @@ type TuyaDeviceConfig struct {
 	ClientID   string
 	Secret     string
 	DeviceID   string
+	Simulator  SimulatorConfig
+}
+
+// SimulatorConfig is the initial state of simulated devices.
+type SimulatorConfig struct {
+	Mode        string
+	Sensors     []string
+	Latency     time.Duration
+	FailureRate float64
+}
+
+// BudgetConfig limits requests sent to Tuya cloud with a client ID, limits
+// set to 0 are not enforced.
+type BudgetConfig struct {
+	RequestsPerSecond float64
+	DailyLimit        int
+	MonthlyLimit      int
+}
+
+// ListenerConfig is a socket the API is served on.
+type ListenerConfig struct {
+	Type    string
+	Address string
+	Path    string
+	Mode    os.FileMode
+	Owner   string
+	Group   string
+}
+
+// TLSConfig enables HTTPS on the API when CertFile is set.
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion uint16
+	// ClientCAFile enables client certificate authentication
+	ClientCAFile string
+	// Identities maps client certificate identities to their permission
+	Identities map[string]string
+}
+
+// LoggingConfig selects log levels, format and outputs.
+type LoggingConfig struct {
+	Level logging.Level
+	// Levels overrides Level per subsystem
+	Levels map[string]logging.Level
+	Format logging.Format
+	Syslog bool
+	Stderr bool
+}
+
+type GroupConfig struct {
+	Name      string
+	DeviceIDs []string
+}
+
+type ScheduleConfig struct {
+	Cron     string
+	TimeZone string
+	DeviceID string
+	GroupID  string
+	Mode     string
+	Rollback bool
+	Holidays []string
+}
+
+type RuleActionConfig struct {
+	Type     string
+	DeviceID string
+	GroupID  string
+	Mode     string
+	URL      string
+	Message  string
+}
+
+type RuleConfig struct {
+	Trigger      string
+	DeviceID     string
+	For          time.Duration
+	TimeWindow   string
+	TimeZone     string
+	Mode         string
+	ModeDeviceID string
+	DryRun       bool
+	Actions      []RuleActionConfig
+}
+
+type NotificationChannelConfig struct {
+	Type        string
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	To          []string
+	URL         string
+	Token       string
+	Priority    int
+	Template    string
+	ContentType string
+}
+
+type NotificationRouteConfig struct {
+	EventTypes []string
+	DeviceIDs  []string
+	Channels   []string
+	Title      string
+	Template   string
+}
+
+type EscalationConfig struct {
+	DeviceIDs     []string
+	First         []string
+	Second        []string
+	EscalateAfter time.Duration
+	RepeatEvery   time.Duration
 }
 
 type Config struct {
-	Devices map[string]TuyaDeviceConfig
-	WebPort int
+	Devices              map[string]TuyaDeviceConfig
+	Groups               map[string]GroupConfig
+	Schedules            map[string]ScheduleConfig
+	Holidays             []string
+	Rules                map[string]RuleConfig
+	NotificationChannels map[string]NotificationChannelConfig
+	NotificationRoutes   []NotificationRouteConfig
+	Escalations          map[string]EscalationConfig
+	Budgets              map[string]BudgetConfig
+	PollInterval         time.Duration
+	WebPort              int
+	Listeners            []ListenerConfig
+	TLS                  TLSConfig
+	// WebPIN must be sent with every request which may change state when
+	// it is set
+	WebPIN               string
+	ConfirmationTimeout  time.Duration
+	ConfirmationInterval time.Duration
+	OfflineDebounce      time.Duration
+	ShutdownTimeout      time.Duration
+	Logging              LoggingConfig
 }
 
 func ReadConfig() (Config, error) {
@@ -31,6 +171,9 @@ func ReadConfig() (Config, error) {
 
 	tuyaDevicesRequiredVariables := []string{"name", "type", "host", "client_id", "secret", "device_id"}
 
+	// simulated devices don't use Tuya cloud
+	simulatedDevicesRequiredVariables := []string{"name", "type", "device_id"}
+
 	webServerRequiredVariables := []string{"port"}
 
 	viper := viperLib.New()
@@ -75,7 +218,12 @@ func ReadConfig() (Config, error) {
 				keys[key_name] = true
 			}
 
-			for _, requiredDeviceKey := range tuyaDevicesRequiredVariables {
+			requiredDeviceKeys := tuyaDevicesRequiredVariables
+			if deviceType, ok := deviceInfoValueMap["type"].(string); ok && deviceType == "simulated" {
+				requiredDeviceKeys = simulatedDevicesRequiredVariables
+			}
+
+			for _, requiredDeviceKey := range requiredDeviceKeys {
 				if _, ok := keys[requiredDeviceKey]; !ok {
 					return config, errors.New("Fatal error config: device " + deviceKey + " has no " + requiredDeviceKey + ".")
 				} else {
@@ -104,6 +252,21 @@ func ReadConfig() (Config, error) {
 				}
 			}
 
+			if device.DeviceType == "simulated" {
+				prefix := "tuya_devices." + deviceKey + "."
+				device.Simulator = SimulatorConfig{Mode: viper.GetString(prefix + "mode"), Sensors: viper.GetStringSlice(prefix + "sensors"), FailureRate: viper.GetFloat64(prefix + "failure_rate")}
+				if viper.IsSet(prefix + "latency") {
+					value, parseErr := time.ParseDuration(viper.GetString(prefix + "latency"))
+					if parseErr != nil {
+						return config, errors.New("Fatal error config: device " + deviceKey + " latency is not a valid duration.")
+					}
+					device.Simulator.Latency = value
+				}
+				if device.Simulator.FailureRate < 0 || device.Simulator.FailureRate > 1 {
+					return config, errors.New("Fatal error config: device " + deviceKey + " failure_rate must be between 0 and 1.")
+				}
+			}
+
 			deviceNames[device.Name] = true
 			deviceIDs[device.DeviceID] = true
 			devices[device.Name] = device
@@ -111,11 +274,372 @@ func ReadConfig() (Config, error) {
 	}
 	config.Devices = devices
 
-	for _, webServerVariable := range webServerRequiredVariables {
-		if !viper.IsSet("web_server." + webServerVariable) {
-			return config, errors.New("Fatal error config: no web_server " + webServerVariable + " was found.")
+	// groups section is optional, members are referenced by device name
+	groups := make(map[string]GroupConfig)
+	for groupKey := range viper.GetStringMap("groups") {
+		var group GroupConfig
+		group.Name = viper.GetString("groups." + groupKey + ".name")
+		if group.Name == "" {
+			group.Name = groupKey
+		}
+		memberNames := viper.GetStringSlice("groups." + groupKey + ".devices")
+		if len(memberNames) == 0 {
+			return config, errors.New("Fatal error config: group " + groupKey + " has no devices.")
+		}
+		for _, memberName := range memberNames {
+			member, ok := devices[memberName]
+			if !ok {
+				return config, errors.New("Fatal error config: group " + groupKey + " device '" + memberName + "' does not exist.")
+			}
+			group.DeviceIDs = append(group.DeviceIDs, member.DeviceID)
+		}
+		groups[groupKey] = group
+	}
+	config.Groups = groups
+
+	// schedules target either a device, referenced by name, or a group
+	config.Holidays = viper.GetStringSlice("scheduler.holidays")
+	schedules := make(map[string]ScheduleConfig)
+	for scheduleKey := range viper.GetStringMap("schedules") {
+		prefix := "schedules." + scheduleKey + "."
+		schedule := ScheduleConfig{Cron: viper.GetString(prefix + "cron"), TimeZone: viper.GetString(prefix + "time_zone"), GroupID: viper.GetString(prefix + "group"), Mode: viper.GetString(prefix + "mode"), Rollback: viper.GetBool(prefix + "rollback"), Holidays: viper.GetStringSlice(prefix + "holidays")}
+		for _, requiredScheduleKey := range []string{"cron", "mode"} {
+			if !viper.IsSet(prefix + requiredScheduleKey) {
+				return config, errors.New("Fatal error config: schedule " + scheduleKey + " has no " + requiredScheduleKey + ".")
+			}
+		}
+		if deviceName := viper.GetString(prefix + "device"); deviceName != "" {
+			device, ok := devices[deviceName]
+			if !ok {
+				return config, errors.New("Fatal error config: schedule " + scheduleKey + " device '" + deviceName + "' does not exist.")
+			}
+			schedule.DeviceID = device.DeviceID
+		}
+		if (schedule.DeviceID == "") == (schedule.GroupID == "") {
+			return config, errors.New("Fatal error config: schedule " + scheduleKey + " must have either a device or a group.")
+		}
+		if _, ok := groups[schedule.GroupID]; schedule.GroupID != "" && !ok {
+			return config, errors.New("Fatal error config: schedule " + scheduleKey + " group '" + schedule.GroupID + "' does not exist.")
+		}
+		schedules[scheduleKey] = schedule
+	}
+	config.Schedules = schedules
+
+	// rules reference devices by name, trigger and actions are validated by the rules engine
+	rules := make(map[string]RuleConfig)
+	for ruleKey := range viper.GetStringMap("rules") {
+		prefix := "rules." + ruleKey + "."
+		if !viper.IsSet(prefix + "trigger") {
+			return config, errors.New("Fatal error config: rule " + ruleKey + " has no trigger.")
+		}
+		rule := RuleConfig{Trigger: viper.GetString(prefix + "trigger"), TimeWindow: viper.GetString(prefix + "time_window"), TimeZone: viper.GetString(prefix + "time_zone"), Mode: viper.GetString(prefix + "mode"), DryRun: viper.GetBool(prefix + "dry_run")}
+		if viper.IsSet(prefix + "for") {
+			value, parseErr := time.ParseDuration(viper.GetString(prefix + "for"))
+			if parseErr != nil {
+				return config, errors.New("Fatal error config: rule " + ruleKey + " for is not a valid duration.")
+			}
+			rule.For = value
+		}
+		deviceIDs := map[string]*string{"device": &rule.DeviceID, "mode_device": &rule.ModeDeviceID}
+		for deviceKey, deviceID := range deviceIDs {
+			if deviceName := viper.GetString(prefix + deviceKey); deviceName != "" {
+				device, ok := devices[deviceName]
+				if !ok {
+					return config, errors.New("Fatal error config: rule " + ruleKey + " " + deviceKey + " '" + deviceName + "' does not exist.")
+				}
+				*deviceID = device.DeviceID
+			}
+		}
+		actions, _ := viper.Get(prefix + "actions").([]interface{})
+		if len(actions) == 0 {
+			return config, errors.New("Fatal error config: rule " + ruleKey + " has no actions.")
+		}
+		for _, actionValue := range actions {
+			actionMap, ok := actionValue.(map[string]interface{})
+			if !ok {
+				return config, errors.New("Fatal error config: rule " + ruleKey + " actions must be tables.")
+			}
+			actionString := func(key string) string {
+				value, _ := actionMap[key].(string)
+				return value
+			}
+			action := RuleActionConfig{Type: actionString("type"), GroupID: actionString("group"), Mode: actionString("mode"), URL: actionString("url"), Message: actionString("message")}
+			if deviceName := actionString("device"); deviceName != "" {
+				device, ok := devices[deviceName]
+				if !ok {
+					return config, errors.New("Fatal error config: rule " + ruleKey + " action device '" + deviceName + "' does not exist.")
+				}
+				action.DeviceID = device.DeviceID
+			}
+			if _, ok := groups[action.GroupID]; action.GroupID != "" && !ok {
+				return config, errors.New("Fatal error config: rule " + ruleKey + " action group '" + action.GroupID + "' does not exist.")
+			}
+			rule.Actions = append(rule.Actions, action)
+		}
+		rules[ruleKey] = rule
+	}
+	config.Rules = rules
+
+	// notification channel types and templates are validated by the notifier
+	notificationChannels := make(map[string]NotificationChannelConfig)
+	for channelKey := range viper.GetStringMap("notifications.channels") {
+		prefix := "notifications.channels." + channelKey + "."
+		if !viper.IsSet(prefix + "type") {
+			return config, errors.New("Fatal error config: notification channel " + channelKey + " has no type.")
+		}
+		notificationChannels[channelKey] = NotificationChannelConfig{Type: viper.GetString(prefix + "type"), Host: viper.GetString(prefix + "host"), Port: viper.GetInt(prefix + "port"), Username: viper.GetString(prefix + "username"), Password: viper.GetString(prefix + "password"), From: viper.GetString(prefix + "from"), To: viper.GetStringSlice(prefix + "to"), URL: viper.GetString(prefix + "url"), Token: viper.GetString(prefix + "token"), Priority: viper.GetInt(prefix + "priority"), Template: viper.GetString(prefix + "template"), ContentType: viper.GetString(prefix + "content_type")}
+	}
+	config.NotificationChannels = notificationChannels
+	notificationRoutes, _ := viper.Get("notifications.routes").([]interface{})
+	for routeIndex, routeValue := range notificationRoutes {
+		routeMap, ok := routeValue.(map[string]interface{})
+		if !ok {
+			return config, errors.New("Fatal error config: notification routes must be tables.")
+		}
+		routeStrings := func(key string) []string {
+			var values []string
+			items, _ := routeMap[key].([]interface{})
+			for _, item := range items {
+				if value, ok := item.(string); ok {
+					values = append(values, value)
+				}
+			}
+			return values
+		}
+		title, _ := routeMap["title"].(string)
+		template, _ := routeMap["template"].(string)
+		route := NotificationRouteConfig{EventTypes: routeStrings("events"), Channels: routeStrings("channels"), Title: title, Template: template}
+		for _, deviceName := range routeStrings("devices") {
+			device, ok := devices[deviceName]
+			if !ok {
+				return config, errors.New("Fatal error config: notification route " + strconv.Itoa(routeIndex+1) + " device '" + deviceName + "' does not exist.")
+			}
+			route.DeviceIDs = append(route.DeviceIDs, device.DeviceID)
+		}
+		for _, channelName := range route.Channels {
+			if _, ok := notificationChannels[channelName]; !ok {
+				return config, errors.New("Fatal error config: notification route " + strconv.Itoa(routeIndex+1) + " channel '" + channelName + "' does not exist.")
+			}
+		}
+		config.NotificationRoutes = append(config.NotificationRoutes, route)
+	}
+
+	// escalations notify notification channels
+	escalations := make(map[string]EscalationConfig)
+	for escalationKey := range viper.GetStringMap("escalations") {
+		prefix := "escalations." + escalationKey + "."
+		escalation := EscalationConfig{First: viper.GetStringSlice(prefix + "first"), Second: viper.GetStringSlice(prefix + "second")}
+		for _, deviceName := range viper.GetStringSlice(prefix + "devices") {
+			device, ok := devices[deviceName]
+			if !ok {
+				return config, errors.New("Fatal error config: escalation " + escalationKey + " device '" + deviceName + "' does not exist.")
+			}
+			escalation.DeviceIDs = append(escalation.DeviceIDs, device.DeviceID)
+		}
+		for _, channelName := range append(append([]string{}, escalation.First...), escalation.Second...) {
+			if _, ok := notificationChannels[channelName]; !ok {
+				return config, errors.New("Fatal error config: escalation " + escalationKey + " channel '" + channelName + "' does not exist.")
+			}
+		}
+		escalationDurations := map[string]*time.Duration{"escalate_after": &escalation.EscalateAfter, "repeat_every": &escalation.RepeatEvery}
+		for durationName, duration := range escalationDurations {
+			if viper.IsSet(prefix + durationName) {
+				value, parseErr := time.ParseDuration(viper.GetString(prefix + durationName))
+				if parseErr != nil {
+					return config, errors.New("Fatal error config: escalation " + escalationKey + " " + durationName + " is not a valid duration.")
+				}
+				*duration = value
+			}
+		}
+		escalations[escalationKey] = escalation
+	}
+	config.Escalations = escalations
+
+	// tuya_budgets section is optional, budgets are referenced by client ID
+	budgets := make(map[string]BudgetConfig)
+	for budgetKey := range viper.GetStringMap("tuya_budgets") {
+		prefix := "tuya_budgets." + budgetKey + "."
+		clientID := viper.GetString(prefix + "client_id")
+		if clientID == "" {
+			return config, errors.New("Fatal error config: budget " + budgetKey + " has no client_id.")
+		}
+		if _, ok := budgets[clientID]; ok {
+			return config, errors.New("Fatal error config: budget client_id '" + clientID + "' is repeated.")
+		}
+		budget := BudgetConfig{RequestsPerSecond: viper.GetFloat64(prefix + "requests_per_second"), DailyLimit: viper.GetInt(prefix + "daily_limit"), MonthlyLimit: viper.GetInt(prefix + "monthly_limit")}
+		if budget.RequestsPerSecond < 0 || budget.DailyLimit < 0 || budget.MonthlyLimit < 0 {
+			return config, errors.New("Fatal error config: budget " + budgetKey + " limits can't be negative.")
+		}
+		budgets[clientID] = budget
+	}
+	config.Budgets = budgets
+
+	// polling section is optional
+	config.PollInterval = 20 * time.Second
+	if viper.IsSet("polling.interval") {
+		value, parseErr := time.ParseDuration(viper.GetString("polling.interval"))
+		if parseErr != nil || value <= 0 {
+			return config, errors.New("Fatal error config: polling interval is not a valid duration.")
+		}
+		config.PollInterval = value
+	}
+
+	// listeners replace port, which listens on every interface
+	webListeners, _ := viper.Get("web_server.listeners").([]interface{})
+	if len(webListeners) == 0 {
+		for _, webServerVariable := range webServerRequiredVariables {
+			if !viper.IsSet("web_server." + webServerVariable) {
+				return config, errors.New("Fatal error config: no web_server " + webServerVariable + " was found.")
+			}
 		}
 	}
 	config.WebPort = viper.GetInt("web_server.port")
+	if len(webListeners) == 0 {
+		config.Listeners = []ListenerConfig{{Type: listeners.TCPListener, Address: ":" + strconv.Itoa(config.WebPort)}}
+	}
+	for _, listenerValue := range webListeners {
+		listenerMap, ok := listenerValue.(map[string]interface{})
+		if !ok {
+			return config, errors.New("Fatal error config: web_server listeners must be tables.")
+		}
+		var listener ListenerConfig
+		listener.Type, _ = listenerMap["type"].(string)
+		listener.Address, _ = listenerMap["address"].(string)
+		listener.Path, _ = listenerMap["path"].(string)
+		listener.Owner, _ = listenerMap["owner"].(string)
+		listener.Group, _ = listenerMap["group"].(string)
+		switch listener.Type {
+		case listeners.TCPListener:
+			if listener.Address == "" {
+				return config, errors.New("Fatal error config: web_server tcp listener has no address.")
+			}
+		case listeners.UnixListener:
+			if listener.Path == "" {
+				return config, errors.New("Fatal error config: web_server unix listener has no path.")
+			}
+			if mode, ok := listenerMap["mode"].(string); ok {
+				value, parseErr := strconv.ParseUint(mode, 8, 32)
+				if parseErr != nil || value > 0777 {
+					return config, errors.New("Fatal error config: web_server unix listener mode '" + mode + "' is not valid.")
+				}
+				listener.Mode = os.FileMode(value)
+			}
+		case listeners.SystemdListener:
+		default:
+			return config, errors.New("Fatal error config: web_server listener type '" + listener.Type + "' is not valid.")
+		}
+		config.Listeners = append(config.Listeners, listener)
+	}
+
+	// TLS is optional, clients are authenticated when client_ca is set
+	config.TLS = TLSConfig{CertFile: viper.GetString("web_server.tls_cert"), KeyFile: viper.GetString("web_server.tls_key"), MinVersion: webtls.Versions["1.2"], ClientCAFile: viper.GetString("web_server.client_ca"), Identities: make(map[string]string)}
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return config, errors.New("Fatal error config: web_server tls_cert and tls_key must be set together.")
+	}
+	if viper.IsSet("web_server.tls_min_version") {
+		minVersion, ok := webtls.Versions[viper.GetString("web_server.tls_min_version")]
+		if !ok {
+			return config, errors.New("Fatal error config: web_server tls_min_version '" + viper.GetString("web_server.tls_min_version") + "' is not valid.")
+		}
+		config.TLS.MinVersion = minVersion
+	}
+	if config.TLS.ClientCAFile != "" && config.TLS.CertFile == "" {
+		return config, errors.New("Fatal error config: web_server client_ca requires tls_cert and tls_key.")
+	}
+	// clients are tables, identities may contain dots
+	webClients, _ := viper.Get("web_server.clients").([]interface{})
+	if len(webClients) > 0 && config.TLS.ClientCAFile == "" {
+		return config, errors.New("Fatal error config: web_server clients require client_ca.")
+	}
+	for _, clientValue := range webClients {
+		clientMap, ok := clientValue.(map[string]interface{})
+		if !ok {
+			return config, errors.New("Fatal error config: web_server clients must be tables.")
+		}
+		identity, _ := clientMap["identity"].(string)
+		permission, _ := clientMap["permission"].(string)
+		if identity == "" {
+			return config, errors.New("Fatal error config: web_server client has no identity.")
+		}
+		if permission != webtls.ReadPermission && permission != webtls.WritePermission {
+			return config, errors.New("Fatal error config: web_server client '" + identity + "' permission '" + permission + "' is not valid.")
+		}
+		config.TLS.Identities[identity] = permission
+	}
+	config.WebPIN = viper.GetString("web_server.pin")
+
+	// mode_change section is optional
+	durations := map[string]*time.Duration{"confirmation_timeout": &config.ConfirmationTimeout, "confirmation_interval": &config.ConfirmationInterval}
+	for durationName, duration := range durations {
+		if viper.IsSet("mode_change." + durationName) {
+			value, parseErr := time.ParseDuration(viper.GetString("mode_change." + durationName))
+			if parseErr != nil {
+				return config, errors.New("Fatal error config: mode_change " + durationName + " is not a valid duration.")
+			}
+			if value <= 0 {
+				return config, errors.New("Fatal error config: mode_change " + durationName + " must be greater than zero.")
+			}
+			*duration = value
+		}
+	}
+
+	// connectivity section is optional
+	if viper.IsSet("connectivity.offline_debounce") {
+		value, parseErr := time.ParseDuration(viper.GetString("connectivity.offline_debounce"))
+		if parseErr != nil {
+			return config, errors.New("Fatal error config: connectivity offline_debounce is not a valid duration.")
+		}
+		config.OfflineDebounce = value
+	}
+
+	// shutdown section is optional
+	config.ShutdownTimeout = 20 * time.Second
+	if viper.IsSet("shutdown.timeout") {
+		value, parseErr := time.ParseDuration(viper.GetString("shutdown.timeout"))
+		if parseErr != nil {
+			return config, errors.New("Fatal error config: shutdown timeout is not a valid duration.")
+		}
+		if value <= 0 {
+			return config, errors.New("Fatal error config: shutdown timeout must be greater than zero.")
+		}
+		config.ShutdownTimeout = value
+	}
+
+	// logging section is optional, entries go to syslog by default
+	config.Logging = LoggingConfig{Level: logging.InfoLevel, Levels: make(map[string]logging.Level), Format: logging.LogfmtFormat, Syslog: true}
+	if viper.IsSet("logging.level") {
+		level, levelErr := logging.ParseLevel(viper.GetString("logging.level"))
+		if levelErr != nil {
+			return config, errors.New("Fatal error config: logging level '" + viper.GetString("logging.level") + "' is not valid.")
+		}
+		config.Logging.Level = level
+	}
+	for subsystem, value := range viper.GetStringMapString("logging.levels") {
+		level, levelErr := logging.ParseLevel(value)
+		if levelErr != nil {
+			return config, errors.New("Fatal error config: logging level '" + value + "' of " + subsystem + " is not valid.")
+		}
+		config.Logging.Levels[subsystem] = level
+	}
+	if viper.IsSet("logging.format") {
+		format, formatErr := logging.ParseFormat(viper.GetString("logging.format"))
+		if formatErr != nil {
+			return config, errors.New("Fatal error config: logging format '" + viper.GetString("logging.format") + "' is not valid.")
+		}
+		config.Logging.Format = format
+	}
+	if viper.IsSet("logging.outputs") {
+		config.Logging.Syslog = false
+		for _, output := range viper.GetStringSlice("logging.outputs") {
+			switch output {
+			case "syslog":
+				config.Logging.Syslog = true
+			case "stderr":
+				config.Logging.Stderr = true
+			default:
+				return config, errors.New("Fatal error config: logging output '" + output + "' is not valid.")
+			}
+		}
+	}
 	return config, nil
 }
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"crypto/tls"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/logging"
 )
 
 func TestProcessNoConfigFilePresent(t *testing.T) {
@@ -84,3 +88,367 @@ func TestProcessConfigOK(t *testing.T) {
 		t.Errorf("ReadConfig method without tuya devices should not fail.")
 	}
 }
+
+func TestProcessConfigModeChange(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_mode_change/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Errorf("ReadConfig method with mode_change section should not fail, error was '%s'.", err.Error())
+	}
+	if config.ConfirmationTimeout != 8*time.Second {
+		t.Errorf("Confirmation timeout should be 8s, not %s.", config.ConfirmationTimeout)
+	}
+	if config.ConfirmationInterval != 250*time.Millisecond {
+		t.Errorf("Confirmation interval should be 250ms, not %s.", config.ConfirmationInterval)
+	}
+	if config.OfflineDebounce != 2*time.Minute {
+		t.Errorf("Offline debounce should be 2m, not %s.", config.OfflineDebounce)
+	}
+}
+
+func TestProcessConfigInvalidModeChange(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_invalid_mode_change/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with invalid confirmation_timeout should fail.")
+	} else {
+		if err.Error() != "Fatal error config: mode_change confirmation_timeout is not a valid duration." {
+			t.Errorf("Error should be \"Fatal error config: mode_change confirmation_timeout is not a valid duration.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigNegativeModeChange(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_negative_mode_change/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with negative confirmation_interval should fail.")
+	} else {
+		if err.Error() != "Fatal error config: mode_change confirmation_interval must be greater than zero." {
+			t.Errorf("Error should be \"Fatal error config: mode_change confirmation_interval must be greater than zero.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigGroups(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_groups/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Errorf("ReadConfig method with groups should not fail, error was '%s'.", err.Error())
+	}
+	group, ok := config.Groups["premises"]
+	if !ok {
+		t.Fatalf("Group premises should have been read.")
+	}
+	if group.Name != "Premises" {
+		t.Errorf("Group name should be 'Premises', not '%s'.", group.Name)
+	}
+	if len(group.DeviceIDs) != 2 || group.DeviceIDs[0] != "device123" || group.DeviceIDs[1] != "device1234" {
+		t.Errorf("Group devices should be [device123 device1234], not %v.", group.DeviceIDs)
+	}
+}
+
+func TestProcessConfigGroupUnknownDevice(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_group_unknown_device/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with unknown group device should fail.")
+	} else {
+		if err.Error() != "Fatal error config: group premises device 'Garage Alarm' does not exist." {
+			t.Errorf("Error should be \"Fatal error config: group premises device 'Garage Alarm' does not exist.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigSchedules(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_schedules/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with schedules should not fail, error was '%s'.", err.Error())
+	}
+	if len(config.Holidays) != 2 {
+		t.Errorf("Two holidays should have been read, not %d.", len(config.Holidays))
+	}
+	officeArm := config.Schedules["office_arm"]
+	if officeArm.DeviceID != "device1234" || officeArm.Cron != "0 20 * * 1-5" || officeArm.TimeZone != "Europe/Madrid" || officeArm.Mode != "Armed" {
+		t.Errorf("Schedule office_arm has not been read properly: %+v.", officeArm)
+	}
+	premisesDisarm := config.Schedules["premises_disarm"]
+	if premisesDisarm.GroupID != "premises" || len(premisesDisarm.Holidays) != 1 {
+		t.Errorf("Schedule premises_disarm has not been read properly: %+v.", premisesDisarm)
+	}
+}
+
+func TestProcessConfigScheduleNoTarget(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_schedule_no_target/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with schedule without target should fail.")
+	} else {
+		if err.Error() != "Fatal error config: schedule office_arm must have either a device or a group." {
+			t.Errorf("Error should be \"Fatal error config: schedule office_arm must have either a device or a group.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigRules(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_rules/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with rules should not fail, error was '%s'.", err.Error())
+	}
+	lockOffice := config.Rules["lock_office"]
+	if lockOffice.Trigger != "firing_started" || lockOffice.DeviceID != "device123" || lockOffice.ModeDeviceID != "device1234" || lockOffice.TimeWindow != "22:00-07:00" || lockOffice.Mode != "Armed" {
+		t.Errorf("Rule lock_office has not been read properly: %+v.", lockOffice)
+	}
+	if len(lockOffice.Actions) != 2 || lockOffice.Actions[0].DeviceID != "device1234" || lockOffice.Actions[1].URL != "http://localhost:8080/hook" {
+		t.Errorf("Rule lock_office actions have not been read properly: %+v.", lockOffice.Actions)
+	}
+	homeOffline := config.Rules["home_offline"]
+	if homeOffline.For != 5*time.Minute || !homeOffline.DryRun || homeOffline.Actions[0].Type != "notify" {
+		t.Errorf("Rule home_offline has not been read properly: %+v.", homeOffline)
+	}
+}
+
+func TestProcessConfigRuleNoActions(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_rule_no_actions/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with rule without actions should fail.")
+	} else {
+		if err.Error() != "Fatal error config: rule lock_office has no actions." {
+			t.Errorf("Error should be \"Fatal error config: rule lock_office has no actions.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigNotifications(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_notifications/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with notifications should not fail, error was '%s'.", err.Error())
+	}
+	adminEmail := config.NotificationChannels["admin_email"]
+	if adminEmail.Type != "email" || adminEmail.Port != 587 || len(adminEmail.To) != 1 {
+		t.Errorf("Channel admin_email has not been read properly: %+v.", adminEmail)
+	}
+	if phone := config.NotificationChannels["phone"]; phone.Type != "ntfy" || phone.Priority != 5 {
+		t.Errorf("Channel phone has not been read properly: %+v.", phone)
+	}
+	if len(config.NotificationRoutes) != 2 {
+		t.Fatalf("Two notification routes should have been read, not %d.", len(config.NotificationRoutes))
+	}
+	batteryRoute := config.NotificationRoutes[1]
+	if len(batteryRoute.DeviceIDs) != 1 || batteryRoute.DeviceIDs[0] != "device1234" || batteryRoute.Template != "Replace {{.Device}} battery." {
+		t.Errorf("Battery route has not been read properly: %+v.", batteryRoute)
+	}
+	intrusion := config.Escalations["intrusion"]
+	if len(intrusion.DeviceIDs) != 1 || intrusion.First[0] != "phone" || intrusion.Second[0] != "admin_email" || intrusion.EscalateAfter != 5*time.Minute || intrusion.RepeatEvery != 10*time.Minute {
+		t.Errorf("Escalation intrusion has not been read properly: %+v.", intrusion)
+	}
+}
+
+func TestProcessConfigNotificationUnknownChannel(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_notification_unknown_channel/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with unknown notification channel should fail.")
+	} else {
+		if err.Error() != "Fatal error config: notification route 1 channel 'phone' does not exist." {
+			t.Errorf("Error should be \"Fatal error config: notification route 1 channel 'phone' does not exist.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigSimulated(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_simulated/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with simulated device should not fail, error was '%s'.", err.Error())
+	}
+	simulated := config.Devices["Test Alarm"]
+	if simulated.DeviceType != "simulated" || simulated.DeviceID != "simulated1" || simulated.Host != "" {
+		t.Errorf("Simulated device has not been read properly: %+v.", simulated)
+	}
+	if simulated.Simulator.Mode != "arm" || len(simulated.Simulator.Sensors) != 2 || simulated.Simulator.Latency != 200*time.Millisecond || simulated.Simulator.FailureRate != 0.1 {
+		t.Errorf("Simulator of device has not been read properly: %+v.", simulated.Simulator)
+	}
+}
+
+func TestProcessConfigSimulatedInvalidLatency(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_simulated_invalid_latency/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with invalid simulated latency should fail.")
+	} else {
+		if err.Error() != "Fatal error config: device test_alarm latency is not a valid duration." {
+			t.Errorf("Error should be \"Fatal error config: device test_alarm latency is not a valid duration.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigBudgets(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_budgets/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with budgets should not fail, error was '%s'.", err.Error())
+	}
+	budget, ok := config.Budgets["Id123"]
+	if !ok || budget.RequestsPerSecond != 2.5 || budget.DailyLimit != 800 || budget.MonthlyLimit != 25000 {
+		t.Errorf("Budget has not been read properly: %+v.", config.Budgets)
+	}
+	if config.PollInterval != 30*time.Second {
+		t.Errorf("Poll interval should be 30s, it was %s.", config.PollInterval)
+	}
+	if config.ShutdownTimeout != 20*time.Second {
+		t.Errorf("Shutdown timeout should default to 20s, it was %s.", config.ShutdownTimeout)
+	}
+}
+
+func TestProcessConfigBudgetNegative(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_budget_negative/")
+	config, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with negative budget limit should fail.")
+	} else {
+		if err.Error() != "Fatal error config: budget home limits can't be negative." {
+			t.Errorf("Error should be \"Fatal error config: budget home limits can't be negative.\" but error was '%s'.", err.Error())
+		}
+	}
+	if len(config.Budgets) != 0 {
+		t.Errorf("Budgets should not be returned when config fails.")
+	}
+}
+
+func TestProcessConfigShutdown(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_shutdown/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with shutdown timeout should not fail, error was '%s'.", err.Error())
+	}
+	if config.ShutdownTimeout != 45*time.Second {
+		t.Errorf("Shutdown timeout should be 45s, it was %s.", config.ShutdownTimeout)
+	}
+}
+
+func TestProcessConfigZeroShutdownTimeout(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_zero_shutdown_timeout/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with zero shutdown timeout should fail.")
+	} else {
+		if err.Error() != "Fatal error config: shutdown timeout must be greater than zero." {
+			t.Errorf("Error should be \"Fatal error config: shutdown timeout must be greater than zero.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigLogging(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_logging/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with logging should not fail, error was '%s'.", err.Error())
+	}
+	if config.Logging.Level != logging.WarnLevel || config.Logging.Format != logging.JSONFormat || !config.Logging.Syslog || !config.Logging.Stderr {
+		t.Errorf("Logging has not been read properly: %+v.", config.Logging)
+	}
+	if level, ok := config.Logging.Levels["tuya"]; !ok || level != logging.DebugLevel {
+		t.Errorf("Subsystem levels have not been read properly: %+v.", config.Logging.Levels)
+	}
+}
+
+func TestProcessConfigLoggingDefaults(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_shutdown/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method should not fail, error was '%s'.", err.Error())
+	}
+	if config.Logging.Level != logging.InfoLevel || config.Logging.Format != logging.LogfmtFormat || !config.Logging.Syslog || config.Logging.Stderr {
+		t.Errorf("Logging should default to info entries in logfmt sent to syslog: %+v.", config.Logging)
+	}
+}
+
+func TestProcessConfigLoggingInvalidLevel(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_logging_invalid_level/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with invalid logging level should fail.")
+	} else {
+		if err.Error() != "Fatal error config: logging level 'verbose' of tuya is not valid." {
+			t.Errorf("Error should be \"Fatal error config: logging level 'verbose' of tuya is not valid.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigTLS(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_tls/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with TLS should not fail, error was '%s'.", err.Error())
+	}
+	if config.TLS.CertFile != "/etc/windmaker-alarmmanager/server.crt" || config.TLS.KeyFile != "/etc/windmaker-alarmmanager/server.key" || config.TLS.ClientCAFile != "/etc/windmaker-alarmmanager/clients.pem" || config.TLS.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TLS has not been read properly: %+v.", config.TLS)
+	}
+	if len(config.TLS.Identities) != 2 || config.TLS.Identities["kitchen-panel.home"] != "read" || config.TLS.Identities["home-assistant"] != "write" {
+		t.Errorf("Client identities have not been read properly: %+v.", config.TLS.Identities)
+	}
+	if config.WebPIN != "4721" {
+		t.Errorf("Web PIN should be '4721', it was '%s'.", config.WebPIN)
+	}
+}
+
+func TestProcessConfigTLSInvalidPermission(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_tls_invalid_permission/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with invalid client permission should fail.")
+	} else {
+		if err.Error() != "Fatal error config: web_server client 'kitchen-panel' permission 'admin' is not valid." {
+			t.Errorf("Error should be \"Fatal error config: web_server client 'kitchen-panel' permission 'admin' is not valid.\" but error was '%s'.", err.Error())
+		}
+	}
+}
+
+func TestProcessConfigListeners(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_listeners/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method with listeners should not fail, error was '%s'.", err.Error())
+	}
+	expected := []ListenerConfig{
+		{Type: "tcp", Address: "127.0.0.1:3000"},
+		{Type: "unix", Path: "/run/windmaker-alarmmanager/api.sock", Mode: 0660, Owner: "nobody", Group: "www-data"},
+		{Type: "systemd"},
+	}
+	if len(config.Listeners) != len(expected) {
+		t.Fatalf("Listeners have not been read properly: %+v.", config.Listeners)
+	}
+	for i := range expected {
+		if config.Listeners[i] != expected[i] {
+			t.Errorf("Listener %d should be %+v, it was %+v.", i, expected[i], config.Listeners[i])
+		}
+	}
+}
+
+func TestProcessConfigListenersDefault(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_shutdown/")
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig method should not fail, error was '%s'.", err.Error())
+	}
+	if len(config.Listeners) != 1 || config.Listeners[0] != (ListenerConfig{Type: "tcp", Address: ":3000"}) {
+		t.Errorf("Port should be served on every interface without listeners: %+v.", config.Listeners)
+	}
+}
+
+func TestProcessConfigListenerInvalidMode(t *testing.T) {
+	os.Setenv("ALARM_MANAGER_CONFIG_FILE_LOCATION", "./config_files_test/config_listener_invalid_mode/")
+	_, err := ReadConfig()
+	if err == nil {
+		t.Errorf("ReadConfig method with invalid socket mode should fail.")
+	} else {
+		if err.Error() != "Fatal error config: web_server unix listener mode 'rw-rw----' is not valid." {
+			t.Errorf("Error should be \"Fatal error config: web_server unix listener mode 'rw-rw----' is not valid.\" but error was '%s'.", err.Error())
+		}
+	}
+}
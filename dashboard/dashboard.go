@@ -0,0 +1,23 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Redirect sends /dashboard to the dashboard index page.
+func Redirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/dashboard/", http.StatusMovedPermanently)
+}
+
+// Handler serves the dashboard, it must be mounted under /dashboard/. The
+// dashboard reads and changes device state through the API, so it is
+// subject to the same client authorization and PIN.
+func Handler() http.Handler {
+	files, _ := fs.Sub(static, "static")
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,264 @@
+"use strict";
+
+// Status is long-polled, requests return as soon as a device changes.
+// Browsers only open a few connections per server, with more devices their
+// status is polled instead.
+const statusWait = "30s";
+const maxLongPolls = 4;
+const pollDelay = 10000;
+const retryDelay = 5000;
+const eventLimit = 15;
+
+const cards = {};
+// readOnly and pinRequired are read from /whoami before devices are shown,
+// pin is asked for the first mode change and forgotten when it is refused.
+let readOnly = false;
+let pinRequired = false;
+let pin = "";
+// hasSimulator is cleared when /simulator is not served, it is only served
+// when there are simulated devices.
+let hasSimulator = true;
+
+function sleep(milliseconds) {
+  return new Promise((resolve) => setTimeout(resolve, milliseconds));
+}
+
+// api sends a request to the AlarmManager API and decodes its JSON body.
+async function api(path, options = {}) {
+  const response = await fetch(path, Object.assign({ cache: "no-store" }, options));
+  let body = null;
+  if (response.status !== 304) {
+    try {
+      body = await response.json();
+    } catch (error) {
+      body = null;
+    }
+  }
+  if (response.status === 401) {
+    showNotice(body && body.msg ? body.msg : "Client certificate is required.");
+  }
+  return { status: response.status, etag: response.headers.get("ETag"), body: body };
+}
+
+function showNotice(text) {
+  const notice = document.getElementById("notice");
+  notice.textContent = text;
+  notice.hidden = false;
+}
+
+function setConnection(live) {
+  const badge = document.getElementById("connection");
+  badge.textContent = live ? "Live" : "Disconnected";
+  badge.className = "badge " + (live ? "online" : "offline");
+}
+
+function createCard(deviceID, name) {
+  const card = document.getElementById("device-template").content.firstElementChild.cloneNode(true);
+  card.querySelector(".name").textContent = name;
+  card.querySelector(".mode").textContent = "unknown";
+  for (const button of card.querySelectorAll("button")) {
+    button.addEventListener("click", () => changeMode(deviceID, button.dataset.mode));
+  }
+  document.getElementById("devices").appendChild(card);
+  cards[deviceID] = { element: card, capabilities: null, sensors: null, reason: "", busy: false, unavailable: false };
+  return card;
+}
+
+function render(deviceID, status) {
+  const card = cards[deviceID];
+  const element = card.element;
+  const mode = element.querySelector(".mode");
+  mode.textContent = status.mode;
+  mode.className = "mode badge " + status.mode;
+  element.classList.toggle("firing", status.firing);
+  const firing = element.querySelector(".firing");
+  firing.textContent = status.firing ? "Firing" : "Quiet";
+  firing.className = "firing" + (status.firing ? " alarm" : "");
+  element.querySelector(".online").textContent = status.online ? "Online" : "Offline";
+  let power = status.power_source === "unknown" ? "Not reported" : "On " + status.power_source;
+  if (status.low_battery) {
+    power += ", battery low";
+  }
+  element.querySelector(".power").textContent = power;
+  if (status.capabilities) {
+    card.capabilities = status.capabilities;
+  }
+  card.reason = status.reason || "";
+  renderSensors(deviceID);
+  renderButtons(deviceID);
+}
+
+// renderButtons disables mode buttons while a change is being sent, when
+// the device mode can't be changed or when the client can only read.
+function renderButtons(deviceID) {
+  const card = cards[deviceID];
+  const canChangeMode = !readOnly && (!card.capabilities || card.capabilities.includes("mode_control"));
+  for (const button of card.element.querySelectorAll("button")) {
+    button.disabled = card.busy || !canChangeMode;
+  }
+}
+
+function renderSensors(deviceID) {
+  const card = cards[deviceID];
+  let text = "Not reported";
+  if (card.sensors && Object.keys(card.sensors).length > 0) {
+    text = Object.keys(card.sensors).map((sensor) => sensor + ": " + card.sensors[sensor]).join(", ");
+  } else if (card.reason) {
+    text = "Last alarm: " + card.reason;
+  }
+  card.element.querySelector(".sensors").textContent = text;
+}
+
+// refreshSensors reads sensor states, only simulated devices report them.
+async function refreshSensors() {
+  if (!hasSimulator) {
+    return;
+  }
+  const response = await api("/simulator/");
+  if (response.status === 404) {
+    hasSimulator = false;
+  }
+  if (response.status !== 200 || !response.body) {
+    return;
+  }
+  for (const deviceID of Object.keys(response.body.data || {})) {
+    if (cards[deviceID]) {
+      cards[deviceID].sensors = response.body.data[deviceID].sensors;
+      renderSensors(deviceID);
+    }
+  }
+}
+
+async function refreshEvents() {
+  const response = await api("/events/?limit=" + eventLimit);
+  if (response.status !== 200 || !response.body) {
+    return;
+  }
+  const list = document.getElementById("events");
+  list.replaceChildren();
+  for (const event of response.body.data) {
+    const item = document.createElement("li");
+    const time = document.createElement("time");
+    time.dateTime = event.time;
+    time.textContent = new Date(event.time).toLocaleString();
+    item.append(time, event.msg);
+    list.appendChild(item);
+  }
+}
+
+async function changeMode(deviceID, mode) {
+  const card = cards[deviceID];
+  const message = card.element.querySelector(".message");
+  if (pinRequired && !pin) {
+    pin = window.prompt("PIN") || "";
+    if (!pin) {
+      return;
+    }
+  }
+  const headers = { "Content-Type": "application/json" };
+  if (pinRequired) {
+    headers["X-Alarm-PIN"] = pin;
+  }
+  card.busy = true;
+  renderButtons(deviceID);
+  message.textContent = "Sending…";
+  try {
+    const response = await api("/devices/status/" + encodeURIComponent(deviceID), {
+      method: "PUT",
+      headers: headers,
+      body: JSON.stringify({ mode: mode }),
+    });
+    const body = response.body || {};
+    if (response.status === 403) {
+      pin = "";
+    }
+    if (response.status === 200) {
+      message.textContent = body.msg || "Mode changed.";
+    } else {
+      message.textContent = body.msg || "Mode change failed with status " + response.status + ".";
+    }
+    if ((response.status === 200 || response.status === 202) && body.mode) {
+      render(deviceID, body);
+    }
+  } catch (error) {
+    message.textContent = "Service can't be reached.";
+  }
+  card.busy = false;
+  renderButtons(deviceID);
+}
+
+// watch keeps a device card up to date, long-polls are answered as soon as
+// device state differs from the ETag the dashboard already has.
+async function watch(deviceID, longPoll) {
+  let etag = "";
+  for (;;) {
+    try {
+      const path = "/devices/status/" + encodeURIComponent(deviceID) + (etag && longPoll ? "?wait=" + statusWait : "");
+      const response = await api(path, { headers: etag ? { "If-None-Match": etag } : {} });
+      setConnection(response.status !== 401);
+      const card = cards[deviceID];
+      const message = card.element.querySelector(".message");
+      if (response.status === 200) {
+        etag = response.etag || "";
+        if (card.unavailable) {
+          card.unavailable = false;
+          message.textContent = "";
+        }
+        render(deviceID, response.body);
+        refreshEvents();
+        refreshSensors();
+      } else if (response.status !== 304) {
+        card.unavailable = true;
+        message.textContent = (response.body && response.body.msg) || "Status is not available.";
+        await sleep(retryDelay);
+      }
+      if (!longPoll) {
+        await sleep(pollDelay);
+      }
+    } catch (error) {
+      setConnection(false);
+      await sleep(retryDelay);
+    }
+  }
+}
+
+// showClient reads what the client is allowed to do, mode buttons are
+// disabled when it can only read.
+function showClient(client) {
+  readOnly = client.permission !== "write";
+  pinRequired = client.pin_required;
+  if (readOnly) {
+    showNotice("This client can only read device status, mode changes are not allowed.");
+  }
+}
+
+async function start() {
+  let response;
+  try {
+    const client = await api("/whoami");
+    if (client.status === 200 && client.body) {
+      showClient(client.body);
+    }
+    response = await api("/devices/");
+  } catch (error) {
+    setConnection(false);
+    setTimeout(start, retryDelay);
+    return;
+  }
+  if (response.status !== 200 || !response.body) {
+    setConnection(false);
+    return;
+  }
+  const devices = response.body.data || {};
+  const deviceIDs = Object.keys(devices).sort((first, second) => devices[first].localeCompare(devices[second]));
+  for (const deviceID of deviceIDs) {
+    createCard(deviceID, devices[deviceID]);
+  }
+  setConnection(true);
+  refreshEvents();
+  refreshSensors();
+  const longPoll = deviceIDs.length <= maxLongPolls;
+  deviceIDs.forEach((deviceID) => watch(deviceID, longPoll));
+}
+
+start();
@@ -0,0 +1,104 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+	chi "github.com/go-chi/chi/v5"
+)
+
+// budgetDevice is implemented by devices whose requests are counted in a
+// request budget.
+type budgetDevice interface {
+	Budget() *tuyadevice.Budget
+}
+
+// PollInterval returns how long pollers must wait between status updates,
+// base is stretched by the budget of any device which is running low.
+func (manager *DeviceManager) PollInterval(base time.Duration) time.Duration {
+	interval := base
+	for _, device := range manager.DevicesInfo {
+		if budgeted, ok := device.(budgetDevice); ok {
+			if deviceInterval := budgeted.Budget().PollInterval(base); deviceInterval > interval {
+				interval = deviceInterval
+			}
+		}
+	}
+	return interval
+}
+
+// BudgetRoutes are served from /admin/budgets, budgets are identified by
+// their client ID.
+func BudgetRoutes() chi.Router {
+	router := chi.NewRouter()
+	router.Get("/", ListBudgets)
+	router.Route("/{id}", func(r chi.Router) {
+		r.Use(DeviceCtx)
+		r.Put("/", UpdateBudgetUsage)
+	})
+	return router
+}
+
+type BudgetListResponse struct {
+	Success bool                     `json:"success"`
+	Message string                   `json:"msg"`
+	Data    []tuyadevice.BudgetUsage `json:"data"`
+}
+
+func ListBudgets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	response := BudgetListResponse{Success: true, Data: tuyadevice.BudgetUsages()}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+type BudgetResponse struct {
+	Success bool                    `json:"success"`
+	Message string                  `json:"msg"`
+	Data    *tuyadevice.BudgetUsage `json:"data,omitempty"`
+}
+
+// BudgetUsageUpdate restores the usage Tuya cloud reports, counters are
+// lost when the service restarts.
+type BudgetUsageUpdate struct {
+	DailyUsed   *int `json:"daily_used"`
+	MonthlyUsed *int `json:"monthly_used"`
+}
+
+func UpdateBudgetUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	clientID := r.Context().Value("id").(string)
+	var response BudgetResponse
+	var update BudgetUsageUpdate
+	budget, ok := tuyadevice.LookupBudget(clientID)
+	if !ok {
+		response.Message = fmt.Sprintf("Client '%s' has no budget.", clientID)
+		w.WriteHeader(404)
+	} else if json.NewDecoder(r.Body).Decode(&update) != nil {
+		response.Message = "Failed to decode Response"
+		w.WriteHeader(400)
+	} else {
+		usage := budget.Usage()
+		if update.DailyUsed != nil {
+			usage.DailyUsed = *update.DailyUsed
+		}
+		if update.MonthlyUsed != nil {
+			usage.MonthlyUsed = *update.MonthlyUsed
+		}
+		if usage.DailyUsed < 0 || usage.MonthlyUsed < 0 {
+			response.Message = fmt.Sprintf("Usage of client '%s' can't be negative.", clientID)
+			w.WriteHeader(400)
+		} else {
+			budget.SetUsage(usage.DailyUsed, usage.MonthlyUsed)
+			usage = budget.Usage()
+			response.Success = true
+			response.Message = "Budget usage updated."
+			response.Data = &usage
+		}
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
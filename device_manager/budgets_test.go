@@ -0,0 +1,55 @@
+package devices
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func TestPollIntervalBacksOff(t *testing.T) {
+	deviceManager, _ := simulatorManager(t)
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Budget Alarm", DeviceType: "99AST", ClientID: "poll-client", DeviceID: "budget123"})
+
+	if interval := deviceManager.PollInterval(20 * time.Second); interval != 20*time.Second {
+		t.Errorf("Poll interval without budget limits should be 20s, it was %s.", interval)
+	}
+	tuyadevice.BudgetFor("poll-client").SetLimits(0, 100, 0)
+	tuyadevice.BudgetFor("poll-client").SetUsage(80, 80)
+	if interval := deviceManager.PollInterval(20 * time.Second); interval != 80*time.Second {
+		t.Errorf("Poll interval with 20%% of budget left should be 80s, it was %s.", interval)
+	}
+}
+
+func TestUpdateBudgetUsage(t *testing.T) {
+	tuyadevice.BudgetFor("usage-client").SetLimits(0, 0, 1000)
+
+	recorder := httptest.NewRecorder()
+	BudgetRoutes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/usage-client", strings.NewReader(`{"monthly_used": 250}`)))
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"monthly_used":250`) || !strings.Contains(recorder.Body.String(), `"remaining":0.75`) {
+		t.Errorf("Budget usage should be updated, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	BudgetRoutes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/usage-client", strings.NewReader(`{"daily_used": -1}`)))
+	if recorder.Code != 400 {
+		t.Errorf("Negative usage should return 400, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	BudgetRoutes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/unknown-client", strings.NewReader(`{"daily_used": 1}`)))
+	if recorder.Code != 404 {
+		t.Errorf("Unknown client budget should return 404, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+	if _, ok := tuyadevice.LookupBudget("unknown-client"); ok {
+		t.Errorf("Updating unknown client budget should not create it.")
+	}
+
+	recorder = httptest.NewRecorder()
+	BudgetRoutes().ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(recorder.Body.String(), `"client_id":"usage-client"`) {
+		t.Errorf("Budget list should contain usage-client, response was %s.", recorder.Body.String())
+	}
+}
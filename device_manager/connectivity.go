@@ -0,0 +1,165 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/events"
+)
+
+// Outages which ended before this many days are forgotten.
+const offlineRetentionDays = 31
+
+type outage struct {
+	start time.Time
+	end   time.Time
+}
+
+// connectivity is the debounced online state of a device.
+type connectivity struct {
+	online       bool
+	pendingSince time.Time
+	offlineSince time.Time
+	outages      []outage
+}
+
+func (manager *DeviceManager) clock() time.Time {
+	if manager.now == nil {
+		return time.Now()
+	}
+	return manager.now()
+}
+
+// updateConnectivity records device_offline and device_online events once
+// a device has reported its new online state for OfflineDebounce, flapping
+// devices are not reported. Outages are kept for the offline report.
+func (manager *DeviceManager) updateConnectivity(deviceID string, deviceName string, online bool, now time.Time) {
+	manager.connectivityMutex.Lock()
+	defer manager.connectivityMutex.Unlock()
+	if manager.connectivity == nil {
+		manager.connectivity = make(map[string]*connectivity)
+	}
+	state, ok := manager.connectivity[deviceID]
+	if !ok {
+		state = &connectivity{online: true}
+		manager.connectivity[deviceID] = state
+	}
+	if online == state.online {
+		state.pendingSince = time.Time{}
+		return
+	}
+	if state.pendingSince.IsZero() {
+		state.pendingSince = now
+	}
+	if now.Sub(state.pendingSince) < manager.OfflineDebounce {
+		return
+	}
+
+	event := events.Event{Source: "device_manager", DeviceID: deviceID, Success: true}
+	if online {
+		offlineFor := state.pendingSince.Sub(state.offlineSince).Round(time.Second)
+		state.outages = append(state.outages, outage{start: state.offlineSince, end: state.pendingSince})
+		event.Type = events.DeviceOnline
+		event.Message = fmt.Sprintf("%s is online again after %s offline.", deviceName, offlineFor)
+		event.Data = map[string]string{"offline_for": offlineFor.String()}
+	} else {
+		state.offlineSince = state.pendingSince
+		event.Type = events.DeviceOffline
+		event.Message = fmt.Sprintf("%s is offline.", deviceName)
+		event.Data = map[string]string{"since": state.offlineSince.Format(time.RFC3339)}
+	}
+	state.online = online
+	state.pendingSince = time.Time{}
+
+	retention := now.AddDate(0, 0, -offlineRetentionDays)
+	for len(state.outages) > 0 && state.outages[0].end.Before(retention) {
+		state.outages = state.outages[1:]
+	}
+	if manager.History != nil {
+		manager.History.Record(event)
+	}
+}
+
+type DailyOffline struct {
+	Date           string `json:"date"`
+	OfflineSeconds int64  `json:"offline_seconds"`
+	Offline        string `json:"offline"`
+}
+
+// OfflineReport returns how long each device has been offline on each of
+// the last days, today included, in local time.
+func (manager *DeviceManager) OfflineReport(days int) map[string][]DailyOffline {
+	manager.connectivityMutex.Lock()
+	defer manager.connectivityMutex.Unlock()
+	now := manager.clock()
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+
+	report := make(map[string][]DailyOffline)
+	for deviceID := range manager.DevicesInfo {
+		var outages []outage
+		if state, ok := manager.connectivity[deviceID]; ok {
+			outages = append(outages, state.outages...)
+			if !state.online {
+				outages = append(outages, outage{start: state.offlineSince, end: now})
+			}
+		}
+		daily := []DailyOffline{}
+		for index := days - 1; index >= 0; index-- {
+			dayStart := today.AddDate(0, 0, -index)
+			dayEnd := dayStart.AddDate(0, 0, 1)
+			var offline time.Duration
+			for _, deviceOutage := range outages {
+				start, end := deviceOutage.start, deviceOutage.end
+				if start.Before(dayStart) {
+					start = dayStart
+				}
+				if end.After(dayEnd) {
+					end = dayEnd
+				}
+				if end.After(start) {
+					offline += end.Sub(start)
+				}
+			}
+			offline = offline.Round(time.Second)
+			daily = append(daily, DailyOffline{Date: dayStart.Format("2006-01-02"), OfflineSeconds: int64(offline / time.Second), Offline: offline.String()})
+		}
+		report[deviceID] = daily
+	}
+	return report
+}
+
+type OfflineReportResponse struct {
+	Success bool                      `json:"success"`
+	Message string                    `json:"msg"`
+	Data    map[string][]DailyOffline `json:"data"`
+}
+
+func (manager *DeviceManager) ShowOfflineReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var response OfflineReportResponse
+	days := 7
+	var daysErr error
+	if daysString := r.URL.Query().Get("days"); daysString != "" {
+		days, daysErr = strconv.Atoi(daysString)
+	}
+	deviceID := r.URL.Query().Get("device_id")
+	if daysErr != nil || days <= 0 || days > offlineRetentionDays {
+		response.Message = fmt.Sprintf("days must be an integer between 1 and %d.", offlineRetentionDays)
+		w.WriteHeader(400)
+	} else if deviceID != "" && !manager.HasDevice(deviceID) {
+		response.Message = fmt.Sprintf("Device id '%s' does not exist.", deviceID)
+		w.WriteHeader(404)
+	} else {
+		response.Success = true
+		response.Data = manager.OfflineReport(days)
+		if deviceID != "" {
+			response.Data = map[string][]DailyOffline{deviceID: response.Data[deviceID]}
+		}
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
@@ -0,0 +1,74 @@
+package devices
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/events"
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func connectivityManager(debounce time.Duration) (*DeviceManager, *events.History) {
+	history := events.NewHistory(10)
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), History: history, OfflineDebounce: debounce}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Test Device", DeviceType: "99AST", DeviceID: "testid123"})
+	return &deviceManager, history
+}
+
+func TestUpdateConnectivityDebounce(t *testing.T) {
+
+	deviceManager, history := connectivityManager(time.Minute)
+	start := time.Date(2022, 6, 3, 10, 0, 0, 0, time.UTC)
+
+	// Flapping device is not reported
+	deviceManager.updateConnectivity("testid123", "Test Device", false, start)
+	deviceManager.updateConnectivity("testid123", "Test Device", true, start.Add(20*time.Second))
+	deviceManager.updateConnectivity("testid123", "Test Device", false, start.Add(40*time.Second))
+	deviceManager.updateConnectivity("testid123", "Test Device", false, start.Add(80*time.Second))
+	if recorded := history.Recent(10, events.Event{}); len(recorded) != 0 {
+		t.Fatalf("Offline state should be debounced, events were %+v.", recorded)
+	}
+
+	deviceManager.updateConnectivity("testid123", "Test Device", false, start.Add(100*time.Second))
+	recorded := history.Recent(10, events.Event{})
+	if len(recorded) != 1 || recorded[0].Type != events.DeviceOffline || recorded[0].Data["since"] != "2022-06-03T10:00:40Z" {
+		t.Fatalf("Device should be reported offline since it stopped flapping, events were %+v.", recorded)
+	}
+
+	deviceManager.updateConnectivity("testid123", "Test Device", true, start.Add(10*time.Minute+40*time.Second))
+	deviceManager.updateConnectivity("testid123", "Test Device", true, start.Add(12*time.Minute))
+	recorded = history.Recent(1, events.Event{})
+	if recorded[0].Type != events.DeviceOnline || recorded[0].Message != "Test Device is online again after 10m0s offline." {
+		t.Errorf("Device recovery should be reported with offline duration, event was %+v.", recorded[0])
+	}
+}
+
+func TestOfflineReport(t *testing.T) {
+
+	deviceManager, _ := connectivityManager(0)
+	now := time.Date(2022, 6, 3, 1, 0, 0, 0, time.UTC)
+	deviceManager.now = func() time.Time { return now }
+
+	// Offline from 23:00 to 00:30, and again since 00:45
+	deviceManager.updateConnectivity("testid123", "Test Device", false, now.Add(-2*time.Hour))
+	deviceManager.updateConnectivity("testid123", "Test Device", true, now.Add(-30*time.Minute))
+	deviceManager.updateConnectivity("testid123", "Test Device", false, now.Add(-15*time.Minute))
+
+	report := deviceManager.OfflineReport(2)["testid123"]
+	if len(report) != 2 || report[0].Date != "2022-06-02" || report[0].Offline != "1h0m0s" || report[1].Date != "2022-06-03" || report[1].OfflineSeconds != 45*60 {
+		t.Errorf("Offline report should split outages by day, report was %+v.", report)
+	}
+
+	recorder := httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/offline?days=1&device_id=testid123", nil))
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"testid123":[{"date":"2022-06-03","offline_seconds":2700,"offline":"45m0s"}]`) {
+		t.Errorf("GET offline report returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/offline?days=0", nil))
+	if recorder.Code != 400 {
+		t.Errorf("GET offline report with invalid days should return 400, not %d.", recorder.Code)
+	}
+}
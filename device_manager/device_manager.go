@@ -2,20 +2,26 @@ package devices
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf16"
 
 	config "github.com/a-castellano/AlarmManager/config_reader"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/logging"
 	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
-	"github.com/go-chi/chi"
+	"github.com/a-castellano/AlarmManager/webtls"
+	chi "github.com/go-chi/chi/v5"
 )
 
+var logger = logging.New("devices")
+
 type AlarmMode int
 
 const (
@@ -40,78 +46,94 @@ var AlarmModeAlarmValues = map[AlarmMode]string{
 	Sos:        "sos",
 }
 
+type PowerSource int
+
+const (
+	UnknownPower PowerSource = iota // charge_state is not reported
+	MainsPower
+	BatteryPower
+)
+
+var PowerSourceValues = map[PowerSource]string{
+	UnknownPower: "unknown",
+	MainsPower:   "mains",
+	BatteryPower: "battery",
+}
+
 type AlarmInfo struct {
-	IP        string
-	LocalKey  string
-	Latitude  float32
-	Longitude float32
-	Name      string
-	Mode      AlarmMode
-	Online    bool
-	Firing    bool
+	IP          string
+	LocalKey    string
+	Latitude    float32
+	Longitude   float32
+	Name        string
+	Mode        AlarmMode
+	Online      bool
+	Firing      bool
+	PowerSource PowerSource
+	LowBattery  bool
+	// Reason is the last decoded alarm message
+	Reason string
 }
 
+// Alarm is the last retrieved info of a device.
 type Alarm interface {
 	ShowInfo() AlarmInfo
-	getEquivalentMode(newMode string) (string, error)
-}
-
-type Alarm99ASTResult struct {
-	ActiveTime  int     `json:"active_time"`
-	BizTime     int     `json:"biz_type"`
-	Category    string  `json:"category"`
-	CreateTime  int     `json:"create_time"`
-	Icon        string  `json:"icon"`
-	ID          string  `json:"id"`
-	IP          string  `json:"ip"`
-	Latitude    float32 `json:"lat,string"`
-	Longitude   float32 `json:"lon,string"`
-	LocalKey    string  `json:"local_key"`
-	Model       string  `json:"model"`
-	Name        string  `json:"name"`
-	Online      bool    `json:"online"`
-	OwnerID     int     `json:"owner_id,string"`
-	ProductID   string  `json:"product_id"`
-	ProductName string  `json:"product_name"`
-	Sub         bool    `json:"sub"`
-	TimeZone    string  `json:"time_zone"`
-	UID         string  `json:"uid"`
-	UpdateTime  int     `json:"update_time"`
-	UUID        string  `json:"uuid"`
-	Status      []struct {
-		Code  string      `json:"code"`
-		Value interface{} `json:"value"`
-	} `json:"status"`
-}
-
-type Alarm99AST struct {
-	Result    Alarm99ASTResult `json:"result"`
-	Success   bool             `json:"success"`
-	Time      int              `json:"t"`
-	AlarmInfo AlarmInfo
-}
-
-func (a Alarm99AST) ShowInfo() AlarmInfo {
-	return a.AlarmInfo
-}
-
-func (a Alarm99AST) getEquivalentMode(newMode string) (string, error) {
-	var equivalentMode string
-	// Check if newMode is defined
-	if newModeValue, ok := AlarmModeMap[newMode]; ok {
-		equivalentMode = AlarmModeAlarmValues[newModeValue]
-	} else {
-		errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", newMode)
-		return equivalentMode, errors.New(errorString)
-	}
-	return equivalentMode, nil
+	// EquivalentMode returns the device value of newMode
+	EquivalentMode(newMode string) (string, error)
+	Capabilities() []string
 }
 
+type ModeConfirmation int
+
+const (
+	Confirmed    ModeConfirmation = iota + 1 // device reports requested mode
+	Pending                                  // device still reports previous mode
+	Contradicted                             // device reports another mode
+)
+
+var ModeConfirmationValues = map[ModeConfirmation]string{
+	Confirmed:    "confirmed",
+	Pending:      "pending",
+	Contradicted: "contradicted",
+}
+
+const DefaultConfirmationTimeout = 5 * time.Second
+const DefaultConfirmationInterval = 500 * time.Millisecond
+
+// DeviceManager polls managed devices. Devices and groups must be added
+// before Start, device state is read through Snapshot.
 type DeviceManager struct {
 	initiated   bool
 	DevicesInfo map[string]tuyadevice.Device
-	AlarmsInfo  map[string]Alarm
-	mutex       sync.Mutex
+	Groups      map[string]Group
+	// snapshot holds the latest *Snapshot
+	snapshot     atomic.Value
+	snapshotOnce sync.Once
+	// epoch tells apart ETags of different service runs
+	epoch string
+	// mutex serializes device polls and mode changes
+	mutex sync.Mutex
+	// ConfirmationTimeout is how long ConfirmMode polls the device
+	ConfirmationTimeout time.Duration
+	// ConfirmationInterval is the time between ConfirmMode polls
+	ConfirmationInterval time.Duration
+	// Jobs runs asynchronous mode changes, they are not available when nil
+	Jobs *JobManager
+	// History receives state transitions, they are not recorded when nil
+	History *events.History
+	// OfflineDebounce is how long a device must keep its new online state
+	// before it is reported
+	OfflineDebounce   time.Duration
+	connectivity      map[string]*connectivity
+	connectivityMutex sync.Mutex
+	// modeActors holds who requested the last mode change of each device
+	// until the device reports it, mutex must be held
+	modeActors map[string]string
+	// Client is shared by API handlers to reach devices, http.DefaultClient
+	// is used when nil
+	Client *http.Client
+	// now is replaced in tests
+	now func() time.Time
 }
 
 func CreateTuyaDeviceFromConfig(deviceConfig config.TuyaDeviceConfig) tuyadevice.TuyaDevice {
@@ -121,6 +143,17 @@ func CreateTuyaDeviceFromConfig(deviceConfig config.TuyaDeviceConfig) tuyadevice
 	return device
 }
 
+// CreateDeviceFromConfig creates the device of the type set in config.
+func CreateDeviceFromConfig(deviceConfig config.TuyaDeviceConfig) tuyadevice.Device {
+	if deviceConfig.DeviceType == tuyadevice.SimulatedDeviceType {
+		device := tuyadevice.NewSimulatedDevice(deviceConfig.Name, deviceConfig.DeviceID, deviceConfig.Simulator.Mode, deviceConfig.Simulator.Sensors)
+		device.SetFailures(deviceConfig.Simulator.Latency, deviceConfig.Simulator.FailureRate)
+		return device
+	}
+	device := CreateTuyaDeviceFromConfig(deviceConfig)
+	return &device
+}
+
 func (manager *DeviceManager) AddDevice(device tuyadevice.Device) error {
 	deviceName := device.GetDeviceName()
 	deviceID := device.GetDeviceID()
@@ -132,124 +165,236 @@ func (manager *DeviceManager) AddDevice(device tuyadevice.Device) error {
 	return nil
 }
 
-func (manager *DeviceManager) Start(client http.Client) error {
-	for deviceID, device := range manager.DevicesInfo {
+func (manager *DeviceManager) HasDevice(deviceID string) bool {
+	_, ok := manager.DevicesInfo[deviceID]
+	return ok
+}
+
+func (manager *DeviceManager) HasGroup(groupID string) bool {
+	_, ok := manager.Groups[groupID]
+	return ok
+}
+
+// httpClient returns the client shared by API handlers.
+func (manager *DeviceManager) httpClient() *http.Client {
+	if manager.Client == nil {
+		return http.DefaultClient
+	}
+	return manager.Client
+}
+
+func (manager *DeviceManager) Start(ctx context.Context, client *http.Client) error {
+	for _, device := range manager.DevicesInfo {
 		// Retrieve info foreach device
-		tokenError := device.RetrieveToken(client)
+		tokenError := device.RetrieveToken(ctx, client)
 		if tokenError != nil {
 			return tokenError
 		}
-		manager.DevicesInfo[deviceID] = device
-
 	}
 	return nil
 }
 
-func (manager *DeviceManager) RetrieveInfo(client http.Client) error {
+func (manager *DeviceManager) RetrieveInfo(ctx context.Context, client *http.Client) error {
 
 	manager.mutex.Lock()
 	defer manager.mutex.Unlock()
 
 	for deviceID, device := range manager.DevicesInfo {
-		deviceName := device.GetDeviceName()
-		tokenError := device.RetrieveToken(client)
-		if tokenError != nil {
-			return tokenError
-		}
-		log.Println("Retrieving info from device ", deviceName)
-		deviceInfo, deviceInfoErr := device.GetDeviceInfo(client)
-		log.Println(string(deviceInfo))
-		if deviceInfoErr != nil {
-			log.Println("Fatal error retrieving info from device ", deviceName)
-			return deviceInfoErr
+		if retrieveError := manager.retrieveDeviceInfo(ctx, client, deviceID, device); retrieveError != nil {
+			return retrieveError
 		}
-		switch device.GetDeviceType() {
-		case "99AST":
-			alarmInfo := Alarm99AST{}
-			if unmarshalErr := json.Unmarshal(deviceInfo, &alarmInfo); unmarshalErr != nil {
-				return unmarshalErr
-			}
-			// Retrieve Alarm Info
-			alarmInfo.AlarmInfo.IP = alarmInfo.Result.IP
-			alarmInfo.AlarmInfo.LocalKey = alarmInfo.Result.LocalKey
-			alarmInfo.AlarmInfo.Latitude = alarmInfo.Result.Latitude
-			alarmInfo.AlarmInfo.Longitude = alarmInfo.Result.Longitude
-			alarmInfo.AlarmInfo.Online = alarmInfo.Result.Online
-			// Check master mode value
-
-			var masterStateSet, masterModeSet, alarmMessageSet bool
-			for _, statusTuple := range alarmInfo.Result.Status {
-				if masterStateSet && masterModeSet {
-					break
-				} else {
-					switch statusTuple.Code {
-					case "master_mode":
-						masterModeValue := fmt.Sprintf("%v", statusTuple.Value)
-						switch masterModeValue {
-						case "home":
-							alarmInfo.AlarmInfo.Mode = HomeArmed
-						case "disarmed":
-							alarmInfo.AlarmInfo.Mode = Disarmed
-						case "arm":
-							alarmInfo.AlarmInfo.Mode = FullyArmed
-						case "sos":
-							alarmInfo.AlarmInfo.Mode = Sos
-						default:
-							alarmInfo.AlarmInfo.Mode = Unknown
-						}
-						masterModeSet = true
-					case "master_state":
-						masterStateValue := fmt.Sprintf("%v", statusTuple.Value)
-						alarmInfo.AlarmInfo.Firing = masterStateValue == "alarm"
-						masterStateSet = true
-					case "alarm_msg":
-						alarmMessageValue := fmt.Sprintf("%v", statusTuple.Value)
-						alarmMessageSet = strings.HasPrefix(alarmMessageValue, "AF")
-					}
+	}
+	manager.initiated = true
+	return nil
+}
 
-				}
-			}
-			if alarmInfo.AlarmInfo.Firing == false && alarmMessageSet == true {
-				alarmInfo.AlarmInfo.Firing = true
-			}
-			manager.AlarmsInfo[deviceID] = alarmInfo
-		default:
-			errorString := fmt.Sprintf("Alarm %s type %s not supported", deviceName, device.GetDeviceType())
-			return errors.New(errorString)
+// decodeAlarmMessage decodes alarm_msg values, they are base64 encoded
+// UTF-16 big endian strings. Values which can't be decoded are returned as is.
+func decodeAlarmMessage(value string) string {
+	decoded, decodeErr := base64.StdEncoding.DecodeString(value)
+	if decodeErr != nil || len(decoded)%2 != 0 {
+		return value
+	}
+	units := make([]uint16, len(decoded)/2)
+	for index := range units {
+		units[index] = uint16(decoded[2*index])<<8 | uint16(decoded[2*index+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// specificationDevice is implemented by devices which can retrieve their
+// specification.
+type specificationDevice interface {
+	GetDeviceSpecification(context.Context, *http.Client) ([]byte, error)
+}
+
+// newDeviceDriver creates the driver registered for device type or, when
+// there is none, for the Tuya category reported in deviceInfo.
+func newDeviceDriver(device tuyadevice.Device, deviceInfo []byte) (Driver, bool) {
+	if driver, ok := NewDriver(device.GetDeviceType()); ok {
+		return driver, true
+	}
+	var details struct {
+		Result struct {
+			Category string `json:"category"`
+		} `json:"result"`
+	}
+	json.Unmarshal(deviceInfo, &details)
+	return NewCategoryDriver(details.Result.Category)
+}
+
+func learnSpecification(ctx context.Context, client *http.Client, device tuyadevice.Device, learner SpecificationLearner) error {
+	specDevice, ok := device.(specificationDevice)
+	if !ok {
+		return nil
+	}
+	logger.Info("Retrieving device specification.", "device", device.GetDeviceName())
+	specification, specificationErr := specDevice.GetDeviceSpecification(ctx, client)
+	if specificationErr != nil {
+		return specificationErr
+	}
+	return learner.LearnSpecification(specification)
+}
+
+func (manager *DeviceManager) retrieveDeviceInfo(ctx context.Context, client *http.Client, deviceID string, device tuyadevice.Device) error {
+	deviceName := device.GetDeviceName()
+	tokenError := device.RetrieveToken(ctx, client)
+	if tokenError != nil {
+		return tokenError
+	}
+	logger.Debug("Retrieving device info.", "device", deviceName)
+	deviceInfo, deviceInfoErr := device.GetDeviceInfo(ctx, client)
+	if deviceInfoErr != nil {
+		logger.Error("Failed to retrieve device info.", "device", deviceName, "error", deviceInfoErr)
+		return deviceInfoErr
+	}
+	var driver Driver
+	previousAlarm, known := manager.Snapshot().Alarm(deviceID)
+	if previousDriverAlarm, ok := previousAlarm.(DriverAlarm); ok {
+		driver = previousDriverAlarm.Driver
+	} else if driver, ok = newDeviceDriver(device, deviceInfo); !ok {
+		errorString := fmt.Sprintf("Alarm %s type %s not supported", deviceName, device.GetDeviceType())
+		return errors.New(errorString)
+	} else if learner, ok := driver.(SpecificationLearner); ok {
+		if learnErr := learnSpecification(ctx, client, device, learner); learnErr != nil {
+			return learnErr
 		}
 	}
-	manager.initiated = true
+	alarmInfo, parseErr := driver.ParseInfo(deviceInfo)
+	if parseErr != nil {
+		return parseErr
+	}
+	manager.publish(deviceID, DriverAlarm{Info: alarmInfo, Driver: driver})
+	if known {
+		manager.recordTransitions(deviceID, deviceName, previousAlarm.ShowInfo(), alarmInfo)
+	} else {
+		baseline := AlarmInfo{Mode: alarmInfo.Mode, PowerSource: MainsPower}
+		manager.recordTransitions(deviceID, deviceName, baseline, alarmInfo)
+	}
+	manager.updateConnectivity(deviceID, deviceName, alarmInfo.Online, manager.clock())
 	return nil
 }
 
-func (manager *DeviceManager) ChangeMode(client http.Client, deviceID string, newMode string) error {
+func (manager *DeviceManager) ChangeMode(ctx context.Context, client *http.Client, deviceID string, newMode string) error {
 	manager.mutex.Lock()
 	defer manager.mutex.Unlock()
 	if !manager.initiated {
 		errorString := fmt.Sprintf("Device has not retrieved devices info yet.")
 		return errors.New(errorString)
 	} // Check if device exists
-	if alarmDevice, ok := manager.AlarmsInfo[deviceID]; !ok {
+	if alarmDevice, ok := manager.Snapshot().Alarm(deviceID); !ok {
 		errorString := fmt.Sprintf("Device id '%s' is not a managed device.", deviceID)
 		return errors.New(errorString)
 	} else {
-		if equivalentMode, equivalentModeError := alarmDevice.getEquivalentMode(newMode); equivalentModeError != nil {
+		if driverAlarm, ok := alarmDevice.(DriverAlarm); ok && !hasCapability(driverAlarm.Driver, ModeControl) {
+			errorString := fmt.Sprintf("Device '%s' mode can't be changed.", manager.DevicesInfo[deviceID].GetDeviceName())
+			return errors.New(errorString)
+		} else if equivalentMode, equivalentModeError := alarmDevice.EquivalentMode(newMode); equivalentModeError != nil {
 			return equivalentModeError
 		} else {
-			changeModeError := manager.DevicesInfo[deviceID].ChangeMode(client, equivalentMode)
+			changeModeError := manager.DevicesInfo[deviceID].ChangeMode(ctx, client, equivalentMode)
 			if changeModeError != nil {
 				return changeModeError
 			}
+			if manager.modeActors == nil {
+				manager.modeActors = make(map[string]string)
+			}
+			manager.modeActors[deviceID] = events.ActorFrom(ctx)
 		}
 
 	}
 	return nil
 }
 
+// confirmationTimeout returns ConfirmationTimeout, or its default when it is
+// not set.
+func (manager *DeviceManager) confirmationTimeout() time.Duration {
+	if manager.ConfirmationTimeout <= 0 {
+		return DefaultConfirmationTimeout
+	}
+	return manager.ConfirmationTimeout
+}
+
+// ConfirmMode polls deviceID until it reports newMode, ConfirmationTimeout
+// passes or ctx is done. previousMode is the mode reported before the change
+// was requested.
+func (manager *DeviceManager) ConfirmMode(ctx context.Context, client *http.Client, deviceID string, newMode string, previousMode AlarmMode) (ModeConfirmation, error) {
+	requestedMode, ok := AlarmModeMap[newMode]
+	if !ok {
+		errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", newMode)
+		return Pending, errors.New(errorString)
+	}
+	device, ok := manager.DevicesInfo[deviceID]
+	if !ok {
+		errorString := fmt.Sprintf("Device id '%s' is not a managed device.", deviceID)
+		return Pending, errors.New(errorString)
+	}
+	timeout := manager.confirmationTimeout()
+	interval := manager.ConfirmationInterval
+	if interval <= 0 {
+		interval = DefaultConfirmationInterval
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		manager.mutex.Lock()
+		retrieveError := manager.retrieveDeviceInfo(ctx, client, deviceID, device)
+		manager.mutex.Unlock()
+		var currentMode AlarmMode
+		if alarm, ok := manager.Snapshot().Alarm(deviceID); ok && retrieveError == nil {
+			currentMode = alarm.ShowInfo().Mode
+		}
+		if retrieveError != nil {
+			return Pending, retrieveError
+		}
+		switch currentMode {
+		case requestedMode:
+			return Confirmed, nil
+		case previousMode:
+		default:
+			logger.Warn("Device reports another mode.", "device", device.GetDeviceName(), "mode", AlarmModeAlarmValues[currentMode], "requested", AlarmModeAlarmValues[requestedMode])
+			return Contradicted, nil
+		}
+		// device is polled once more when the deadline is reached
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return Pending, nil
+		}
+		if wait > interval {
+			wait = interval
+		}
+		select {
+		case <-ctx.Done():
+			return Pending, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
 func (manager *DeviceManager) Routes() chi.Router {
 	router := chi.NewRouter()
-	router.Get("/devices", manager.ListDevices)
-	router.Route("/devices/status/{id}", func(r chi.Router) {
+	router.Get("/", manager.ListDevices)
+	router.Get("/offline", manager.ShowOfflineReport)
+	router.Route("/status/{id}", func(r chi.Router) {
 		r.Use(DeviceCtx)
 		r.Get("/", manager.ShowDeviceInfo)
 		r.Put("/", manager.UpdateStatus)
@@ -282,26 +427,73 @@ func DeviceCtx(next http.Handler) http.Handler {
 }
 
 type DeviceStatusResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"msg"`
-	Mode    string `json:"mode"`
-	Firing  bool   `json:"firing"`
-	Online  bool   `json:"online"`
+	Success      bool     `json:"success"`
+	Message      string   `json:"msg"`
+	Mode         string   `json:"mode"`
+	Firing       bool     `json:"firing"`
+	Online       bool     `json:"online"`
+	PowerSource  string   `json:"power_source"`
+	LowBattery   bool     `json:"low_battery"`
+	Reason       string   `json:"reason,omitempty"`
+	Confirmation string   `json:"confirmation,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// writeErrorHeader writes the status code reporting err, Tuya cloud errors
+// have their own status codes and fallback is used for other errors.
+func writeErrorHeader(w http.ResponseWriter, err error, fallback int) {
+	switch {
+	case errors.Is(err, tuyadevice.ErrRateLimited):
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(429)
+	case errors.Is(err, tuyadevice.ErrBudgetExhausted):
+		w.WriteHeader(429)
+	case errors.Is(err, tuyadevice.ErrDeviceOffline):
+		w.WriteHeader(503)
+	case errors.Is(err, tuyadevice.ErrPermissionDenied):
+		w.WriteHeader(403)
+	case errors.Is(err, tuyadevice.ErrParamIllegal):
+		w.WriteHeader(422)
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(504)
+	case errors.Is(err, tuyadevice.ErrTokenInvalid), errors.Is(err, tuyadevice.ErrTokenExpired):
+		// token was already renewed once, Tuya cloud keeps rejecting it
+		w.WriteHeader(502)
+	default:
+		w.WriteHeader(fallback)
+	}
 }
 
 func (manager *DeviceManager) ShowDeviceInfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	deviceID := r.Context().Value("id").(string)
 	var response DeviceStatusResponse
-	if _, ok := manager.DevicesInfo[deviceID]; !ok {
+	wait, waitErr := ParseWait(r)
+	if waitErr != nil {
+		response.Success = false
+		response.Message = waitErr.Error()
+		w.WriteHeader(400)
+	} else if _, ok := manager.DevicesInfo[deviceID]; !ok {
 		response.Success = false
 		response.Message = fmt.Sprintf("Device id '%s' does not exist.", deviceID)
 		w.WriteHeader(404)
+	} else if snapshot, notModified := manager.conditionalSnapshot(w, r, []string{deviceID}, wait); notModified {
+		w.WriteHeader(304)
+		return
+	} else if alarm, ok := snapshot.Alarm(deviceID); !ok {
+		response.Success = false
+		response.Message = fmt.Sprintf("Device id '%s' has not retrieved its info yet.", deviceID)
+		w.WriteHeader(503)
 	} else {
+		alarmInfo := alarm.ShowInfo()
 		response.Success = true
-		response.Firing = manager.AlarmsInfo[deviceID].ShowInfo().Firing
-		response.Online = manager.AlarmsInfo[deviceID].ShowInfo().Online
-		response.Mode = AlarmModeAlarmValues[manager.AlarmsInfo[deviceID].ShowInfo().Mode]
+		response.Firing = alarmInfo.Firing
+		response.Online = alarmInfo.Online
+		response.Mode = AlarmModeAlarmValues[alarmInfo.Mode]
+		response.PowerSource = PowerSourceValues[alarmInfo.PowerSource]
+		response.LowBattery = alarmInfo.LowBattery
+		response.Reason = alarmInfo.Reason
+		response.Capabilities = alarm.Capabilities()
 	}
 	jsonString, _ := json.Marshal(response)
 	w.Write([]byte(jsonString))
@@ -322,30 +514,59 @@ func (manager *DeviceManager) UpdateStatus(w http.ResponseWriter, r *http.Reques
 		response.Success = false
 		response.Message = "Failed to decode Response"
 		w.WriteHeader(400)
+	} else if _, ok := manager.DevicesInfo[deviceID]; !ok {
+		response.Success = false
+		response.Message = fmt.Sprintf("Device id '%s' does not exist.", deviceID)
+		w.WriteHeader(404)
+	} else if alarmDevice, ok := manager.Snapshot().Alarm(deviceID); !ok {
+		response.Success = false
+		response.Message = fmt.Sprintf("Device id '%s' has not retrieved its info yet.", deviceID)
+		w.WriteHeader(503)
+	} else if r.URL.Query().Get("async") == "true" {
+		manager.enqueueModeChange(w, r, deviceID, deviceChangeMode.Mode, alarmDevice.ShowInfo().Mode)
+		return
 	} else {
-		var client http.Client
+		client := manager.httpClient()
+		previousMode := alarmDevice.ShowInfo().Mode
 		currentDeviceSratus := AlarmModeAlarmValues[AlarmModeMap[deviceChangeMode.Mode]]
-		if currentDeviceSratus == AlarmModeAlarmValues[manager.AlarmsInfo[deviceID].ShowInfo().Mode] {
+		if currentDeviceSratus == AlarmModeAlarmValues[previousMode] {
 			response.Success = true
 			response.Message = "Device status has not changed."
 			w.WriteHeader(400)
 		} else {
-			changeModeErr := manager.ChangeMode(client, deviceID, deviceChangeMode.Mode)
+			ctx := events.WithActor(r.Context(), webtls.Identity(r))
+			changeModeErr := manager.ChangeMode(ctx, client, deviceID, deviceChangeMode.Mode)
 			if changeModeErr != nil {
 				response.Message = changeModeErr.Error()
-				w.WriteHeader(400)
+				writeErrorHeader(w, changeModeErr, 400)
 			} else {
-				time.Sleep(1 * time.Second)
-				retrieveInfoError := manager.RetrieveInfo(client)
-				if retrieveInfoError != nil {
+				confirmation, confirmError := manager.ConfirmMode(ctx, client, deviceID, deviceChangeMode.Mode, previousMode)
+				if confirmError != nil {
 					response.Success = false
-					response.Message = retrieveInfoError.Error()
-					w.WriteHeader(400)
+					response.Message = confirmError.Error()
+					writeErrorHeader(w, confirmError, 400)
 				} else {
-					response.Success = true
-					response.Firing = manager.AlarmsInfo[deviceID].ShowInfo().Firing
-					response.Online = manager.AlarmsInfo[deviceID].ShowInfo().Online
-					response.Mode = AlarmModeAlarmValues[manager.AlarmsInfo[deviceID].ShowInfo().Mode]
+					alarm, _ := manager.Snapshot().Alarm(deviceID)
+					alarmInfo := alarm.ShowInfo()
+					response.Firing = alarmInfo.Firing
+					response.Online = alarmInfo.Online
+					response.Mode = AlarmModeAlarmValues[alarmInfo.Mode]
+					response.PowerSource = PowerSourceValues[alarmInfo.PowerSource]
+					response.LowBattery = alarmInfo.LowBattery
+					response.Reason = alarmInfo.Reason
+					response.Confirmation = ModeConfirmationValues[confirmation]
+					switch confirmation {
+					case Confirmed:
+						response.Success = true
+					case Pending:
+						response.Success = true
+						response.Message = "Mode change has been sent but device has not confirmed it yet."
+						w.WriteHeader(202)
+					case Contradicted:
+						response.Success = false
+						response.Message = fmt.Sprintf("Device reports mode '%s' instead of requested one.", response.Mode)
+						w.WriteHeader(409)
+					}
 				}
 			}
 		}
@@ -354,3 +575,43 @@ func (manager *DeviceManager) UpdateStatus(w http.ResponseWriter, r *http.Reques
 	jsonString, _ := json.Marshal(response)
 	w.Write([]byte(jsonString))
 }
+
+// enqueueModeChange answers an asynchronous mode change request with 202 and
+// the job which runs it.
+func (manager *DeviceManager) enqueueModeChange(w http.ResponseWriter, r *http.Request, deviceID string, newMode string, previousMode AlarmMode) {
+	var response JobResponse
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if manager.Jobs == nil {
+		response.Success = false
+		response.Message = "Asynchronous mode changes are not available."
+		w.WriteHeader(400)
+	} else if job, ok := manager.Jobs.Lookup(idempotencyKey); ok && job.DeviceID == deviceID && job.Mode == newMode {
+		// Retried request, command has already been queued
+		response.Success = true
+		response.Job = &job
+		w.WriteHeader(202)
+	} else if _, ok := AlarmModeMap[newMode]; !ok {
+		response.Success = false
+		response.Message = fmt.Sprintf("Alarm mode '%s' is not defined.", newMode)
+		w.WriteHeader(400)
+	} else if AlarmModeMap[newMode] == previousMode {
+		response.Success = true
+		response.Message = "Device status has not changed."
+		w.WriteHeader(400)
+	} else if job, enqueueErr := manager.Jobs.Enqueue(deviceID, newMode, previousMode, idempotencyKey, webtls.Identity(r)); errors.Is(enqueueErr, ErrJobsClosed) {
+		response.Success = false
+		response.Message = enqueueErr.Error()
+		w.WriteHeader(503)
+	} else if enqueueErr != nil {
+		response.Success = false
+		response.Message = enqueueErr.Error()
+		w.WriteHeader(409)
+	} else {
+		response.Success = true
+		response.Job = &job
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		w.WriteHeader(202)
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
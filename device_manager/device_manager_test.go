@@ -2,11 +2,15 @@ package devices
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	config "github.com/a-castellano/AlarmManager/config_reader"
 	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
@@ -38,7 +42,7 @@ func TestCreateTuyaDevice(t *testing.T) {
 
 func TestAddOneDevice(t *testing.T) {
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
 
@@ -51,7 +55,7 @@ func TestAddOneDevice(t *testing.T) {
 
 func TestAddTwoDevicesWithSameName(t *testing.T) {
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
 
@@ -69,9 +73,9 @@ func TestAddTwoDevicesWithSameName(t *testing.T) {
 
 func TestStart(t *testing.T) {
 
-	client := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -79,7 +83,7 @@ func TestStart(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	startError := deviceManager.Start(client)
+	startError := deviceManager.Start(context.Background(), client)
 
 	if startError != nil {
 		t.Errorf("Device Manager start should not fail. Error was %s", startError)
@@ -89,9 +93,9 @@ func TestStart(t *testing.T) {
 
 func TestStartFailedToken(t *testing.T) {
 
-	client := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593`))}}}
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -99,7 +103,7 @@ func TestStartFailedToken(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	startError := deviceManager.Start(client)
+	startError := deviceManager.Start(context.Background(), client)
 
 	if startError == nil {
 		t.Errorf("Device Manager start should fail.")
@@ -109,11 +113,11 @@ func TestStartFailedToken(t *testing.T) {
 
 func TestRetrieveInfo(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -122,9 +126,9 @@ func TestRetrieveInfo(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	retrieveInfoError := deviceManager.RetrieveInfo(clientRetrieveInfo)
+	retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 	if retrieveInfoError != nil {
 		t.Errorf("Device Manager info retrieval should not fail. Error was %s", retrieveInfoError)
 	}
@@ -133,11 +137,11 @@ func TestRetrieveInfo(t *testing.T) {
 
 func TestRetrieveInfoFailed(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -146,9 +150,9 @@ func TestRetrieveInfoFailed(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	retrieveInfoError := deviceManager.RetrieveInfo(clientRetrieveInfo)
+	retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 	if retrieveInfoError == nil {
 		t.Errorf("Device Manager info retrieval should fail.")
 	}
@@ -157,11 +161,11 @@ func TestRetrieveInfoFailed(t *testing.T) {
 
 func TestRetrieveInfoFailedBecauseUnknownDeviceType(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -170,9 +174,9 @@ func TestRetrieveInfoFailedBecauseUnknownDeviceType(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	retrieveInfoError := deviceManager.RetrieveInfo(clientRetrieveInfo)
+	retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 	if retrieveInfoError.Error() != "Alarm Test Device type unknown not supported" {
 		t.Errorf("Device Manager info retrieval should fail with error 'Alarm Test Device type unknown not supported', error was '%s'", retrieveInfoError)
 	}
@@ -181,11 +185,11 @@ func TestRetrieveInfoFailedBecauseUnknownDeviceType(t *testing.T) {
 
 func TestRetrieveInfoAlarmDisarmed(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -195,12 +199,12 @@ func TestRetrieveInfoAlarmDisarmed(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	fmt.Println(deviceManager.AlarmsInfo)
-	alarmInfo := deviceManager.AlarmsInfo["testid123"]
+	fmt.Println(deviceManager.Snapshot().Alarms)
+	alarmInfo := deviceManager.Snapshot().Alarms["testid123"]
 
 	if alarmInfo.ShowInfo().Firing != false {
 		t.Errorf("Alarm shouldn't be firing.")
@@ -214,11 +218,11 @@ func TestRetrieveInfoAlarmDisarmed(t *testing.T) {
 
 func TestRetrieveInfoAlarmHome(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"home"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"home"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -228,11 +232,11 @@ func TestRetrieveInfoAlarmHome(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	alarmInfo := deviceManager.AlarmsInfo["testid123"]
+	alarmInfo := deviceManager.Snapshot().Alarms["testid123"]
 
 	if alarmInfo.ShowInfo().Firing != false {
 		t.Errorf("Alarm shouldn't be firing.")
@@ -246,11 +250,11 @@ func TestRetrieveInfoAlarmHome(t *testing.T) {
 
 func TestRetrieveInfoAlarmArm(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"arm"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"arm"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -260,11 +264,11 @@ func TestRetrieveInfoAlarmArm(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	alarmInfo := deviceManager.AlarmsInfo["testid123"]
+	alarmInfo := deviceManager.Snapshot().Alarms["testid123"]
 
 	if alarmInfo.ShowInfo().Firing != false {
 		t.Errorf("Alarm shouldn't be firing.")
@@ -278,11 +282,11 @@ func TestRetrieveInfoAlarmArm(t *testing.T) {
 
 func TestRetrieveInfoAlarmArmFiring(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"arm"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"alarm"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"arm"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"alarm"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -292,11 +296,11 @@ func TestRetrieveInfoAlarmArmFiring(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	alarmInfo := deviceManager.AlarmsInfo["testid123"]
+	alarmInfo := deviceManager.Snapshot().Alarms["testid123"]
 
 	if alarmInfo.ShowInfo().Firing != true {
 		t.Errorf("Alarm should be firing.")
@@ -310,9 +314,9 @@ func TestRetrieveInfoAlarmArmFiring(t *testing.T) {
 
 func TestChangeAlarmModeNonRetrievedInfo(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -322,10 +326,10 @@ func TestChangeAlarmModeNonRetrievedInfo(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
-	changeModeError := deviceManager.ChangeMode(clientChangueModeResponse, "NonExistentDevice", "NonExistentMode")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	changeModeError := deviceManager.ChangeMode(context.Background(), clientChangueModeResponse, "NonExistentDevice", "NonExistentMode")
 	if changeModeError == nil {
 		t.Errorf("Device Manager change mode should fail.")
 	} else {
@@ -338,11 +342,11 @@ func TestChangeAlarmModeNonRetrievedInfo(t *testing.T) {
 
 func TestChangeAlarmModeNonExistentDevice(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -352,12 +356,12 @@ func TestChangeAlarmModeNonExistentDevice(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(``))}}}
-	changeModeError := deviceManager.ChangeMode(clientChangueModeResponse, "NonExistentDevice", "NonExistentMode")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(``))}}}
+	changeModeError := deviceManager.ChangeMode(context.Background(), clientChangueModeResponse, "NonExistentDevice", "NonExistentMode")
 	if changeModeError == nil {
 		t.Errorf("Device Manager change mode should fail.")
 	} else {
@@ -370,11 +374,11 @@ func TestChangeAlarmModeNonExistentDevice(t *testing.T) {
 
 func TestChangeAlarmModeNonExistentMode(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -384,12 +388,12 @@ func TestChangeAlarmModeNonExistentMode(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(``))}}}
-	changeModeError := deviceManager.ChangeMode(clientChangueModeResponse, "testid123", "NonExistentMode")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(``))}}}
+	changeModeError := deviceManager.ChangeMode(context.Background(), clientChangueModeResponse, "testid123", "NonExistentMode")
 
 	if changeModeError == nil {
 		t.Errorf("Device Manager change mode should fail bacause mode is 'NonExistentMode'.")
@@ -402,11 +406,11 @@ func TestChangeAlarmModeNonExistentMode(t *testing.T) {
 
 func TestChangeAlarmFailed(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -416,12 +420,12 @@ func TestChangeAlarmFailed(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`))}}}
-	changeModeError := deviceManager.ChangeMode(clientChangueModeResponse, "testid123", "Disarmed")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`))}}}
+	changeModeError := deviceManager.ChangeMode(context.Background(), clientChangueModeResponse, "testid123", "Disarmed")
 
 	if changeModeError == nil {
 		t.Errorf("Device Manager change mode should fail bacause call failed.")
@@ -434,11 +438,11 @@ func TestChangeAlarmFailed(t *testing.T) {
 
 func TestChangeAlarmFailedBecauseCorruptJson(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -448,12 +452,12 @@ func TestChangeAlarmFailedBecauseCorruptJson(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"`))}}}
-	changeModeError := deviceManager.ChangeMode(clientChangueModeResponse, "idtest123", "Disarmed")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"`))}}}
+	changeModeError := deviceManager.ChangeMode(context.Background(), clientChangueModeResponse, "idtest123", "Disarmed")
 
 	if changeModeError == nil {
 		t.Errorf("Device Manager change mode should fail bacause call failed.")
@@ -466,11 +470,11 @@ func TestChangeAlarmFailedBecauseCorruptJson(t *testing.T) {
 
 func TestChangeAlarm(t *testing.T) {
 
-	clientGetToken := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
-	clientRetrieveInfo := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
-	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]Alarm)}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
 
 	var device tuyadevice.TuyaDevice
 	device.Name = "Test Device"
@@ -480,14 +484,204 @@ func TestChangeAlarm(t *testing.T) {
 	deviceRef := &device
 	deviceManager.AddDevice(deviceRef)
 
-	deviceManager.Start(clientGetToken)
+	deviceManager.Start(context.Background(), clientGetToken)
 
-	deviceManager.RetrieveInfo(clientRetrieveInfo)
+	deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo)
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":true,"success":true,"t":1653184890385,"tid":"18dd6963d97311eca734f2b4cd1fee5a"}`))}}}
-	changeModeError := deviceManager.ChangeMode(clientChangueModeResponse, "idtest123", "Disarmed")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":true,"success":true,"t":1653184890385,"tid":"18dd6963d97311eca734f2b4cd1fee5a"}`))}}}
+	changeModeError := deviceManager.ChangeMode(context.Background(), clientChangueModeResponse, "idtest123", "Disarmed")
 
 	if changeModeError != nil {
 		t.Errorf("Device Manager change mode shouldn't fail.")
 	}
 }
+
+// SequenceRoundTripperMock returns a fresh response for each body, the last
+// body is repeated once the sequence is exhausted.
+type SequenceRoundTripperMock struct {
+	Bodies []string
+	calls  int
+}
+
+func (srtm *SequenceRoundTripperMock) RoundTrip(*http.Request) (*http.Response, error) {
+	index := srtm.calls
+	if index >= len(srtm.Bodies) {
+		index = len(srtm.Bodies) - 1
+	}
+	srtm.calls++
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(srtm.Bodies[index]))}, nil
+}
+
+func alarmStatusJSON(mode string, state string) string {
+	return fmt.Sprintf(`{"result":{"category":"mal","id":"testid123","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST","name":"Multifunction alarm","online":true,"owner_id":"11154007","status":[{"code":"master_mode","value":"%s"},{"code":"master_state","value":"%s"}]},"success":true,"t":1645128085588}`, mode, state)
+}
+
+func confirmModeManager(t *testing.T, initialMode string) *DeviceManager {
+	clientGetToken := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	clientRetrieveInfo := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{alarmStatusJSON(initialMode, "normal")}}}
+
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), ConfirmationTimeout: 50 * time.Millisecond, ConfirmationInterval: 10 * time.Millisecond}
+
+	var device tuyadevice.TuyaDevice
+	device.Name = "Test Device"
+	device.DeviceType = "99AST"
+	device.DeviceID = "testid123"
+
+	deviceManager.AddDevice(&device)
+	deviceManager.Start(context.Background(), clientGetToken)
+	if retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), clientRetrieveInfo); retrieveInfoError != nil {
+		t.Fatalf("Device Manager info retrieval should not fail. Error was %s", retrieveInfoError)
+	}
+	return &deviceManager
+}
+
+func TestConfirmModeConfirmed(t *testing.T) {
+
+	deviceManager := confirmModeManager(t, "disarmed")
+
+	client := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{alarmStatusJSON("disarmed", "normal"), alarmStatusJSON("arm", "normal")}}}
+	confirmation, confirmError := deviceManager.ConfirmMode(context.Background(), client, "testid123", "Armed", Disarmed)
+
+	if confirmError != nil {
+		t.Errorf("Mode confirmation shouldn't fail. Error was %s", confirmError)
+	}
+	if confirmation != Confirmed {
+		t.Errorf("Mode change should be confirmed, not %s.", ModeConfirmationValues[confirmation])
+	}
+	if deviceManager.Snapshot().Alarms["testid123"].ShowInfo().Mode != FullyArmed {
+		t.Errorf("Alarm should be FullyArmed after confirmation.")
+	}
+}
+
+func TestConfirmModePending(t *testing.T) {
+
+	deviceManager := confirmModeManager(t, "disarmed")
+
+	client := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{alarmStatusJSON("disarmed", "normal")}}}
+	start := time.Now()
+	confirmation, confirmError := deviceManager.ConfirmMode(context.Background(), client, "testid123", "Armed", Disarmed)
+
+	if confirmError != nil {
+		t.Errorf("Mode confirmation shouldn't fail. Error was %s", confirmError)
+	}
+	if confirmation != Pending {
+		t.Errorf("Mode change should be pending, not %s.", ModeConfirmationValues[confirmation])
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Mode confirmation should stop polling once its deadline has passed.")
+	}
+}
+
+func TestConfirmModePollsUntilDeadline(t *testing.T) {
+
+	deviceManager := confirmModeManager(t, "disarmed")
+	deviceManager.ConfirmationTimeout = 20 * time.Millisecond
+	deviceManager.ConfirmationInterval = 10 * time.Millisecond
+
+	transport := &SequenceRoundTripperMock{Bodies: []string{alarmStatusJSON("disarmed", "normal")}}
+	confirmation, confirmError := deviceManager.ConfirmMode(context.Background(), &http.Client{Transport: transport}, "testid123", "Armed", Disarmed)
+
+	if confirmError != nil || confirmation != Pending {
+		t.Errorf("Mode change should be pending, confirmation was %s and error '%v'.", ModeConfirmationValues[confirmation], confirmError)
+	}
+	if transport.calls != 3 {
+		t.Errorf("Device should be polled at start, after one interval and at the deadline, it was polled %d times.", transport.calls)
+	}
+}
+
+func TestConfirmModeContradicted(t *testing.T) {
+
+	deviceManager := confirmModeManager(t, "disarmed")
+
+	client := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{alarmStatusJSON("home", "normal")}}}
+	confirmation, confirmError := deviceManager.ConfirmMode(context.Background(), client, "testid123", "Armed", Disarmed)
+
+	if confirmError != nil {
+		t.Errorf("Mode confirmation shouldn't fail. Error was %s", confirmError)
+	}
+	if confirmation != Contradicted {
+		t.Errorf("Mode change should be contradicted, not %s.", ModeConfirmationValues[confirmation])
+	}
+}
+
+func TestConfirmModeFailedBecauseCorruptJson(t *testing.T) {
+
+	deviceManager := confirmModeManager(t, "disarmed")
+
+	client := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{`{"result":`}}}
+	_, confirmError := deviceManager.ConfirmMode(context.Background(), client, "testid123", "Armed", Disarmed)
+
+	if confirmError == nil {
+		t.Errorf("Mode confirmation should fail with corrupt device info.")
+	}
+}
+
+func TestDecodeAlarmMessage(t *testing.T) {
+
+	if reason := decodeAlarmMessage("AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="); reason != "APP Desermado" {
+		t.Errorf("Alarm message should be decoded as 'APP Desermado', not '%s'.", reason)
+	}
+	if reason := decodeAlarmMessage("not base64"); reason != "not base64" {
+		t.Errorf("Invalid alarm messages should be returned as is, not '%s'.", reason)
+	}
+}
+
+func TestWriteErrorHeader(t *testing.T) {
+	statusCodes := map[error]int{
+		&tuyadevice.APIError{Code: tuyadevice.CodeDeviceOffline}:                                              503,
+		&tuyadevice.APIError{Code: tuyadevice.CodeRateLimited}:                                                429,
+		&tuyadevice.APIError{Code: tuyadevice.CodePermissionDenied}:                                           403,
+		&tuyadevice.APIError{Code: tuyadevice.CodeParamIllegal}:                                               422,
+		&tuyadevice.APIError{Code: tuyadevice.CodeTokenExpired}:                                               502,
+		fmt.Errorf("Device failed, error was '%w'.", &tuyadevice.APIError{Code: tuyadevice.CodeTokenInvalid}): 502,
+		fmt.Errorf("Unknown error."):                                                                          400,
+	}
+	for err, statusCode := range statusCodes {
+		recorder := httptest.NewRecorder()
+		writeErrorHeader(recorder, err, 400)
+		if recorder.Code != statusCode {
+			t.Errorf("Error '%v' should return status %d, returned %d.", err, statusCode, recorder.Code)
+		}
+	}
+}
+
+// BlockingRoundTripperMock answers once the request is cancelled.
+type BlockingRoundTripperMock struct{}
+
+func (brtm BlockingRoundTripperMock) RoundTrip(request *http.Request) (*http.Response, error) {
+	<-request.Context().Done()
+	return nil, request.Context().Err()
+}
+
+func TestUpdateStatusCancelled(t *testing.T) {
+	deviceManager := jobsManager(t, &AlarmRoundTripperMock{Mode: "disarmed"})
+	deviceManager.Client = &http.Client{Transport: BlockingRoundTripperMock{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	deviceManager.Routes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/status/testid123", bytes.NewBufferString(`{"mode": "Armed"}`)).WithContext(ctx))
+	if recorder.Code != 504 {
+		t.Errorf("Mode change should return 504 when request deadline passes, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Mode change should stop when request deadline passes, it took %s.", elapsed)
+	}
+}
+
+func TestUpdateStatusBeforeInfo(t *testing.T) {
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Test Device", DeviceType: "99AST", DeviceID: "testid123"})
+
+	recorder := httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/status/testid123", bytes.NewBufferString(`{"mode": "Armed"}`)))
+	if recorder.Code != 503 || !strings.Contains(recorder.Body.String(), "has not retrieved its info yet") {
+		t.Errorf("Mode change before device info is retrieved should return 503, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/status/unknown", bytes.NewBufferString(`{"mode": "Armed"}`)))
+	if recorder.Code != 404 {
+		t.Errorf("Mode change of unknown device should return 404, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+}
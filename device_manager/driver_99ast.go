@@ -0,0 +1,84 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	RegisterDriver("99AST", NewDriver99AST)
+}
+
+type Alarm99ASTResult struct {
+	ActiveTime  int     `json:"active_time"`
+	BizTime     int     `json:"biz_type"`
+	Category    string  `json:"category"`
+	CreateTime  int     `json:"create_time"`
+	Icon        string  `json:"icon"`
+	ID          string  `json:"id"`
+	IP          string  `json:"ip"`
+	Latitude    float32 `json:"lat,string"`
+	Longitude   float32 `json:"lon,string"`
+	LocalKey    string  `json:"local_key"`
+	Model       string  `json:"model"`
+	Name        string  `json:"name"`
+	Online      bool    `json:"online"`
+	OwnerID     int     `json:"owner_id,string"`
+	ProductID   string  `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Sub         bool    `json:"sub"`
+	TimeZone    string  `json:"time_zone"`
+	UID         string  `json:"uid"`
+	UpdateTime  int     `json:"update_time"`
+	UUID        string  `json:"uuid"`
+	Status      []struct {
+		Code  string      `json:"code"`
+		Value interface{} `json:"value"`
+	} `json:"status"`
+}
+
+type Alarm99AST struct {
+	Result  Alarm99ASTResult `json:"result"`
+	Success bool             `json:"success"`
+	Time    int              `json:"t"`
+}
+
+var alarm99ASTModes = map[AlarmMode]string{
+	FullyArmed: "arm",
+	Disarmed:   "disarmed",
+	HomeArmed:  "home",
+	Sos:        "sos",
+}
+
+// Driver99AST handles 99AST multifunction alarms.
+type Driver99AST struct{}
+
+func NewDriver99AST() Driver {
+	return Driver99AST{}
+}
+
+func (driver Driver99AST) ParseInfo(deviceInfo []byte) (AlarmInfo, error) {
+	return parseStandardInfo(deviceInfo, driver.AlarmMode)
+}
+
+func (driver Driver99AST) DeviceMode(mode AlarmMode) (string, error) {
+	value, ok := alarm99ASTModes[mode]
+	if !ok {
+		errorString := fmt.Sprintf("Alarm mode '%d' is not supported by 99AST alarms.", mode)
+		return "", errors.New(errorString)
+	}
+	return value, nil
+}
+
+func (driver Driver99AST) AlarmMode(value string) AlarmMode {
+	for mode, modeValue := range alarm99ASTModes {
+		if modeValue == value {
+			return mode
+		}
+	}
+	return Unknown
+}
+
+func (driver Driver99AST) Capabilities() []Capability {
+	return []Capability{ModeControl, FiringReport, AlarmMessage, PowerReport, BatteryReport}
+}
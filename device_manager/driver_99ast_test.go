@@ -0,0 +1,56 @@
+package devices
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDriver99ASTParseInfo(t *testing.T) {
+	var driver Driver99AST
+
+	alarmInfo, parseErr := driver.ParseInfo([]byte(powerStatusJSON(false, true)))
+	if parseErr != nil {
+		t.Fatalf("99AST info should be parsed, error was '%s'.", parseErr)
+	}
+	if alarmInfo.Mode != FullyArmed || alarmInfo.Firing || !alarmInfo.Online || alarmInfo.PowerSource != BatteryPower || !alarmInfo.LowBattery {
+		t.Errorf("99AST info was not parsed properly, it was %+v.", alarmInfo)
+	}
+	if alarmInfo.IP != "199.46.115.128" || alarmInfo.LocalKey != "bc10cf0dca9aa13f" {
+		t.Errorf("99AST device details were not parsed properly, they were %+v.", alarmInfo)
+	}
+	if _, parseErr := driver.ParseInfo([]byte(`{"result":`)); parseErr == nil {
+		t.Errorf("Corrupt info should not be parsed.")
+	}
+}
+
+func TestDriver99ASTAlarmMessage(t *testing.T) {
+	var driver Driver99AST
+
+	staleMessage := strings.Replace(alarmStatusJSON("disarmed", "normal"), `"value":"normal"}`, `"value":"normal"},{"code":"alarm_msg","value":"AFstale"}`, 1)
+	if alarmInfo, _ := driver.ParseInfo([]byte(staleMessage)); alarmInfo.Firing {
+		t.Errorf("Alarm messages reported after master state should not make the alarm fire, info was %+v.", alarmInfo)
+	}
+	alarmMessage := strings.Replace(alarmStatusJSON("arm", "normal"), `"status":[`, `"status":[{"code":"alarm_msg","value":"AFzone"},`, 1)
+	if alarmInfo, _ := driver.ParseInfo([]byte(alarmMessage)); !alarmInfo.Firing {
+		t.Errorf("Alarm messages reported before master state should make the alarm fire, info was %+v.", alarmInfo)
+	}
+}
+
+func TestDriver99ASTModes(t *testing.T) {
+	var driver Driver99AST
+
+	for mode, value := range alarm99ASTModes {
+		if deviceMode, modeErr := driver.DeviceMode(mode); modeErr != nil || deviceMode != value {
+			t.Errorf("Mode %d should be '%s', it was '%s'.", mode, value, deviceMode)
+		}
+		if driver.AlarmMode(value) != mode {
+			t.Errorf("Value '%s' should be mode %d.", value, mode)
+		}
+	}
+	if driver.AlarmMode("party") != Unknown {
+		t.Errorf("Unknown values should be Unknown mode.")
+	}
+	if _, modeErr := driver.DeviceMode(Unknown); modeErr == nil {
+		t.Errorf("Unknown mode should have no device value.")
+	}
+}
@@ -0,0 +1,190 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterCategoryDriver("mal", NewDriverMal)
+}
+
+// parseStandardInfo parses device details using Tuya standard data points
+// of the mal category, modeOf translates master_mode values.
+func parseStandardInfo(deviceInfo []byte, modeOf func(string) AlarmMode) (AlarmInfo, error) {
+	alarm := Alarm99AST{}
+	if unmarshalErr := json.Unmarshal(deviceInfo, &alarm); unmarshalErr != nil {
+		return AlarmInfo{}, unmarshalErr
+	}
+	alarmInfo := AlarmInfo{IP: alarm.Result.IP, LocalKey: alarm.Result.LocalKey, Latitude: alarm.Result.Latitude, Longitude: alarm.Result.Longitude, Online: alarm.Result.Online}
+
+	var masterStateSet, masterModeSet, alarmMessageSet bool
+	for _, statusTuple := range alarm.Result.Status {
+		switch statusTuple.Code {
+		case "master_mode":
+			alarmInfo.Mode = modeOf(fmt.Sprintf("%v", statusTuple.Value))
+			masterModeSet = true
+		case "master_state":
+			alarmInfo.Firing = fmt.Sprintf("%v", statusTuple.Value) == "alarm"
+			masterStateSet = true
+		case "alarm_msg":
+			alarmMessageValue := fmt.Sprintf("%v", statusTuple.Value)
+			alarmInfo.Reason = decodeAlarmMessage(alarmMessageValue)
+			// Messages reported after master_mode and master_state are
+			// stale, they do not make the alarm fire
+			if !masterStateSet || !masterModeSet {
+				alarmMessageSet = strings.HasPrefix(alarmMessageValue, "AF")
+			}
+		case "charge_state":
+			if fmt.Sprintf("%v", statusTuple.Value) == "true" {
+				alarmInfo.PowerSource = MainsPower
+			} else {
+				alarmInfo.PowerSource = BatteryPower
+			}
+		case "switch_low_battery":
+			alarmInfo.LowBattery = fmt.Sprintf("%v", statusTuple.Value) == "true"
+		}
+	}
+	if !alarmInfo.Firing && alarmMessageSet {
+		alarmInfo.Firing = true
+	}
+	return alarmInfo, nil
+}
+
+// malModeValues are the master_mode values panels use for each mode, first
+// ones are Tuya standard values.
+var malModeValues = map[AlarmMode][]string{
+	FullyArmed: {"arm", "away", "armed"},
+	Disarmed:   {"disarmed", "disarm"},
+	HomeArmed:  {"home", "stay"},
+	Sos:        {"sos", "panic"},
+}
+
+// Data points reported for each capability.
+var malCapabilityCodes = map[Capability]string{
+	FiringReport:  "master_state",
+	AlarmMessage:  "alarm_msg",
+	PowerReport:   "charge_state",
+	BatteryReport: "switch_low_battery",
+}
+
+type malSpecification struct {
+	Result struct {
+		Category  string `json:"category"`
+		Functions []struct {
+			Code   string `json:"code"`
+			Type   string `json:"type"`
+			Values string `json:"values"`
+		} `json:"functions"`
+		Status []struct {
+			Code string `json:"code"`
+		} `json:"status"`
+	} `json:"result"`
+	Success bool   `json:"success"`
+	Message string `json:"msg"`
+}
+
+// DriverMal handles any alarm panel of Tuya mal category using standard
+// data points. Until it learns the device specification it assumes every
+// standard mode and capability.
+type DriverMal struct {
+	modes        map[AlarmMode]string
+	capabilities []Capability
+}
+
+func NewDriverMal() Driver {
+	driver := &DriverMal{modes: make(map[AlarmMode]string)}
+	for mode, values := range malModeValues {
+		driver.modes[mode] = values[0]
+	}
+	driver.capabilities = []Capability{ModeControl, FiringReport, AlarmMessage, PowerReport, BatteryReport}
+	return driver
+}
+
+// LearnSpecification keeps the master_mode values of the device and the
+// capabilities its data points provide.
+func (driver *DriverMal) LearnSpecification(specification []byte) error {
+	var deviceSpecification malSpecification
+	if unmarshalErr := json.Unmarshal(specification, &deviceSpecification); unmarshalErr != nil {
+		return unmarshalErr
+	}
+	if !deviceSpecification.Success {
+		errorString := fmt.Sprintf("Device specification could not be retrieved, error was '%s'.", deviceSpecification.Message)
+		return errors.New(errorString)
+	}
+	codes := make(map[string]bool)
+	for _, status := range deviceSpecification.Result.Status {
+		codes[status.Code] = true
+	}
+
+	modes := make(map[AlarmMode]string)
+	var capabilities []Capability
+	for _, function := range deviceSpecification.Result.Functions {
+		codes[function.Code] = true
+		if function.Code != "master_mode" || function.Type != "Enum" {
+			continue
+		}
+		var values struct {
+			Range []string `json:"range"`
+		}
+		if unmarshalErr := json.Unmarshal([]byte(function.Values), &values); unmarshalErr != nil {
+			return unmarshalErr
+		}
+		for _, value := range values.Range {
+			if mode := malMode(value); mode != Unknown {
+				if _, ok := modes[mode]; !ok {
+					modes[mode] = value
+				}
+			}
+		}
+		capabilities = append(capabilities, ModeControl)
+	}
+	for _, capability := range []Capability{FiringReport, AlarmMessage, PowerReport, BatteryReport} {
+		if codes[malCapabilityCodes[capability]] {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	driver.modes = modes
+	driver.capabilities = capabilities
+	return nil
+}
+
+func malMode(value string) AlarmMode {
+	value = strings.ToLower(value)
+	for mode, values := range malModeValues {
+		for _, modeValue := range values {
+			if modeValue == value {
+				return mode
+			}
+		}
+	}
+	return Unknown
+}
+
+func (driver *DriverMal) ParseInfo(deviceInfo []byte) (AlarmInfo, error) {
+	return parseStandardInfo(deviceInfo, driver.AlarmMode)
+}
+
+func (driver *DriverMal) DeviceMode(mode AlarmMode) (string, error) {
+	value, ok := driver.modes[mode]
+	if !ok {
+		errorString := fmt.Sprintf("Alarm mode '%s' is not supported by the device.", AlarmModeAlarmValues[mode])
+		return "", errors.New(errorString)
+	}
+	return value, nil
+}
+
+func (driver *DriverMal) AlarmMode(value string) AlarmMode {
+	for mode, modeValue := range driver.modes {
+		if modeValue == value {
+			return mode
+		}
+	}
+	return malMode(value)
+}
+
+func (driver *DriverMal) Capabilities() []Capability {
+	return driver.capabilities
+}
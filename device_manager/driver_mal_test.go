@@ -0,0 +1,69 @@
+package devices
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+	"github.com/a-castellano/AlarmManager/tuyadevice/tuyatest"
+)
+
+const malSpecificationJSON = `{"result":{"category":"mal","functions":[{"code":"master_mode","type":"Enum","values":"{\"range\":[\"disarm\",\"away\",\"stay\"]}"}],"status":[{"code":"master_mode","type":"Enum","values":"{}"},{"code":"master_state","type":"Enum","values":"{}"}]},"success":true,"t":1653184890385}`
+
+func TestDriverMalLearnSpecification(t *testing.T) {
+	driver := NewDriverMal().(*DriverMal)
+
+	if value, _ := driver.DeviceMode(HomeArmed); value != "home" {
+		t.Errorf("Standard values should be used before learning specification, home armed was '%s'.", value)
+	}
+	if learnErr := driver.LearnSpecification([]byte(malSpecificationJSON)); learnErr != nil {
+		t.Fatalf("Specification should be learned, error was '%s'.", learnErr)
+	}
+	expectedModes := map[AlarmMode]string{FullyArmed: "away", Disarmed: "disarm", HomeArmed: "stay"}
+	for mode, expectedValue := range expectedModes {
+		if value, modeErr := driver.DeviceMode(mode); modeErr != nil || value != expectedValue {
+			t.Errorf("Mode %d should be '%s', it was '%s' with error '%v'.", mode, expectedValue, value, modeErr)
+		}
+		if driver.AlarmMode(expectedValue) != mode {
+			t.Errorf("Value '%s' should be mode %d.", expectedValue, mode)
+		}
+	}
+	if _, modeErr := driver.DeviceMode(Sos); modeErr == nil || modeErr.Error() != "Alarm mode 'sos' is not supported by the device." {
+		t.Errorf("Modes missing from specification should not be supported, error was '%v'.", modeErr)
+	}
+	if capabilities := driver.Capabilities(); len(capabilities) != 2 || capabilities[0] != ModeControl || capabilities[1] != FiringReport {
+		t.Errorf("Capabilities should be learned from specification, they were %v.", capabilities)
+	}
+	if learnErr := driver.LearnSpecification([]byte(`{"code":1106,"msg":"permission deny","success":false}`)); learnErr == nil {
+		t.Errorf("Failed specification responses should not be learned.")
+	}
+}
+
+func TestRetrieveInfoCategoryDriver(t *testing.T) {
+	cloud := tuyatest.NewServer("client123", "secret123")
+	defer cloud.Close()
+	panel := tuyatest.AlarmDevice("panel123", "Second House", "stay")
+	panel.Model = "AX-200"
+	panel.Functions[0].Values = `{"range":["disarm","away","stay"]}`
+	cloud.AddDevice(panel)
+	client := &http.Client{}
+
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Second House", DeviceType: "AX-200", Host: cloud.URL, ClientID: "client123", Secret: "secret123", DeviceID: "panel123"})
+	if retrieveErr := deviceManager.RetrieveInfo(context.Background(), client); retrieveErr != nil {
+		t.Fatalf("Standard mal devices should be supported, error was '%s'.", retrieveErr)
+	}
+	if info, _ := deviceManager.DeviceInfo("panel123"); info.Mode != HomeArmed || info.PowerSource != MainsPower {
+		t.Errorf("Second House should be home armed on mains power, info was %+v.", info)
+	}
+	if changeErr := deviceManager.ChangeMode(context.Background(), client, "panel123", "Armed"); changeErr != nil {
+		t.Fatalf("Mode change should not fail, error was '%s'.", changeErr)
+	}
+	if mode := cloud.StatusValue("panel123", "master_mode"); mode != "away" {
+		t.Errorf("Armed mode should be sent as 'away', device mode is '%v'.", mode)
+	}
+	if changeErr := deviceManager.ChangeMode(context.Background(), client, "panel123", "SOS"); changeErr == nil {
+		t.Errorf("Modes the device does not support should not be sent.")
+	}
+}
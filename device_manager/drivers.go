@@ -0,0 +1,150 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Capability is a feature supported by an alarm driver.
+type Capability int
+
+const (
+	ModeControl   Capability = iota + 1 // mode can be changed
+	FiringReport                        // firing state is reported
+	AlarmMessage                        // firing reason is reported
+	PowerReport                         // mains or battery power is reported
+	BatteryReport                       // low battery is reported
+)
+
+var CapabilityValues = map[Capability]string{
+	ModeControl:   "mode_control",
+	FiringReport:  "firing_report",
+	AlarmMessage:  "alarm_message",
+	PowerReport:   "power_report",
+	BatteryReport: "battery_report",
+}
+
+// Driver translates device details of an alarm model.
+type Driver interface {
+	// ParseInfo parses device details returned by GetDeviceInfo
+	ParseInfo(deviceInfo []byte) (AlarmInfo, error)
+	// DeviceMode returns the value device uses for mode
+	DeviceMode(mode AlarmMode) (string, error)
+	// AlarmMode returns the mode of a device value, Unknown when there is none
+	AlarmMode(value string) AlarmMode
+	Capabilities() []Capability
+}
+
+// SpecificationLearner is implemented by drivers which learn device
+// features from its specification before parsing device details.
+type SpecificationLearner interface {
+	LearnSpecification(specification []byte) error
+}
+
+// DriverFactory creates the driver of a device, drivers may keep what they
+// learn about the device.
+type DriverFactory func() Driver
+
+var (
+	driverFactories   = make(map[string]DriverFactory)
+	categoryFactories = make(map[string]DriverFactory)
+	driversMutex      sync.RWMutex
+)
+
+// RegisterDriver makes drivers created by factory handle devices of
+// deviceType. Drivers are registered from init functions of their files.
+func RegisterDriver(deviceType string, factory DriverFactory) error {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+	if _, ok := driverFactories[deviceType]; ok {
+		errorString := fmt.Sprintf("Driver for type '%s' is already registered.", deviceType)
+		return errors.New(errorString)
+	}
+	driverFactories[deviceType] = factory
+	return nil
+}
+
+// RegisterCategoryDriver makes drivers created by factory handle devices
+// of Tuya category whose type has no registered driver.
+func RegisterCategoryDriver(category string, factory DriverFactory) error {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+	if _, ok := categoryFactories[category]; ok {
+		errorString := fmt.Sprintf("Driver for category '%s' is already registered.", category)
+		return errors.New(errorString)
+	}
+	categoryFactories[category] = factory
+	return nil
+}
+
+// NewCategoryDriver creates a driver for Tuya category.
+func NewCategoryDriver(category string) (Driver, bool) {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+	factory, ok := categoryFactories[category]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// NewDriver creates a driver for deviceType.
+func NewDriver(deviceType string) (Driver, bool) {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+	factory, ok := driverFactories[deviceType]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// DriverTypes returns registered device types, sorted.
+func DriverTypes() []string {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+	deviceTypes := make([]string, 0, len(driverFactories))
+	for deviceType := range driverFactories {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	return deviceTypes
+}
+
+func hasCapability(driver Driver, capability Capability) bool {
+	for _, driverCapability := range driver.Capabilities() {
+		if driverCapability == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// DriverAlarm is the last retrieved info of a device and its driver.
+type DriverAlarm struct {
+	Info   AlarmInfo
+	Driver Driver
+}
+
+func (alarm DriverAlarm) ShowInfo() AlarmInfo {
+	return alarm.Info
+}
+
+func (alarm DriverAlarm) EquivalentMode(newMode string) (string, error) {
+	mode, ok := AlarmModeMap[newMode]
+	if !ok {
+		errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", newMode)
+		return "", errors.New(errorString)
+	}
+	return alarm.Driver.DeviceMode(mode)
+}
+
+func (alarm DriverAlarm) Capabilities() []string {
+	capabilities := []string{}
+	for _, capability := range alarm.Driver.Capabilities() {
+		capabilities = append(capabilities, CapabilityValues[capability])
+	}
+	return capabilities
+}
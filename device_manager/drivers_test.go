@@ -0,0 +1,64 @@
+package devices
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+// readOnlyDriver reports 99AST details but can't change mode.
+type readOnlyDriver struct {
+	Driver99AST
+}
+
+func (driver readOnlyDriver) Capabilities() []Capability {
+	return []Capability{FiringReport}
+}
+
+func init() {
+	RegisterDriver("readonly", func() Driver { return readOnlyDriver{} })
+}
+
+func TestRegisterDriver(t *testing.T) {
+	if registerErr := RegisterDriver("99AST", NewDriver99AST); registerErr == nil {
+		t.Errorf("Registering a driver twice for the same type should fail.")
+	}
+	if _, ok := NewDriver("unknown"); ok {
+		t.Errorf("Unregistered types should have no driver.")
+	}
+	deviceTypes := DriverTypes()
+	if len(deviceTypes) < 3 || deviceTypes[0] != "99AST" {
+		t.Errorf("99AST, readonly and simulated types should be registered, types were %v.", deviceTypes)
+	}
+}
+
+func TestDriverAlarmEquivalentMode(t *testing.T) {
+	alarm := DriverAlarm{Driver: Driver99AST{}}
+
+	if value, modeErr := alarm.EquivalentMode("HomeArmed"); modeErr != nil || value != "home" {
+		t.Errorf("HomeArmed should be 'home' on 99AST alarms, it was '%s' with error '%v'.", value, modeErr)
+	}
+	if _, modeErr := alarm.EquivalentMode("Party"); modeErr == nil || modeErr.Error() != "Alarm mode 'Party' is not defined." {
+		t.Errorf("Undefined modes should fail, error was '%v'.", modeErr)
+	}
+	if capabilities := alarm.Capabilities(); len(capabilities) != 5 || capabilities[0] != "mode_control" {
+		t.Errorf("99AST capabilities were not listed properly, they were %v.", capabilities)
+	}
+}
+
+func TestChangeModeWithoutModeControl(t *testing.T) {
+	transport := &AlarmRoundTripperMock{Mode: "arm"}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Test Device", DeviceType: "readonly", DeviceID: "testid123"})
+	deviceManager.RetrieveInfo(context.Background(), &http.Client{Transport: transport})
+
+	if info, ok := deviceManager.DeviceInfo("testid123"); !ok || info.Mode != FullyArmed {
+		t.Fatalf("Registered drivers should parse device info, info was %+v.", info)
+	}
+	changeErr := deviceManager.ChangeMode(context.Background(), &http.Client{Transport: transport}, "testid123", "Disarmed")
+	if changeErr == nil || changeErr.Error() != "Device 'Test Device' mode can't be changed." {
+		t.Errorf("Devices without mode control should not change mode, error was '%v'.", changeErr)
+	}
+}
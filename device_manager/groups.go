@@ -0,0 +1,284 @@
+package devices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/webtls"
+	chi "github.com/go-chi/chi/v5"
+)
+
+// Group is a set of devices which are always armed and disarmed together.
+type Group struct {
+	Name      string
+	DeviceIDs []string
+}
+
+type GroupMemberResult struct {
+	DeviceID     string `json:"device_id"`
+	Name         string `json:"name"`
+	Success      bool   `json:"success"`
+	Message      string `json:"msg"`
+	PreviousMode string `json:"previous_mode"`
+	Mode         string `json:"mode"`
+	Firing       bool   `json:"firing"`
+	Online       bool   `json:"online"`
+	Confirmation string `json:"confirmation,omitempty"`
+	RolledBack   bool   `json:"rolled_back,omitempty"`
+}
+
+func (manager *DeviceManager) AddGroup(groupID string, group Group) error {
+	if manager.Groups == nil {
+		manager.Groups = make(map[string]Group)
+	}
+	if _, ok := manager.Groups[groupID]; ok {
+		return fmt.Errorf("Group '%s' has already been added to device manager.", groupID)
+	}
+	for _, deviceID := range group.DeviceIDs {
+		if _, ok := manager.DevicesInfo[deviceID]; !ok {
+			return fmt.Errorf("Group '%s' device id '%s' is not a managed device.", groupID, deviceID)
+		}
+	}
+	manager.Groups[groupID] = group
+	return nil
+}
+
+func (manager *DeviceManager) memberStatus(snapshot *Snapshot, deviceID string) GroupMemberResult {
+	result := GroupMemberResult{DeviceID: deviceID, Name: manager.DevicesInfo[deviceID].GetDeviceName()}
+	if alarm, ok := snapshot.Alarm(deviceID); ok {
+		alarmInfo := alarm.ShowInfo()
+		result.Success = true
+		result.Mode = AlarmModeAlarmValues[alarmInfo.Mode]
+		result.Firing = alarmInfo.Firing
+		result.Online = alarmInfo.Online
+	} else {
+		result.Message = "Device has not retrieved its info yet."
+	}
+	return result
+}
+
+// ChangeGroupMode changes every member of groupID to newMode and waits for
+// each device to confirm it. When rollback is set and any member fails the
+// members which were already changed are set back to their previous mode.
+func (manager *DeviceManager) ChangeGroupMode(ctx context.Context, client *http.Client, groupID string, newMode string, rollback bool) ([]GroupMemberResult, error) {
+	group, ok := manager.Groups[groupID]
+	if !ok {
+		errorString := fmt.Sprintf("Group '%s' does not exist.", groupID)
+		return nil, errors.New(errorString)
+	}
+	requestedMode, ok := AlarmModeMap[newMode]
+	if !ok {
+		errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", newMode)
+		return nil, errors.New(errorString)
+	}
+
+	var results []GroupMemberResult
+	var changed []int
+	var failed bool
+	for _, deviceID := range group.DeviceIDs {
+		snapshot := manager.Snapshot()
+		result := manager.memberStatus(snapshot, deviceID)
+		result.PreviousMode = result.Mode
+		var previousMode AlarmMode
+		if alarm, ok := snapshot.Alarm(deviceID); ok {
+			previousMode = alarm.ShowInfo().Mode
+		}
+		if result.Success && previousMode == requestedMode {
+			result.Message = "Device status has not changed."
+		} else if changeModeErr := manager.ChangeMode(ctx, client, deviceID, newMode); changeModeErr != nil {
+			result.Success = false
+			result.Message = changeModeErr.Error()
+		} else if confirmation, confirmError := manager.ConfirmMode(ctx, client, deviceID, newMode, previousMode); confirmError != nil {
+			// Command was sent, device may have applied it
+			changed = append(changed, len(results))
+			result.Success = false
+			result.Message = confirmError.Error()
+		} else {
+			changed = append(changed, len(results))
+			result = manager.memberStatus(manager.Snapshot(), deviceID)
+			result.PreviousMode = AlarmModeAlarmValues[previousMode]
+			result.Confirmation = ModeConfirmationValues[confirmation]
+			if confirmation == Contradicted {
+				result.Success = false
+				result.Message = fmt.Sprintf("Device reports mode '%s' instead of requested one.", result.Mode)
+			}
+		}
+		failed = failed || !result.Success
+		results = append(results, result)
+	}
+
+	if failed && rollback {
+		for _, index := range changed {
+			manager.rollbackMember(events.ActorFrom(ctx), client, &results[index])
+		}
+	}
+	return results, nil
+}
+
+// rollbackMember sets result device back to its previous mode on behalf of
+// actor. It does not use the group change context, which may be done
+// already when a member failed because it timed out.
+func (manager *DeviceManager) rollbackMember(actor string, client *http.Client, result *GroupMemberResult) {
+	ctx, cancel := context.WithTimeout(events.WithActor(context.Background(), actor), manager.confirmationTimeout())
+	defer cancel()
+	for modeName, mode := range AlarmModeMap {
+		if AlarmModeAlarmValues[mode] != result.PreviousMode {
+			continue
+		}
+		logger.Info("Rolling back device mode.", "device", result.Name, "mode", modeName)
+		if changeModeErr := manager.ChangeMode(ctx, client, result.DeviceID, modeName); changeModeErr != nil {
+			result.Message = fmt.Sprintf("Rollback failed: %s", changeModeErr.Error())
+			return
+		}
+		result.RolledBack = true
+		return
+	}
+	result.Message = fmt.Sprintf("Rollback failed: previous mode '%s' is unknown.", result.PreviousMode)
+}
+
+// GroupChangeTimeout is how long a group mode change may take: members are
+// changed one after another and each one may wait up to the confirmation
+// timeout.
+func (manager *DeviceManager) GroupChangeTimeout() time.Duration {
+	var members int
+	for _, group := range manager.Groups {
+		if len(group.DeviceIDs) > members {
+			members = len(group.DeviceIDs)
+		}
+	}
+	return time.Duration(members) * manager.confirmationTimeout()
+}
+
+// GroupStatus returns members status and the aggregated group status: mode
+// is "mixed" when members disagree, firing when any member fires and online
+// only when every member is online.
+func (manager *DeviceManager) GroupStatus(groupID string) (GroupStatusResponse, bool) {
+	return manager.groupStatus(manager.Snapshot(), groupID)
+}
+
+func (manager *DeviceManager) groupStatus(snapshot *Snapshot, groupID string) (GroupStatusResponse, bool) {
+	group, ok := manager.Groups[groupID]
+	if !ok {
+		return GroupStatusResponse{}, false
+	}
+	response := GroupStatusResponse{Success: true, ID: groupID, Name: group.Name, Online: true}
+	for index, deviceID := range group.DeviceIDs {
+		member := manager.memberStatus(snapshot, deviceID)
+		if index == 0 {
+			response.Mode = member.Mode
+		} else if response.Mode != member.Mode {
+			response.Mode = "mixed"
+		}
+		response.Firing = response.Firing || member.Firing
+		response.Online = response.Online && member.Online
+		response.Members = append(response.Members, member)
+	}
+	return response, true
+}
+
+func (manager *DeviceManager) GroupRoutes() chi.Router {
+	router := chi.NewRouter()
+	router.Get("/", manager.ListGroups)
+	router.Route("/{id}", func(r chi.Router) {
+		r.Use(DeviceCtx)
+		r.Get("/", manager.ShowGroupInfo)
+		r.Put("/", manager.UpdateGroupStatus)
+	})
+	return router
+}
+
+type GroupListResponse struct {
+	Success bool                `json:"success"`
+	Data    map[string][]string `json:"data"`
+}
+
+func (manager *DeviceManager) ListGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	groupMap := make(map[string][]string)
+	for groupID, group := range manager.Groups {
+		groupMap[groupID] = append([]string{}, group.DeviceIDs...)
+		sort.Strings(groupMap[groupID])
+	}
+	jsonResponse := GroupListResponse{Success: true, Data: groupMap}
+	jsonString, _ := json.Marshal(jsonResponse)
+	w.Write([]byte(jsonString))
+}
+
+type GroupStatusResponse struct {
+	Success bool                `json:"success"`
+	Message string              `json:"msg"`
+	ID      string              `json:"id,omitempty"`
+	Name    string              `json:"name,omitempty"`
+	Mode    string              `json:"mode"`
+	Firing  bool                `json:"firing"`
+	Online  bool                `json:"online"`
+	Members []GroupMemberResult `json:"members"`
+}
+
+func (manager *DeviceManager) ShowGroupInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	groupID := r.Context().Value("id").(string)
+	group, ok := manager.Groups[groupID]
+	wait, waitErr := ParseWait(r)
+	var response GroupStatusResponse
+	if waitErr != nil {
+		response.Message = waitErr.Error()
+		w.WriteHeader(400)
+	} else if !ok {
+		response.Message = fmt.Sprintf("Group '%s' does not exist.", groupID)
+		w.WriteHeader(404)
+	} else if snapshot, notModified := manager.conditionalSnapshot(w, r, group.DeviceIDs, wait); notModified {
+		w.WriteHeader(304)
+		return
+	} else {
+		response, _ = manager.groupStatus(snapshot, groupID)
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+type GroupChangeStatus struct {
+	Mode     string `json:"mode"`
+	Rollback bool   `json:"rollback"`
+}
+
+func (manager *DeviceManager) UpdateGroupStatus(w http.ResponseWriter, r *http.Request) {
+	var response GroupStatusResponse
+	w.Header().Set("Content-Type", "application/json")
+	groupID := r.Context().Value("id").(string)
+	decoder := json.NewDecoder(r.Body)
+	var groupChangeMode GroupChangeStatus
+	if err := decoder.Decode(&groupChangeMode); err != nil {
+		response.Message = "Failed to decode Response"
+		w.WriteHeader(400)
+	} else if _, ok := manager.Groups[groupID]; !ok {
+		response.Message = fmt.Sprintf("Group '%s' does not exist.", groupID)
+		w.WriteHeader(404)
+	} else {
+		results, changeModeErr := manager.ChangeGroupMode(events.WithActor(r.Context(), webtls.Identity(r)), manager.httpClient(), groupID, groupChangeMode.Mode, groupChangeMode.Rollback)
+		if changeModeErr != nil {
+			response.Message = changeModeErr.Error()
+			w.WriteHeader(400)
+		} else {
+			response, _ = manager.GroupStatus(groupID)
+			response.Members = results
+			for _, result := range results {
+				if !result.Success {
+					response.Success = false
+					response.Message = "Some group members failed to change mode."
+				}
+			}
+			if !response.Success {
+				w.WriteHeader(409)
+			}
+		}
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
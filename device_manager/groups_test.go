@@ -0,0 +1,168 @@
+package devices
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func groupsManager(t *testing.T, transport *AlarmRoundTripperMock) *DeviceManager {
+	client := &http.Client{Transport: transport}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), ConfirmationTimeout: 50 * time.Millisecond, ConfirmationInterval: 10 * time.Millisecond}
+
+	homeAlarm := tuyadevice.TuyaDevice{Name: "Home Alarm", DeviceType: "99AST", DeviceID: "home123", Host: "https://openapi.tuyaeu.com"}
+	officeAlarm := tuyadevice.TuyaDevice{Name: "Office Alarm", DeviceType: "99AST", DeviceID: "office123", Host: "https://openapi.tuyaeu.com"}
+	deviceManager.AddDevice(&homeAlarm)
+	deviceManager.AddDevice(&officeAlarm)
+	if addGroupErr := deviceManager.AddGroup("premises", Group{Name: "Premises", DeviceIDs: []string{"home123", "office123"}}); addGroupErr != nil {
+		t.Fatalf("AddGroup should not fail, error was '%s'.", addGroupErr)
+	}
+	deviceManager.Start(context.Background(), client)
+	if retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), client); retrieveInfoError != nil {
+		t.Fatalf("Device Manager info retrieval should not fail. Error was %s", retrieveInfoError)
+	}
+	return &deviceManager
+}
+
+func TestAddGroupUnknownDevice(t *testing.T) {
+
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	addGroupErr := deviceManager.AddGroup("premises", Group{Name: "Premises", DeviceIDs: []string{"home123"}})
+
+	if addGroupErr == nil {
+		t.Errorf("AddGroup should fail with unmanaged devices.")
+	} else if addGroupErr.Error() != "Group 'premises' device id 'home123' is not a managed device." {
+		t.Errorf("AddGroup error should be \"Group 'premises' device id 'home123' is not a managed device.\" but error was '%s'.", addGroupErr)
+	}
+}
+
+func TestChangeGroupMode(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := groupsManager(t, transport)
+
+	results, changeModeErr := deviceManager.ChangeGroupMode(context.Background(), &http.Client{Transport: transport}, "premises", "Armed", false)
+	if changeModeErr != nil {
+		t.Fatalf("ChangeGroupMode shouldn't fail, error was '%s'.", changeModeErr)
+	}
+	for _, result := range results {
+		if !result.Success || result.Confirmation != "confirmed" || result.Mode != "arm" || result.PreviousMode != "disarmed" {
+			t.Errorf("Member %s should have been armed, result was %+v.", result.Name, result)
+		}
+	}
+	groupStatus, _ := deviceManager.GroupStatus("premises")
+	if groupStatus.Mode != "arm" {
+		t.Errorf("Group mode should be 'arm', not '%s'.", groupStatus.Mode)
+	}
+}
+
+func TestChangeGroupModeRollback(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed", Failing: map[string]bool{"office123": true}}
+	deviceManager := groupsManager(t, transport)
+
+	results, changeModeErr := deviceManager.ChangeGroupMode(context.Background(), &http.Client{Transport: transport}, "premises", "Armed", true)
+	if changeModeErr != nil {
+		t.Fatalf("ChangeGroupMode shouldn't fail, error was '%s'.", changeModeErr)
+	}
+	if !results[0].Success || !results[0].RolledBack {
+		t.Errorf("Home Alarm should have been armed and rolled back, result was %+v.", results[0])
+	}
+	if results[1].Success {
+		t.Errorf("Office Alarm should have failed, result was %+v.", results[1])
+	}
+	if transport.deviceMode("home123") != "disarmed" {
+		t.Errorf("Home Alarm should be disarmed after rollback, not '%s'.", transport.deviceMode("home123"))
+	}
+}
+
+// CancellingRoundTripperMock cancels the request context once a request for
+// DeviceID is sent.
+type CancellingRoundTripperMock struct {
+	*AlarmRoundTripperMock
+	DeviceID string
+	Cancel   context.CancelFunc
+}
+
+func (crtm CancellingRoundTripperMock) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/devices/"+crtm.DeviceID) {
+		crtm.Cancel()
+	}
+	return crtm.AlarmRoundTripperMock.RoundTrip(req)
+}
+
+func TestChangeGroupModeRollbackAfterDeadline(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := groupsManager(t, transport)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &http.Client{Transport: CancellingRoundTripperMock{AlarmRoundTripperMock: transport, DeviceID: "office123", Cancel: cancel}}
+
+	results, changeModeErr := deviceManager.ChangeGroupMode(ctx, client, "premises", "Armed", true)
+	if changeModeErr != nil {
+		t.Fatalf("ChangeGroupMode shouldn't fail, error was '%s'.", changeModeErr)
+	}
+	if results[1].Success {
+		t.Errorf("Office Alarm should have failed once the context is done, result was %+v.", results[1])
+	}
+	if !results[0].RolledBack {
+		t.Errorf("Home Alarm should be rolled back after the context is done, result was %+v.", results[0])
+	}
+	if transport.deviceMode("home123") != "disarmed" {
+		t.Errorf("Home Alarm should be disarmed after rollback, not '%s'.", transport.deviceMode("home123"))
+	}
+}
+
+func TestGroupChangeTimeout(t *testing.T) {
+
+	deviceManager := groupsManager(t, &AlarmRoundTripperMock{Mode: "disarmed"})
+	if timeout := deviceManager.GroupChangeTimeout(); timeout != 100*time.Millisecond {
+		t.Errorf("Group change timeout should be 100ms for two members, not %s.", timeout)
+	}
+}
+
+func TestChangeGroupModeWithoutRollback(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed", Failing: map[string]bool{"office123": true}}
+	deviceManager := groupsManager(t, transport)
+
+	results, _ := deviceManager.ChangeGroupMode(context.Background(), &http.Client{Transport: transport}, "premises", "Armed", false)
+	if results[0].RolledBack {
+		t.Errorf("Home Alarm shouldn't be rolled back.")
+	}
+	if transport.deviceMode("home123") != "arm" {
+		t.Errorf("Home Alarm should stay armed, not '%s'.", transport.deviceMode("home123"))
+	}
+	groupStatus, _ := deviceManager.GroupStatus("premises")
+	if groupStatus.Mode != "mixed" {
+		t.Errorf("Group mode should be 'mixed', not '%s'.", groupStatus.Mode)
+	}
+}
+
+func TestShowGroupInfo(t *testing.T) {
+
+	deviceManager := groupsManager(t, &AlarmRoundTripperMock{Mode: "home"})
+
+	recorder := httptest.NewRecorder()
+	deviceManager.GroupRoutes().ServeHTTP(recorder, httptest.NewRequest("GET", "/premises", nil))
+	var response GroupStatusResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+
+	if recorder.Code != 200 || response.Mode != "home" || len(response.Members) != 2 || !response.Online {
+		t.Errorf("GET group should return both members in home mode, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	deviceManager.GroupRoutes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/nonexistent", bytes.NewBufferString(`{"mode": "Armed"}`)))
+	if recorder.Code != 404 {
+		t.Errorf("PUT nonexistent group should return 404, not %d.", recorder.Code)
+	}
+}
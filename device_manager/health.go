@@ -0,0 +1,39 @@
+package devices
+
+import "fmt"
+
+// Health counts devices by their last retrieved state.
+type Health struct {
+	Devices    int
+	Online     int
+	Firing     int
+	LowBattery int
+}
+
+func (health Health) String() string {
+	return fmt.Sprintf("%d devices, %d online, %d firing, %d low battery", health.Devices, health.Online, health.Firing, health.LowBattery)
+}
+
+// Health returns device counts, devices whose info has not been retrieved
+// yet are counted as offline.
+func (manager *DeviceManager) Health() Health {
+	snapshot := manager.Snapshot()
+	health := Health{Devices: len(manager.DevicesInfo)}
+	for deviceID := range manager.DevicesInfo {
+		alarm, ok := snapshot.Alarm(deviceID)
+		if !ok {
+			continue
+		}
+		info := alarm.ShowInfo()
+		if info.Online {
+			health.Online++
+		}
+		if info.Firing {
+			health.Firing++
+		}
+		if info.LowBattery {
+			health.LowBattery++
+		}
+	}
+	return health
+}
@@ -0,0 +1,25 @@
+package devices
+
+import (
+	"testing"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func TestHealth(t *testing.T) {
+
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Home", DeviceType: "99AST", DeviceID: "home123"})
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Garage", DeviceType: "99AST", DeviceID: "garage123"})
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Office", DeviceType: "99AST", DeviceID: "office123"})
+	deviceManager.publish("home123", DriverAlarm{Info: AlarmInfo{Online: true, Firing: true}})
+	deviceManager.publish("garage123", DriverAlarm{Info: AlarmInfo{Online: false, LowBattery: true}})
+
+	health := deviceManager.Health()
+	if health != (Health{Devices: 3, Online: 1, Firing: 1, LowBattery: 1}) {
+		t.Errorf("Devices without info should be counted as offline, health was %+v.", health)
+	}
+	if health.String() != "3 devices, 1 online, 1 firing, 1 low battery" {
+		t.Errorf("Health text was '%s'.", health.String())
+	}
+}
@@ -0,0 +1,263 @@
+package devices
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/events"
+	chi "github.com/go-chi/chi/v5"
+)
+
+type JobState int
+
+const (
+	JobQueued    JobState = iota + 1 // waiting for worker
+	JobSent                          // command sent, device has not confirmed it
+	JobConfirmed                     // device reports requested mode
+	JobFailed                        // command or confirmation failed
+)
+
+var JobStateValues = map[JobState]string{
+	JobQueued:    "queued",
+	JobSent:      "sent",
+	JobConfirmed: "confirmed",
+	JobFailed:    "failed",
+}
+
+// Jobs are kept this long after their last update so clients can read them
+// and retry with the same idempotency key.
+const JobRetention = 24 * time.Hour
+
+const jobQueueSize = 64
+
+// ErrJobsClosed is returned when jobs are enqueued after Shutdown.
+var ErrJobsClosed = errors.New("Job queue is closed, service is shutting down.")
+
+type Job struct {
+	ID             string    `json:"id"`
+	DeviceID       string    `json:"device_id"`
+	Mode           string    `json:"mode"`
+	State          string    `json:"state"`
+	Confirmation   string    `json:"confirmation,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+	idempotencyKey string
+	previousMode   AlarmMode
+	// actor is the identity of the client which requested the job
+	actor string
+}
+
+type JobManager struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closed  bool
+	done    chan struct{}
+	manager *DeviceManager
+	client  *http.Client
+	jobs    map[string]*Job
+	keys    map[string]string
+	queue   chan *Job
+	mutex   sync.Mutex
+}
+
+// NewJobManager starts a worker which runs queued mode changes one by one
+// until ctx is done or Shutdown is called.
+func NewJobManager(ctx context.Context, manager *DeviceManager, client *http.Client) *JobManager {
+	ctx, cancel := context.WithCancel(ctx)
+	jobManager := &JobManager{ctx: ctx, cancel: cancel, done: make(chan struct{}), manager: manager, client: client, jobs: make(map[string]*Job), keys: make(map[string]string), queue: make(chan *Job, jobQueueSize)}
+	go jobManager.work()
+	return jobManager
+}
+
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Enqueue creates a mode change job on behalf of actor. When idempotencyKey
+// has already been used for the same device and mode the existing job is
+// returned instead.
+func (jobManager *JobManager) Enqueue(deviceID string, mode string, previousMode AlarmMode, idempotencyKey string, actor string) (Job, error) {
+	jobManager.mutex.Lock()
+	defer jobManager.mutex.Unlock()
+
+	jobManager.prune()
+	if existingJob, ok := jobManager.lookup(idempotencyKey); ok {
+		if existingJob.DeviceID != deviceID || existingJob.Mode != mode {
+			errorString := fmt.Sprintf("Idempotency key '%s' has already been used for another request.", idempotencyKey)
+			return *existingJob, errors.New(errorString)
+		}
+		return *existingJob, nil
+	}
+	now := time.Now()
+	job := &Job{ID: newJobID(), DeviceID: deviceID, Mode: mode, State: JobStateValues[JobQueued], Created: now, Updated: now, idempotencyKey: idempotencyKey, previousMode: previousMode, actor: actor}
+	if jobManager.closed {
+		return *job, ErrJobsClosed
+	}
+	select {
+	case jobManager.queue <- job:
+	default:
+		return *job, errors.New("Job queue is full.")
+	}
+	jobManager.jobs[job.ID] = job
+	if idempotencyKey != "" {
+		jobManager.keys[idempotencyKey] = job.ID
+	}
+	return *job, nil
+}
+
+// Lookup returns the job created with idempotencyKey, if any.
+func (jobManager *JobManager) Lookup(idempotencyKey string) (Job, bool) {
+	jobManager.mutex.Lock()
+	defer jobManager.mutex.Unlock()
+	job, ok := jobManager.lookup(idempotencyKey)
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (jobManager *JobManager) lookup(idempotencyKey string) (*Job, bool) {
+	if idempotencyKey == "" {
+		return nil, false
+	}
+	jobID, ok := jobManager.keys[idempotencyKey]
+	if !ok {
+		return nil, false
+	}
+	job, ok := jobManager.jobs[jobID]
+	return job, ok
+}
+
+func (jobManager *JobManager) GetJob(jobID string) (Job, bool) {
+	jobManager.mutex.Lock()
+	defer jobManager.mutex.Unlock()
+	job, ok := jobManager.jobs[jobID]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// prune removes jobs not updated during JobRetention, mutex must be held.
+func (jobManager *JobManager) prune() {
+	for jobID, job := range jobManager.jobs {
+		if time.Since(job.Updated) > JobRetention {
+			delete(jobManager.jobs, jobID)
+			if job.idempotencyKey != "" {
+				delete(jobManager.keys, job.idempotencyKey)
+			}
+		}
+	}
+}
+
+func (jobManager *JobManager) update(job *Job, state JobState, confirmation string, jobError error) {
+	jobManager.mutex.Lock()
+	defer jobManager.mutex.Unlock()
+	job.State = JobStateValues[state]
+	job.Confirmation = confirmation
+	if jobError != nil {
+		job.Error = jobError.Error()
+	}
+	job.Updated = time.Now()
+}
+
+func (jobManager *JobManager) work() {
+	defer close(jobManager.done)
+	for {
+		select {
+		case <-jobManager.ctx.Done():
+			return
+		case job, ok := <-jobManager.queue:
+			if !ok {
+				return
+			}
+			jobManager.run(job)
+		}
+	}
+}
+
+// Shutdown stops accepting jobs and waits until queued ones finish. When
+// ctx is done first running jobs are cancelled and queued ones are dropped.
+func (jobManager *JobManager) Shutdown(ctx context.Context) error {
+	jobManager.mutex.Lock()
+	if !jobManager.closed {
+		jobManager.closed = true
+		close(jobManager.queue)
+	}
+	jobManager.mutex.Unlock()
+	defer jobManager.cancel()
+	select {
+	case <-jobManager.done:
+		return nil
+	case <-ctx.Done():
+		jobManager.cancel()
+		<-jobManager.done
+		for job := range jobManager.queue {
+			jobManager.update(job, JobFailed, "", ErrJobsClosed)
+		}
+		return ctx.Err()
+	}
+}
+
+func (jobManager *JobManager) run(job *Job) {
+	logger.Info("Running job.", "job", job.ID, "device", job.DeviceID, "mode", job.Mode)
+	ctx := events.WithActor(jobManager.ctx, job.actor)
+	if changeModeErr := jobManager.manager.ChangeMode(ctx, jobManager.client, job.DeviceID, job.Mode); changeModeErr != nil {
+		jobManager.update(job, JobFailed, "", changeModeErr)
+		return
+	}
+	jobManager.update(job, JobSent, "", nil)
+	confirmation, confirmError := jobManager.manager.ConfirmMode(ctx, jobManager.client, job.DeviceID, job.Mode, job.previousMode)
+	switch {
+	case confirmError != nil:
+		jobManager.update(job, JobFailed, "", confirmError)
+	case confirmation == Confirmed:
+		jobManager.update(job, JobConfirmed, ModeConfirmationValues[confirmation], nil)
+	case confirmation == Contradicted:
+		jobManager.update(job, JobFailed, ModeConfirmationValues[confirmation], errors.New("Device reports a mode different from the requested one."))
+	default:
+		jobManager.update(job, JobSent, ModeConfirmationValues[confirmation], nil)
+	}
+	logger.Info("Job finished.", "job", job.ID)
+}
+
+func (jobManager *JobManager) Routes() chi.Router {
+	router := chi.NewRouter()
+	router.Route("/{id}", func(r chi.Router) {
+		r.Use(DeviceCtx)
+		r.Get("/", jobManager.ShowJob)
+	})
+	return router
+}
+
+type JobResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"msg"`
+	Job     *Job   `json:"job,omitempty"`
+}
+
+func (jobManager *JobManager) ShowJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobID := r.Context().Value("id").(string)
+	var response JobResponse
+	if job, ok := jobManager.GetJob(jobID); !ok {
+		response.Success = false
+		response.Message = fmt.Sprintf("Job id '%s' does not exist.", jobID)
+		w.WriteHeader(404)
+	} else {
+		response.Success = true
+		response.Job = &job
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
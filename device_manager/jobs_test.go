@@ -0,0 +1,232 @@
+package devices
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+// AlarmRoundTripperMock behaves like Tuya cloud, commands change the mode
+// reported by device info requests. Modes are indexed by device ID, Mode is
+// used for devices without an entry. Commands sent to Failing devices are
+// rejected.
+type AlarmRoundTripperMock struct {
+	Mode     string
+	Modes    map[string]string
+	Failing  map[string]bool
+	Commands int
+	mutex    sync.Mutex
+}
+
+var commandModeRegexp = regexp.MustCompile(`"value":"([a-z]+)"`)
+var devicePathRegexp = regexp.MustCompile(`/devices/([^/]+)`)
+
+func (artm *AlarmRoundTripperMock) RoundTrip(req *http.Request) (*http.Response, error) {
+	artm.mutex.Lock()
+	defer artm.mutex.Unlock()
+	if artm.Modes == nil {
+		artm.Modes = make(map[string]string)
+	}
+	var deviceID string
+	if matches := devicePathRegexp.FindStringSubmatch(req.URL.Path); matches != nil {
+		deviceID = matches[1]
+	}
+	mode, ok := artm.Modes[deviceID]
+	if !ok {
+		mode = artm.Mode
+	}
+	var body string
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/token"):
+		body = `{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`
+	case strings.HasSuffix(req.URL.Path, "/commands") && artm.Failing[deviceID]:
+		body = `{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`
+	case strings.HasSuffix(req.URL.Path, "/commands"):
+		artm.Commands++
+		requestBody, _ := ioutil.ReadAll(req.Body)
+		artm.Modes[deviceID] = commandModeRegexp.FindStringSubmatch(string(requestBody))[1]
+		body = `{"result":true,"success":true,"t":1653184890385,"tid":"18dd6963d97311eca734f2b4cd1fee5a"}`
+	default:
+		body = alarmStatusJSON(mode, "normal")
+	}
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+func (artm *AlarmRoundTripperMock) deviceMode(deviceID string) string {
+	artm.mutex.Lock()
+	defer artm.mutex.Unlock()
+	if mode, ok := artm.Modes[deviceID]; ok {
+		return mode
+	}
+	return artm.Mode
+}
+
+func (artm *AlarmRoundTripperMock) commands() int {
+	artm.mutex.Lock()
+	defer artm.mutex.Unlock()
+	return artm.Commands
+}
+
+func jobsManager(t *testing.T, transport *AlarmRoundTripperMock) *DeviceManager {
+	client := &http.Client{Transport: transport}
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), ConfirmationTimeout: 50 * time.Millisecond, ConfirmationInterval: 10 * time.Millisecond}
+
+	device := tuyadevice.TuyaDevice{Name: "Test Device", DeviceType: "99AST", DeviceID: "testid123", Host: "https://openapi.tuyaeu.com"}
+	deviceManager.AddDevice(&device)
+	deviceManager.Start(context.Background(), client)
+	if retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), client); retrieveInfoError != nil {
+		t.Fatalf("Device Manager info retrieval should not fail. Error was %s", retrieveInfoError)
+	}
+	deviceManager.Jobs = NewJobManager(context.Background(), &deviceManager, client)
+	return &deviceManager
+}
+
+func asyncModeChange(deviceManager *DeviceManager, mode string, idempotencyKey string) (*httptest.ResponseRecorder, JobResponse) {
+	request := httptest.NewRequest("PUT", "/status/testid123?async=true", bytes.NewBufferString(`{"mode": "`+mode+`"}`))
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	recorder := httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, request)
+	var response JobResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, response
+}
+
+func waitJob(t *testing.T, deviceManager *DeviceManager, jobID string) Job {
+	for i := 0; i < 100; i++ {
+		job, ok := deviceManager.Jobs.GetJob(jobID)
+		if !ok {
+			t.Fatalf("Job %s should exist.", jobID)
+		}
+		if job.State == JobStateValues[JobConfirmed] || job.State == JobStateValues[JobFailed] || job.Confirmation != "" {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s has not finished.", jobID)
+	return Job{}
+}
+
+func TestAsyncModeChange(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := jobsManager(t, transport)
+
+	recorder, response := asyncModeChange(deviceManager, "Armed", "")
+	if recorder.Code != 202 {
+		t.Fatalf("Async mode change should return 202, not %d.", recorder.Code)
+	}
+	if response.Job == nil || response.Job.State != "queued" {
+		t.Fatalf("Async mode change should return a queued job.")
+	}
+	if recorder.Header().Get("Location") != "/jobs/"+response.Job.ID {
+		t.Errorf("Async mode change Location header should point to job, not '%s'.", recorder.Header().Get("Location"))
+	}
+
+	job := waitJob(t, deviceManager, response.Job.ID)
+	if job.State != "confirmed" {
+		t.Errorf("Job should be confirmed, not '%s'.", job.State)
+	}
+
+	jobRecorder := httptest.NewRecorder()
+	deviceManager.Jobs.Routes().ServeHTTP(jobRecorder, httptest.NewRequest("GET", "/"+job.ID, nil))
+	if jobRecorder.Code != 200 || !strings.Contains(jobRecorder.Body.String(), `"state":"confirmed"`) {
+		t.Errorf("GET job should return confirmed job, returned %d '%s'.", jobRecorder.Code, jobRecorder.Body.String())
+	}
+}
+
+func TestAsyncModeChangeIdempotencyKey(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := jobsManager(t, transport)
+
+	_, firstResponse := asyncModeChange(deviceManager, "Armed", "retry-key")
+	waitJob(t, deviceManager, firstResponse.Job.ID)
+	recorder, retryResponse := asyncModeChange(deviceManager, "Armed", "retry-key")
+
+	if recorder.Code != 202 {
+		t.Errorf("Retried async mode change should return 202, not %d.", recorder.Code)
+	}
+	if retryResponse.Job == nil || retryResponse.Job.ID != firstResponse.Job.ID {
+		t.Errorf("Retried async mode change should return the original job.")
+	}
+	if transport.commands() != 1 {
+		t.Errorf("Retried async mode change should send one command, %d were sent.", transport.commands())
+	}
+
+	recorder, _ = asyncModeChange(deviceManager, "HomeArmed", "retry-key")
+	if recorder.Code != 409 {
+		t.Errorf("Reusing idempotency key for another mode should return 409, not %d.", recorder.Code)
+	}
+}
+
+func TestAsyncModeChangeFailed(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := jobsManager(t, transport)
+	deviceManager.Jobs.client = &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`))}}}
+
+	_, response := asyncModeChange(deviceManager, "Armed", "")
+	job := waitJob(t, deviceManager, response.Job.ID)
+
+	if job.State != "failed" {
+		t.Errorf("Job should have failed, state is '%s'.", job.State)
+	}
+	if job.Error != "Device 'Test Device' failed to change state to arm, error was 'param is illegal ,please check it'." {
+		t.Errorf("Job error should describe Tuya failure, error was '%s'.", job.Error)
+	}
+}
+
+func TestShowJobNotFound(t *testing.T) {
+
+	deviceManager := jobsManager(t, &AlarmRoundTripperMock{Mode: "disarmed"})
+
+	recorder := httptest.NewRecorder()
+	deviceManager.Jobs.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/nonexistent", nil))
+	if recorder.Code != 404 {
+		t.Errorf("GET nonexistent job should return 404, not %d.", recorder.Code)
+	}
+}
+
+func TestJobsShutdown(t *testing.T) {
+
+	deviceManager := jobsManager(t, &AlarmRoundTripperMock{Mode: "disarmed"})
+
+	_, response := asyncModeChange(deviceManager, "Armed", "")
+	if shutdownErr := deviceManager.Jobs.Shutdown(context.Background()); shutdownErr != nil {
+		t.Errorf("Shutdown should wait for queued jobs, error was '%s'.", shutdownErr)
+	}
+	if job, _ := deviceManager.Jobs.GetJob(response.Job.ID); job.State != "confirmed" {
+		t.Errorf("Queued job should finish before shutdown, state is '%s'.", job.State)
+	}
+	if recorder, _ := asyncModeChange(deviceManager, "HomeArmed", ""); recorder.Code != 503 {
+		t.Errorf("Async mode change after shutdown should return 503, not %d.", recorder.Code)
+	}
+}
+
+func TestJobsShutdownDeadline(t *testing.T) {
+
+	deviceManager := jobsManager(t, &AlarmRoundTripperMock{Mode: "disarmed"})
+	deviceManager.Jobs.client = &http.Client{Transport: BlockingRoundTripperMock{}}
+
+	_, response := asyncModeChange(deviceManager, "Armed", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if shutdownErr := deviceManager.Jobs.Shutdown(ctx); shutdownErr != context.DeadlineExceeded {
+		t.Errorf("Shutdown should fail when deadline passes, error was '%v'.", shutdownErr)
+	}
+	if job, _ := deviceManager.Jobs.GetJob(response.Job.ID); job.State != "failed" {
+		t.Errorf("Running job should be cancelled, state is '%s'.", job.State)
+	}
+}
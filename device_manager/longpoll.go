@@ -0,0 +1,54 @@
+package devices
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxStatusWait is the longest status long-poll clients can request.
+const MaxStatusWait = time.Minute
+
+// ParseWait returns the long-poll duration set in the wait query parameter,
+// it is 0 when the parameter is not set.
+func ParseWait(r *http.Request) (time.Duration, error) {
+	waitString := r.URL.Query().Get("wait")
+	if waitString == "" {
+		return 0, nil
+	}
+	wait, parseErr := time.ParseDuration(waitString)
+	if parseErr != nil || wait < 0 || wait > MaxStatusWait {
+		return 0, fmt.Errorf("wait must be a duration between 0s and %s.", MaxStatusWait)
+	}
+	return wait, nil
+}
+
+// etagMatches reports whether the If-None-Match header value matches etag.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionalSnapshot returns the snapshot a status request is answered
+// from. When wait is set and the client state is current, or the client has
+// not sent If-None-Match, it waits until the state of deviceIDs changes.
+// ETag header is set and notModified reports whether the client state is
+// still current.
+func (manager *DeviceManager) conditionalSnapshot(w http.ResponseWriter, r *http.Request, deviceIDs []string, wait time.Duration) (snapshot *Snapshot, notModified bool) {
+	snapshot = manager.Snapshot()
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	etag := manager.ETag(snapshot, deviceIDs...)
+	if wait > 0 && (ifNoneMatch == "" || etagMatches(ifNoneMatch, etag)) {
+		snapshot = manager.WaitForChange(r.Context(), etag, deviceIDs, wait)
+		etag = manager.ETag(snapshot, deviceIDs...)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	return snapshot, ifNoneMatch != "" && etagMatches(ifNoneMatch, etag)
+}
@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func longPollManager() *DeviceManager {
+	deviceManager := &DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Home", DeviceType: "99AST", DeviceID: "home123"})
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Garage", DeviceType: "99AST", DeviceID: "garage123"})
+	deviceManager.AddGroup("premises", Group{Name: "Premises", DeviceIDs: []string{"home123", "garage123"}})
+	deviceManager.publish("home123", DriverAlarm{Info: AlarmInfo{Mode: Disarmed, Online: true}, Driver: NewDriver99AST()})
+	deviceManager.publish("garage123", DriverAlarm{Info: AlarmInfo{Mode: Disarmed, Online: true}, Driver: NewDriver99AST()})
+	return deviceManager
+}
+
+func serveStatus(handler http.Handler, target string, etag string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", target, nil)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestStatusETag(t *testing.T) {
+	deviceManager := longPollManager()
+	routes := deviceManager.Routes()
+
+	recorder := serveStatus(routes, "/status/home123", "")
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != 200 || etag == "" {
+		t.Fatalf("Status should be served with an ETag, response was %d with ETag '%s'.", recorder.Code, etag)
+	}
+	if recorder = serveStatus(routes, "/status/home123", etag); recorder.Code != 304 || recorder.Body.Len() != 0 {
+		t.Errorf("Unchanged status should return 304 without body, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+	deviceManager.publish("garage123", DriverAlarm{Info: AlarmInfo{Mode: FullyArmed, Online: true}, Driver: NewDriver99AST()})
+	if recorder = serveStatus(routes, "/status/home123", etag); recorder.Code != 304 {
+		t.Errorf("Other devices changes should not change the ETag, response was %d.", recorder.Code)
+	}
+	deviceManager.publish("home123", DriverAlarm{Info: AlarmInfo{Mode: FullyArmed, Online: true}, Driver: NewDriver99AST()})
+	recorder = serveStatus(routes, "/status/home123", etag)
+	if recorder.Code != 200 || recorder.Header().Get("ETag") == etag || !strings.Contains(recorder.Body.String(), `"mode":"arm"`) {
+		t.Errorf("Changed status should be served with a new ETag, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestGroupStatusETag(t *testing.T) {
+	deviceManager := longPollManager()
+	routes := deviceManager.GroupRoutes()
+
+	etag := serveStatus(routes, "/premises", "").Header().Get("ETag")
+	if recorder := serveStatus(routes, "/premises", etag); recorder.Code != 304 {
+		t.Errorf("Unchanged group status should return 304, response was %d.", recorder.Code)
+	}
+	deviceManager.publish("garage123", DriverAlarm{Info: AlarmInfo{Mode: FullyArmed, Online: true}, Driver: NewDriver99AST()})
+	if recorder := serveStatus(routes, "/premises", etag); recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"mode":"mixed"`) {
+		t.Errorf("Member changes should change the group ETag, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestStatusLongPoll(t *testing.T) {
+	deviceManager := longPollManager()
+	routes := deviceManager.Routes()
+	etag := serveStatus(routes, "/status/home123", "").Header().Get("ETag")
+
+	start := time.Now()
+	if recorder := serveStatus(routes, "/status/home123?wait=50ms", etag); recorder.Code != 304 || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Long-poll should return 304 once wait passes without changes, response was %d after %s.", recorder.Code, time.Since(start))
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		deviceManager.mutex.Lock()
+		deviceManager.publish("home123", DriverAlarm{Info: AlarmInfo{Mode: HomeArmed, Online: true}, Driver: NewDriver99AST()})
+		deviceManager.mutex.Unlock()
+	}()
+	start = time.Now()
+	recorder := serveStatus(routes, "/status/home123?wait=30s", etag)
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"mode":"home"`) || time.Since(start) > 5*time.Second {
+		t.Errorf("Long-poll should return as soon as status changes, response was %d %s after %s.", recorder.Code, recorder.Body.String(), time.Since(start))
+	}
+
+	if recorder := serveStatus(routes, "/status/home123?wait=30s", etag); recorder.Code != 200 {
+		t.Errorf("Long-poll with an outdated ETag should return at once, response was %d.", recorder.Code)
+	}
+	if recorder := serveStatus(routes, "/status/home123?wait=forever", ""); recorder.Code != 400 {
+		t.Errorf("Invalid wait should return 400, response was %d.", recorder.Code)
+	}
+	if recorder := serveStatus(routes, "/status/home123?wait=2h", ""); recorder.Code != 400 {
+		t.Errorf("Wait longer than %s should return 400, response was %d.", MaxStatusWait, recorder.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package devices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+	chi "github.com/go-chi/chi/v5"
+)
+
+// simulated devices report 99AST status codes
+func init() {
+	RegisterDriver(tuyadevice.SimulatedDeviceType, NewDriver99AST)
+}
+
+func (manager *DeviceManager) simulatedDevice(deviceID string) (*tuyadevice.SimulatedDevice, bool) {
+	device, ok := manager.DevicesInfo[deviceID].(*tuyadevice.SimulatedDevice)
+	return device, ok
+}
+
+// refreshDevice retrieves deviceID info at once so simulated changes are
+// recorded without waiting for the next status update.
+func (manager *DeviceManager) refreshDevice(ctx context.Context, client *http.Client, deviceID string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.retrieveDeviceInfo(ctx, client, deviceID, manager.DevicesInfo[deviceID])
+}
+
+// HasSimulatedDevices reports whether any managed device is simulated.
+func (manager *DeviceManager) HasSimulatedDevices() bool {
+	for deviceID := range manager.DevicesInfo {
+		if _, ok := manager.simulatedDevice(deviceID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (manager *DeviceManager) SimulatorRoutes() chi.Router {
+	router := chi.NewRouter()
+	router.Get("/", manager.ListSimulatedDevices)
+	router.Route("/{id}", func(r chi.Router) {
+		r.Use(DeviceCtx)
+		r.Get("/", manager.ShowSimulatedDevice)
+		r.Put("/", manager.UpdateSimulatedDevice)
+		r.Post("/intrusion", manager.TriggerIntrusion)
+	})
+	return router
+}
+
+type SimulatedDeviceListResponse struct {
+	Success bool                                 `json:"success"`
+	Message string                               `json:"msg"`
+	Data    map[string]tuyadevice.SimulatedState `json:"data"`
+}
+
+func (manager *DeviceManager) ListSimulatedDevices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	states := make(map[string]tuyadevice.SimulatedState)
+	for deviceID := range manager.DevicesInfo {
+		if device, ok := manager.simulatedDevice(deviceID); ok {
+			states[deviceID] = device.State()
+		}
+	}
+	response := SimulatedDeviceListResponse{Success: true, Data: states}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+type SimulatedDeviceResponse struct {
+	Success bool                       `json:"success"`
+	Message string                     `json:"msg"`
+	Data    *tuyadevice.SimulatedState `json:"data,omitempty"`
+}
+
+func (manager *DeviceManager) ShowSimulatedDevice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	deviceID := r.Context().Value("id").(string)
+	var response SimulatedDeviceResponse
+	if device, ok := manager.simulatedDevice(deviceID); !ok {
+		response.Message = fmt.Sprintf("Device id '%s' is not a simulated device.", deviceID)
+		w.WriteHeader(404)
+	} else {
+		state := device.State()
+		response.Success = true
+		response.Data = &state
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+// SimulatedDeviceUpdate changes the fields which are set.
+type SimulatedDeviceUpdate struct {
+	Online      *bool    `json:"online"`
+	Latency     *string  `json:"latency"`
+	FailureRate *float64 `json:"failure_rate"`
+}
+
+func (manager *DeviceManager) UpdateSimulatedDevice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	deviceID := r.Context().Value("id").(string)
+	var response SimulatedDeviceResponse
+	var update SimulatedDeviceUpdate
+	device, ok := manager.simulatedDevice(deviceID)
+	if !ok {
+		response.Message = fmt.Sprintf("Device id '%s' is not a simulated device.", deviceID)
+		w.WriteHeader(404)
+		jsonString, _ := json.Marshal(response)
+		w.Write([]byte(jsonString))
+		return
+	}
+	state := device.State()
+	latency, _ := time.ParseDuration(state.Latency)
+	failureRate := state.FailureRate
+	var parseErr error
+	if json.NewDecoder(r.Body).Decode(&update) != nil {
+		response.Message = "Failed to decode Response"
+	} else if update.Latency != nil {
+		latency, parseErr = time.ParseDuration(*update.Latency)
+		if parseErr != nil || latency < 0 {
+			response.Message = fmt.Sprintf("Latency '%s' is not a valid duration.", *update.Latency)
+		}
+	}
+	if update.FailureRate != nil {
+		failureRate = *update.FailureRate
+		if failureRate < 0 || failureRate > 1 {
+			response.Message = "Failure rate must be between 0 and 1."
+		}
+	}
+	if response.Message != "" {
+		w.WriteHeader(400)
+	} else {
+		device.SetFailures(latency, failureRate)
+		if update.Online != nil {
+			device.SetOnline(*update.Online)
+		}
+		state = device.State()
+		response.Success = true
+		response.Message = "Simulated device updated."
+		response.Data = &state
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+type Intrusion struct {
+	Sensor string `json:"sensor"`
+}
+
+func (manager *DeviceManager) TriggerIntrusion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	deviceID := r.Context().Value("id").(string)
+	var response SimulatedDeviceResponse
+	var intrusion Intrusion
+	device, ok := manager.simulatedDevice(deviceID)
+	if !ok {
+		response.Message = fmt.Sprintf("Device id '%s' is not a simulated device.", deviceID)
+		w.WriteHeader(404)
+	} else if r.ContentLength != 0 && json.NewDecoder(r.Body).Decode(&intrusion) != nil {
+		response.Message = "Failed to decode Response"
+		w.WriteHeader(400)
+	} else if intrusionErr := device.TriggerIntrusion(intrusion.Sensor); intrusionErr != nil {
+		response.Message = intrusionErr.Error()
+		w.WriteHeader(409)
+	} else {
+		if refreshErr := manager.refreshDevice(r.Context(), manager.httpClient(), deviceID); refreshErr != nil {
+			response.Message = fmt.Sprintf("Intrusion triggered, status update failed: %s", refreshErr)
+		} else {
+			response.Message = "Intrusion triggered."
+		}
+		state := device.State()
+		response.Success = true
+		response.Data = &state
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
@@ -0,0 +1,81 @@
+package devices
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "github.com/a-castellano/AlarmManager/config_reader"
+	"github.com/a-castellano/AlarmManager/events"
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func simulatorManager(t *testing.T) (*DeviceManager, *events.History) {
+	history := events.NewHistory(10)
+	deviceManager := &DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), History: history}
+	device := CreateDeviceFromConfig(config.TuyaDeviceConfig{Name: "Test Alarm", DeviceType: "simulated", DeviceID: "simulated1", Simulator: config.SimulatorConfig{Mode: "arm", Sensors: []string{"Hall"}}})
+	deviceManager.AddDevice(device)
+	if retrieveErr := deviceManager.RetrieveInfo(context.Background(), &http.Client{}); retrieveErr != nil {
+		t.Fatalf("Simulated device info retrieval should not fail, error was '%s'.", retrieveErr)
+	}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Real Alarm", DeviceType: "99AST", DeviceID: "testid123"})
+	return deviceManager, history
+}
+
+func TestSimulatedDeviceModeChange(t *testing.T) {
+	deviceManager, history := simulatorManager(t)
+
+	if info, ok := deviceManager.DeviceInfo("simulated1"); !ok || info.Mode != FullyArmed || !info.Online {
+		t.Fatalf("Simulated device should be armed and online, info was %+v.", info)
+	}
+	if changeErr := deviceManager.ChangeMode(context.Background(), &http.Client{}, "simulated1", "Disarmed"); changeErr != nil {
+		t.Fatalf("Simulated device mode change should not fail, error was '%s'.", changeErr)
+	}
+	deviceManager.refreshDevice(context.Background(), &http.Client{}, "simulated1")
+	recorded := history.Recent(10, events.Event{Type: events.ModeChanged})
+	if len(recorded) != 1 || recorded[0].Data["to"] != "disarmed" {
+		t.Errorf("Simulated mode change should be recorded, events were %+v.", recorded)
+	}
+}
+
+func TestSimulatorTriggerIntrusion(t *testing.T) {
+	deviceManager, history := simulatorManager(t)
+
+	recorder := httptest.NewRecorder()
+	deviceManager.SimulatorRoutes().ServeHTTP(recorder, httptest.NewRequest("POST", "/simulated1/intrusion", strings.NewReader(`{"sensor": "Hall"}`)))
+	if recorder.Code != 200 {
+		t.Fatalf("Intrusion should be triggered, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+	recorded := history.Recent(10, events.Event{Type: events.FiringStarted})
+	if len(recorded) != 1 || recorded[0].Data["reason"] != "Intrusion Hall" {
+		t.Errorf("Simulated intrusion should be recorded at once, events were %+v.", recorded)
+	}
+
+	recorder = httptest.NewRecorder()
+	deviceManager.SimulatorRoutes().ServeHTTP(recorder, httptest.NewRequest("POST", "/testid123/intrusion", nil))
+	if recorder.Code != 404 {
+		t.Errorf("Intrusion on Tuya devices should return 404, response was %d.", recorder.Code)
+	}
+}
+
+func TestSimulatorUpdateDevice(t *testing.T) {
+	deviceManager, _ := simulatorManager(t)
+
+	recorder := httptest.NewRecorder()
+	deviceManager.SimulatorRoutes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/simulated1", strings.NewReader(`{"online": false, "latency": "10ms", "failure_rate": 0.5}`)))
+	if recorder.Code != 200 {
+		t.Fatalf("Simulated device should be updated, response was %d %s.", recorder.Code, recorder.Body.String())
+	}
+	device, _ := deviceManager.simulatedDevice("simulated1")
+	if state := device.State(); state.Online || state.Latency != "10ms" || state.FailureRate != 0.5 {
+		t.Errorf("Simulated device state was not updated, state was %+v.", state)
+	}
+
+	recorder = httptest.NewRecorder()
+	deviceManager.SimulatorRoutes().ServeHTTP(recorder, httptest.NewRequest("PUT", "/simulated1", strings.NewReader(`{"failure_rate": 2}`)))
+	if recorder.Code != 400 {
+		t.Errorf("Invalid failure rate should return 400, response was %d.", recorder.Code)
+	}
+}
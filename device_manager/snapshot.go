@@ -0,0 +1,98 @@
+package devices
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot is the state of every device at one point in time. Published
+// snapshots are never modified, so readers get a consistent view without
+// locking while devices are polled.
+type Snapshot struct {
+	Alarms map[string]Alarm
+	// Versions counts state changes of each device
+	Versions map[string]uint64
+	// changed is closed once a newer snapshot is published
+	changed chan struct{}
+}
+
+// Alarm returns last retrieved state of deviceID.
+func (snapshot *Snapshot) Alarm(deviceID string) (Alarm, bool) {
+	alarm, ok := snapshot.Alarms[deviceID]
+	return alarm, ok
+}
+
+// Version returns how many times deviceID state has changed, it is 0 until
+// its info is retrieved.
+func (snapshot *Snapshot) Version(deviceID string) uint64 {
+	return snapshot.Versions[deviceID]
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{Alarms: map[string]Alarm{}, Versions: map[string]uint64{}, changed: make(chan struct{})}
+}
+
+// Snapshot returns the latest published state of managed devices, devices
+// whose info has not been retrieved yet are not included.
+func (manager *DeviceManager) Snapshot() *Snapshot {
+	if snapshot, ok := manager.snapshot.Load().(*Snapshot); ok {
+		return snapshot
+	}
+	manager.snapshotOnce.Do(func() {
+		manager.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+		manager.snapshot.Store(newSnapshot())
+	})
+	return manager.snapshot.Load().(*Snapshot)
+}
+
+// publish stores a copy of the current snapshot with deviceID state set to
+// alarm and wakes up waiting readers. Nothing is published when the state
+// has not changed. Mutex must be held so concurrent updates are not lost.
+func (manager *DeviceManager) publish(deviceID string, alarm Alarm) {
+	current := manager.Snapshot()
+	if previous, ok := current.Alarm(deviceID); ok && previous.ShowInfo() == alarm.ShowInfo() {
+		return
+	}
+	next := newSnapshot()
+	for id, currentAlarm := range current.Alarms {
+		next.Alarms[id] = currentAlarm
+		next.Versions[id] = current.Versions[id]
+	}
+	next.Alarms[deviceID] = alarm
+	next.Versions[deviceID]++
+	manager.snapshot.Store(next)
+	close(current.changed)
+}
+
+// ETag identifies the state of deviceIDs in snapshot, it changes whenever
+// any of them changes or the service is restarted.
+func (manager *DeviceManager) ETag(snapshot *Snapshot, deviceIDs ...string) string {
+	versions := make([]string, len(deviceIDs))
+	for index, deviceID := range deviceIDs {
+		versions[index] = strconv.FormatUint(snapshot.Version(deviceID), 10)
+	}
+	return fmt.Sprintf("\"%s-%s\"", manager.epoch, strings.Join(versions, "."))
+}
+
+// WaitForChange returns the first snapshot whose ETag of deviceIDs is not
+// etag. The latest snapshot is returned when wait passes or ctx is done.
+func (manager *DeviceManager) WaitForChange(ctx context.Context, etag string, deviceIDs []string, wait time.Duration) *Snapshot {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		snapshot := manager.Snapshot()
+		if manager.ETag(snapshot, deviceIDs...) != etag {
+			return snapshot
+		}
+		select {
+		case <-snapshot.changed:
+		case <-timer.C:
+			return manager.Snapshot()
+		case <-ctx.Done():
+			return manager.Snapshot()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package devices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	config "github.com/a-castellano/AlarmManager/config_reader"
+	"github.com/a-castellano/AlarmManager/events"
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func TestSnapshotIsImmutable(t *testing.T) {
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	if alarms := deviceManager.Snapshot().Alarms; len(alarms) != 0 {
+		t.Errorf("Snapshot should be empty before devices are polled, alarms were %v.", alarms)
+	}
+	deviceManager.publish("home123", DriverAlarm{Info: AlarmInfo{Mode: Disarmed}})
+	snapshot := deviceManager.Snapshot()
+	deviceManager.publish("home123", DriverAlarm{Info: AlarmInfo{Mode: FullyArmed}})
+	deviceManager.publish("garage123", DriverAlarm{Info: AlarmInfo{Mode: HomeArmed}})
+
+	if alarm, ok := snapshot.Alarm("home123"); !ok || alarm.ShowInfo().Mode != Disarmed || len(snapshot.Alarms) != 1 {
+		t.Errorf("Published snapshots should not change, alarms were %v.", snapshot.Alarms)
+	}
+	if alarm, ok := deviceManager.Snapshot().Alarm("home123"); !ok || alarm.ShowInfo().Mode != FullyArmed || len(deviceManager.Snapshot().Alarms) != 2 {
+		t.Errorf("Latest snapshot should include every update, alarms were %v.", deviceManager.Snapshot().Alarms)
+	}
+}
+
+// TestAPIDuringPolling serves every read and write route while devices are
+// polled, run with -race to detect unsynchronized state.
+func TestAPIDuringPolling(t *testing.T) {
+	history := events.NewHistory(100)
+	deviceManager := &DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), History: history, ConfirmationTimeout: 20 * time.Millisecond, ConfirmationInterval: time.Millisecond}
+	var deviceIDs []string
+	for index := 0; index < 3; index++ {
+		deviceID := fmt.Sprintf("simulated%d", index)
+		deviceIDs = append(deviceIDs, deviceID)
+		deviceManager.AddDevice(CreateDeviceFromConfig(config.TuyaDeviceConfig{Name: deviceID, DeviceType: "simulated", DeviceID: deviceID, Simulator: config.SimulatorConfig{Mode: "arm", Sensors: []string{"Hall"}}}))
+	}
+	deviceManager.AddGroup("house", Group{Name: "House", DeviceIDs: deviceIDs})
+	if retrieveErr := deviceManager.RetrieveInfo(context.Background(), &http.Client{}); retrieveErr != nil {
+		t.Fatalf("Simulated device info retrieval should not fail, error was '%s'.", retrieveErr)
+	}
+	deviceManager.Jobs = NewJobManager(context.Background(), deviceManager, &http.Client{})
+	defer deviceManager.Jobs.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for ctx.Err() == nil {
+			if retrieveErr := deviceManager.RetrieveInfo(ctx, &http.Client{}); retrieveErr != nil && ctx.Err() == nil {
+				t.Errorf("Simulated devices should be polled, error was '%s'.", retrieveErr)
+			}
+		}
+	}()
+
+	serve := func(handler http.Handler, method string, target string, body string) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if recorder.Code >= 500 && recorder.Code != 503 {
+			t.Errorf("%s %s should not fail, response was %d %s.", method, target, recorder.Code, recorder.Body.String())
+		}
+	}
+	routes, groupRoutes, simulatorRoutes, jobRoutes := deviceManager.Routes(), deviceManager.GroupRoutes(), deviceManager.SimulatorRoutes(), deviceManager.Jobs.Routes()
+	readers := []func(){
+		func() { serve(routes, "GET", "/", "") },
+		func() { serve(routes, "GET", "/status/simulated0", "") },
+		func() { serve(routes, "GET", "/offline?days=2", "") },
+		func() { serve(groupRoutes, "GET", "/house", "") },
+		func() { serve(simulatorRoutes, "GET", "/", "") },
+		func() { serve(jobRoutes, "GET", "/unknown", "") },
+		func() { deviceManager.Health() },
+		func() { deviceManager.DeviceInfo("simulated1") },
+	}
+	writers := []func(){
+		func() { serve(routes, "PUT", "/status/simulated1", `{"mode": "HomeArmed"}`) },
+		func() { serve(routes, "PUT", "/status/simulated2?async=true", `{"mode": "Disarmed"}`) },
+		func() { serve(groupRoutes, "PUT", "/house", `{"mode": "Armed"}`) },
+		func() { serve(simulatorRoutes, "POST", "/simulated0/intrusion", "") },
+		func() { serve(simulatorRoutes, "PUT", "/simulated2", `{"online": false}`) },
+		func() { serve(simulatorRoutes, "PUT", "/simulated2", `{"online": true}`) },
+	}
+	for _, request := range append(readers, writers...) {
+		wait.Add(1)
+		go func(request func()) {
+			defer wait.Done()
+			for ctx.Err() == nil {
+				request()
+			}
+		}(request)
+	}
+	wait.Wait()
+}
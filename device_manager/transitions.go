@@ -0,0 +1,66 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/a-castellano/AlarmManager/events"
+)
+
+// recordTransitions records an event for each difference between previous
+// and current device state, online state is tracked by updateConnectivity.
+// Devices seen for the first time are compared against a quiet device in the
+// same mode running on mains power without low battery, so firing, mains
+// lost and low battery are reported at startup. Mutex must be held.
+func (manager *DeviceManager) recordTransitions(deviceID string, deviceName string,
+	previous AlarmInfo, current AlarmInfo) {
+	if manager.History == nil {
+		return
+	}
+	record := func(eventType string, message string, data map[string]string) {
+		manager.History.Record(events.Event{Type: eventType, Source: "device_manager",
+			DeviceID: deviceID, Success: true, Message: message, Data: data})
+	}
+	if previous.Mode != current.Mode {
+		from := AlarmModeAlarmValues[previous.Mode]
+		to := AlarmModeAlarmValues[current.Mode]
+		// Mode changes are attributed to whoever requested the last one
+		actor := manager.modeActors[deviceID]
+		delete(manager.modeActors, deviceID)
+		manager.History.Record(events.Event{Type: events.ModeChanged, Source: "device_manager",
+			DeviceID: deviceID, Success: true, Actor: actor,
+			Data:    map[string]string{"from": from, "to": to},
+			Message: fmt.Sprintf("%s mode changed from '%s' to '%s'.", deviceName, from, to)})
+	}
+	if !previous.Firing && current.Firing {
+		var data map[string]string
+		if current.Reason != "" {
+			data = map[string]string{"reason": current.Reason}
+		}
+		record(events.FiringStarted, fmt.Sprintf("%s is firing.", deviceName), data)
+	}
+	if previous.Firing && !current.Firing {
+		record(events.FiringStopped, fmt.Sprintf("%s has stopped firing.", deviceName), nil)
+	}
+	if previous.PowerSource == MainsPower && current.PowerSource == BatteryPower {
+		message := fmt.Sprintf("%s has lost mains power, running on battery.", deviceName)
+		record(events.MainsLost, message, nil)
+	}
+	if previous.PowerSource == BatteryPower && current.PowerSource == MainsPower {
+		record(events.MainsRestored, fmt.Sprintf("%s mains power has been restored.", deviceName), nil)
+	}
+	if !previous.LowBattery && current.LowBattery {
+		record(events.LowBattery, fmt.Sprintf("%s battery is low.", deviceName), nil)
+	}
+	if previous.LowBattery && !current.LowBattery {
+		record(events.BatteryOK, fmt.Sprintf("%s battery is no longer low.", deviceName), nil)
+	}
+}
+
+// DeviceInfo returns last retrieved info of deviceID.
+func (manager *DeviceManager) DeviceInfo(deviceID string) (AlarmInfo, bool) {
+	alarm, ok := manager.Snapshot().Alarm(deviceID)
+	if !ok {
+		return AlarmInfo{}, false
+	}
+	return alarm.ShowInfo(), true
+}
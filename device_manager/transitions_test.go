@@ -0,0 +1,149 @@
+package devices
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-castellano/AlarmManager/events"
+	tuyadevice "github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func TestRetrieveInfoRecordsTransitions(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "arm"}
+	deviceManager := jobsManager(t, transport)
+	history := events.NewHistory(10)
+	deviceManager.History = history
+
+	transport.Modes = map[string]string{"testid123": "disarmed"}
+	if retrieveInfoError := deviceManager.RetrieveInfo(context.Background(), &http.Client{Transport: transport}); retrieveInfoError != nil {
+		t.Fatalf("Device Manager info retrieval should not fail. Error was %s", retrieveInfoError)
+	}
+	recorded := history.Recent(10, events.Event{})
+	if len(recorded) != 1 || recorded[0].Type != events.ModeChanged || recorded[0].DeviceID != "testid123" {
+		t.Fatalf("Mode change should be recorded, events were %+v.", recorded)
+	}
+	if recorded[0].Data["from"] != "arm" || recorded[0].Data["to"] != "disarmed" {
+		t.Errorf("Mode change should be from 'arm' to 'disarmed', data was %v.", recorded[0].Data)
+	}
+
+	deviceManager.RetrieveInfo(context.Background(), &http.Client{Transport: transport})
+	if recorded := history.Recent(10, events.Event{}); len(recorded) != 1 {
+		t.Errorf("Unchanged devices should not record events, events were %+v.", recorded)
+	}
+}
+
+func TestDeviceInfo(t *testing.T) {
+
+	deviceManager := jobsManager(t, &AlarmRoundTripperMock{Mode: "home"})
+
+	if info, ok := deviceManager.DeviceInfo("testid123"); !ok || info.Mode != HomeArmed || !info.Online {
+		t.Errorf("DeviceInfo should return home armed online device, returned %+v.", info)
+	}
+	if _, ok := deviceManager.DeviceInfo("nonexistent"); ok {
+		t.Errorf("DeviceInfo should not return info of unmanaged devices.")
+	}
+}
+
+func powerStatusJSON(charging bool, lowBattery bool) string {
+	return strings.Replace(alarmStatusJSON("arm", "normal"), `"status":[`, fmt.Sprintf(`"status":[{"code":"charge_state","value":%t},{"code":"switch_low_battery","value":%t},`, charging, lowBattery), 1)
+}
+
+func TestRetrieveInfoPowerTransitions(t *testing.T) {
+
+	history := events.NewHistory(10)
+	deviceManager := DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), History: history}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Test Device", DeviceType: "99AST", DeviceID: "testid123"})
+	deviceManager.Start(context.Background(), &http.Client{Transport: &AlarmRoundTripperMock{}})
+
+	client := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{powerStatusJSON(false, true), powerStatusJSON(true, false)}}}
+	deviceManager.RetrieveInfo(context.Background(), client)
+	recorded := history.Recent(10, events.Event{})
+	if len(recorded) != 2 || recorded[1].Type != events.MainsLost || recorded[0].Type != events.LowBattery {
+		t.Fatalf("Startup on battery should record mains lost and low battery, events were %+v.", recorded)
+	}
+
+	recorder := httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/status/testid123", nil))
+	if !strings.Contains(recorder.Body.String(), `"power_source":"battery","low_battery":true`) {
+		t.Errorf("Device status should show battery power, returned '%s'.", recorder.Body.String())
+	}
+
+	deviceManager.RetrieveInfo(context.Background(), client)
+	recorded = history.Recent(2, events.Event{})
+	if len(recorded) != 2 || recorded[1].Type != events.MainsRestored || recorded[0].Type != events.BatteryOK {
+		t.Errorf("Mains restore should be recorded, events were %+v.", recorded)
+	}
+}
+
+// clientRequest returns a request sent with a client certificate of identity.
+func clientRequest(method string, target string, body string, identity string) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: identity}}}}
+	return request
+}
+
+func TestModeChangesRecordActor(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := jobsManager(t, transport)
+	deviceManager.Client = &http.Client{Transport: transport}
+	history := events.NewHistory(10)
+	deviceManager.History = history
+
+	recorder := httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, clientRequest("PUT", "/status/testid123", `{"mode": "Armed"}`, "kitchen-panel"))
+	if recorder.Code != 200 {
+		t.Fatalf("Mode change should return 200, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+	if recorded := history.Recent(1, events.Event{Type: events.ModeChanged}); len(recorded) != 1 || recorded[0].Actor != "kitchen-panel" {
+		t.Errorf("Mode change should be attributed to kitchen-panel, events were %+v.", recorded)
+	}
+
+	recorder = httptest.NewRecorder()
+	deviceManager.Routes().ServeHTTP(recorder, clientRequest("PUT", "/status/testid123?async=true", `{"mode": "Disarmed"}`, "hall-panel"))
+	var response JobResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if recorder.Code != 202 || response.Job == nil {
+		t.Fatalf("Async mode change should return 202, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+	waitJob(t, deviceManager, response.Job.ID)
+	if recorded := history.Recent(1, events.Event{Type: events.ModeChanged}); len(recorded) != 1 || recorded[0].Actor != "hall-panel" {
+		t.Errorf("Job mode change should be attributed to hall-panel, events were %+v.", recorded)
+	}
+
+	transport.mutex.Lock()
+	transport.Modes["testid123"] = "arm"
+	transport.mutex.Unlock()
+	deviceManager.RetrieveInfo(context.Background(), &http.Client{Transport: transport})
+	if recorded := history.Recent(1, events.Event{Type: events.ModeChanged}); len(recorded) != 1 || recorded[0].Actor != "" {
+		t.Errorf("Mode changes made on the device should not be attributed, events were %+v.", recorded)
+	}
+}
+
+func TestGroupModeChangeRecordsActor(t *testing.T) {
+
+	transport := &AlarmRoundTripperMock{Mode: "disarmed"}
+	deviceManager := groupsManager(t, transport)
+	deviceManager.Client = &http.Client{Transport: transport}
+	history := events.NewHistory(10)
+	deviceManager.History = history
+
+	recorder := httptest.NewRecorder()
+	deviceManager.GroupRoutes().ServeHTTP(recorder, clientRequest("PUT", "/premises", `{"mode": "Armed"}`, "kitchen-panel"))
+	if recorder.Code != 200 {
+		t.Fatalf("Group mode change should return 200, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+	recorded := history.Recent(10, events.Event{Type: events.ModeChanged})
+	if len(recorded) != 2 || recorded[0].Actor != "kitchen-panel" || recorded[1].Actor != "kitchen-panel" {
+		t.Errorf("Member mode changes should be attributed to kitchen-panel, events were %+v.", recorded)
+	}
+}
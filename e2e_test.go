@@ -0,0 +1,144 @@
+//go:build integration_tests
+// +build integration_tests
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/escalation"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/notifier"
+	"github.com/a-castellano/AlarmManager/scheduler"
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+	"github.com/a-castellano/AlarmManager/tuyadevice/tuyatest"
+)
+
+type e2eStatus struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"msg"`
+	Mode         string `json:"mode"`
+	Firing       bool   `json:"firing"`
+	Confirmation string `json:"confirmation"`
+}
+
+// e2eAPI serves the API of a service managing the devices of cloud.
+func e2eAPI(t *testing.T, cloud *tuyatest.Server) (*httptest.Server, *device_manager.DeviceManager) {
+	client := &http.Client{}
+	deviceManager := device_manager.DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), ConfirmationTimeout: time.Second, ConfirmationInterval: 10 * time.Millisecond, Client: client}
+	deviceManager.AddDevice(&tuyadevice.TuyaDevice{Name: "Home Alarm", DeviceType: "99AST", Host: cloud.URL, ClientID: cloud.ClientID, Secret: cloud.Secret, DeviceID: "device123"})
+	history := events.NewHistory(events.DefaultHistorySize)
+	deviceManager.History = history
+	if startErr := deviceManager.Start(context.Background(), client); startErr != nil {
+		t.Fatalf("Device manager should start against fake cloud, error was '%s'.", startErr)
+	}
+	if retrieveErr := deviceManager.RetrieveInfo(context.Background(), client); retrieveErr != nil {
+		t.Fatalf("Device manager should retrieve info from fake cloud, error was '%s'.", retrieveErr)
+	}
+	deviceManager.Jobs = device_manager.NewJobManager(context.Background(), &deviceManager, client)
+	alarmScheduler, _ := scheduler.New(&deviceManager, client, history, nil)
+	escalator := escalation.New(history, notifier.New(context.Background(), history, nil))
+	api := httptest.NewServer(newRouter("test", apiServices{deviceManager: &deviceManager, history: history, scheduler: alarmScheduler, escalator: escalator}))
+	return api, &deviceManager
+}
+
+func e2eRequest(t *testing.T, method string, url string, body string, decoded interface{}) int {
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response, requestErr := http.DefaultClient.Do(request)
+	if requestErr != nil {
+		t.Fatalf("%s %s should not fail, error was '%s'.", method, url, requestErr)
+	}
+	defer response.Body.Close()
+	json.NewDecoder(response.Body).Decode(decoded)
+	return response.StatusCode
+}
+
+func TestEndToEndChangeMode(t *testing.T) {
+	cloud := tuyatest.NewServer("client123", "secret123")
+	defer cloud.Close()
+	cloud.AddDevice(tuyatest.AlarmDevice("device123", "Home Alarm", "disarmed"))
+	api, _ := e2eAPI(t, cloud)
+	defer api.Close()
+
+	var status e2eStatus
+	if code := e2eRequest(t, "GET", api.URL+"/devices/status/device123", "", &status); code != 200 || status.Mode != "disarmed" {
+		t.Fatalf("Device should be disarmed, response was %d %+v.", code, status)
+	}
+	status = e2eStatus{}
+	if code := e2eRequest(t, "PUT", api.URL+"/devices/status/device123", `{"mode": "Armed"}`, &status); code != 200 || status.Confirmation != "confirmed" {
+		t.Fatalf("Mode change should be confirmed, response was %d %+v.", code, status)
+	}
+	if mode := cloud.StatusValue("device123", "master_mode"); mode != "arm" {
+		t.Errorf("Fake cloud device should be armed, mode is '%v'.", mode)
+	}
+
+	var recorded events.EventListResponse
+	e2eRequest(t, "GET", api.URL+"/events?type=mode_changed", "", &recorded)
+	if len(recorded.Data) != 1 || recorded.Data[0].Data["to"] != "arm" {
+		t.Errorf("Mode change should be recorded, events were %+v.", recorded.Data)
+	}
+}
+
+func TestEndToEndFiring(t *testing.T) {
+	cloud := tuyatest.NewServer("client123", "secret123")
+	defer cloud.Close()
+	cloud.AddDevice(tuyatest.AlarmDevice("device123", "Home Alarm", "arm"))
+	api, deviceManager := e2eAPI(t, cloud)
+	defer api.Close()
+
+	cloud.SetStatus("device123", "master_state", "alarm")
+	deviceManager.RetrieveInfo(context.Background(), &http.Client{})
+
+	var status e2eStatus
+	if e2eRequest(t, "GET", api.URL+"/devices/status/device123", "", &status); !status.Firing {
+		t.Errorf("Device should be firing, response was %+v.", status)
+	}
+	var recorded events.EventListResponse
+	e2eRequest(t, "GET", api.URL+"/events?type=firing_started", "", &recorded)
+	if len(recorded.Data) != 1 {
+		t.Errorf("Firing should be recorded, events were %+v.", recorded.Data)
+	}
+}
+
+func TestEndToEndCloudErrors(t *testing.T) {
+	cloud := tuyatest.NewServer("client123", "secret123")
+	defer cloud.Close()
+	cloud.AddDevice(tuyatest.AlarmDevice("device123", "Home Alarm", "disarmed"))
+	api, _ := e2eAPI(t, cloud)
+	defer api.Close()
+
+	cloud.InjectError(tuyatest.CodeTokenInvalid, 1)
+	var status e2eStatus
+	if code := e2eRequest(t, "PUT", api.URL+"/devices/status/device123", `{"mode": "Armed"}`, &status); code != 200 || status.Confirmation != "confirmed" {
+		t.Errorf("Mode change should succeed with a new token, response was %d %+v.", code, status)
+	}
+
+	cloud.SetOnline("device123", false)
+	status = e2eStatus{}
+	if code := e2eRequest(t, "PUT", api.URL+"/devices/status/device123", `{"mode": "Disarmed"}`, &status); code != 503 || !strings.Contains(status.Message, "device is offline") {
+		t.Errorf("Mode change should fail with offline device, response was %d %+v.", code, status)
+	}
+	cloud.SetOnline("device123", true)
+
+	cloud.InjectError(tuyatest.CodeRateLimited, 1)
+	if code := e2eRequest(t, "PUT", api.URL+"/devices/status/device123", `{"mode": "Disarmed"}`, &status); code != 429 {
+		t.Errorf("Rate limited mode change should return 429, response was %d %+v.", code, status)
+	}
+
+	// command and token renewal are both rejected
+	cloud.InjectError(tuyatest.CodeTokenInvalid, 2)
+	if code := e2eRequest(t, "PUT", api.URL+"/devices/status/device123", `{"mode": "Disarmed"}`, &status); code != 502 {
+		t.Errorf("Mode change should return 502 when tokens are rejected, response was %d %+v.", code, status)
+	}
+	if commands := cloud.Commands(); len(commands) != 1 {
+		t.Errorf("Fake cloud should accept only the first command, it accepted %+v.", commands)
+	}
+}
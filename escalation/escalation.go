@@ -0,0 +1,316 @@
+package escalation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/logging"
+	"github.com/a-castellano/AlarmManager/webtls"
+	chi "github.com/go-chi/chi/v5"
+)
+
+var logger = logging.New("escalation")
+
+// Notifier is implemented by notifier.Notifier.
+type Notifier interface {
+	NotifyChannels(channelNames []string, event events.Event, message string) error
+}
+
+// Policy notifies First channels as soon as a device of DeviceIDs, or any
+// device when empty, starts firing. When nobody acknowledges the alarm
+// during EscalateAfter, Second channels are notified, and notified again
+// every RepeatEvery until the alarm is acknowledged or stops.
+type Policy struct {
+	ID            string
+	DeviceIDs     []string
+	First         []string
+	Second        []string
+	EscalateAfter time.Duration
+	RepeatEvery   time.Duration
+}
+
+// Incident is an alarm being escalated.
+type Incident struct {
+	PolicyID      string    `json:"policy"`
+	DeviceID      string    `json:"device_id"`
+	Started       time.Time `json:"started"`
+	Level         int       `json:"level"`
+	Notifications int       `json:"notifications"`
+	LastNotified  time.Time `json:"last_notified"`
+	event         events.Event
+	timer         *time.Timer
+}
+
+type Escalator struct {
+	history   *events.History
+	notifier  Notifier
+	policies  []Policy
+	incidents map[string]*Incident
+	mutex     sync.Mutex
+}
+
+func New(history *events.History, notifier Notifier) *Escalator {
+	return &Escalator{history: history, notifier: notifier, incidents: make(map[string]*Incident)}
+}
+
+func (escalator *Escalator) AddPolicy(policy Policy) error {
+	if policy.ID == "" {
+		return errors.New("Escalation policy id is required.")
+	}
+	if len(policy.First) == 0 {
+		errorString := fmt.Sprintf("Escalation policy '%s' has no channels to notify first.", policy.ID)
+		return errors.New(errorString)
+	}
+	if len(policy.Second) > 0 && policy.EscalateAfter <= 0 {
+		errorString := fmt.Sprintf("Escalation policy '%s' requires a positive escalation delay.", policy.ID)
+		return errors.New(errorString)
+	}
+	if policy.RepeatEvery < 0 {
+		errorString := fmt.Sprintf("Escalation policy '%s' repeat interval can't be negative.", policy.ID)
+		return errors.New(errorString)
+	}
+	escalator.mutex.Lock()
+	defer escalator.mutex.Unlock()
+	for _, existingPolicy := range escalator.policies {
+		if existingPolicy.ID == policy.ID {
+			errorString := fmt.Sprintf("Escalation policy '%s' already exists.", policy.ID)
+			return errors.New(errorString)
+		}
+	}
+	escalator.policies = append(escalator.policies, policy)
+	return nil
+}
+
+func (policy *Policy) covers(deviceID string) bool {
+	if len(policy.DeviceIDs) == 0 {
+		return true
+	}
+	for _, policyDeviceID := range policy.DeviceIDs {
+		if policyDeviceID == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Run follows firing state of devices received from subscription until ctx
+// is done. Subscription must be created before devices are first polled so
+// alarms firing at startup are escalated too.
+func (escalator *Escalator) Run(ctx context.Context, subscription <-chan events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			escalator.mutex.Lock()
+			for key, incident := range escalator.incidents {
+				incident.timer.Stop()
+				delete(escalator.incidents, key)
+			}
+			escalator.mutex.Unlock()
+			return
+		case event := <-subscription:
+			escalator.Handle(event)
+		}
+	}
+}
+
+// Handle starts escalating alarms when devices start firing and stops when
+// they stop firing or are disarmed.
+func (escalator *Escalator) Handle(event events.Event) {
+	disarmed := device_manager.AlarmModeAlarmValues[device_manager.Disarmed]
+	switch {
+	case event.Type == events.FiringStarted:
+		escalator.start(event)
+	case event.Type == events.FiringStopped, event.Type == events.ModeChanged && event.Data["to"] == disarmed:
+		for _, incident := range escalator.stop(event.DeviceID) {
+			logger.Info("Escalation stopped.", "policy", incident.PolicyID, "device", incident.DeviceID, "reason", event.Message)
+		}
+	}
+}
+
+func (escalator *Escalator) start(event events.Event) {
+	escalator.mutex.Lock()
+	var started []*Incident
+	for index := range escalator.policies {
+		policy := escalator.policies[index]
+		key := policy.ID + "/" + event.DeviceID
+		if _, ok := escalator.incidents[key]; ok || !policy.covers(event.DeviceID) {
+			continue
+		}
+		incident := &Incident{PolicyID: policy.ID, DeviceID: event.DeviceID, Started: time.Now(), event: event}
+		escalator.incidents[key] = incident
+		if len(policy.Second) > 0 {
+			incident.timer = time.AfterFunc(policy.EscalateAfter, func() { escalator.escalate(policy, key) })
+		} else if policy.RepeatEvery > 0 {
+			incident.timer = time.AfterFunc(policy.RepeatEvery, func() { escalator.escalate(policy, key) })
+		} else {
+			incident.timer = time.NewTimer(0)
+			incident.timer.Stop()
+		}
+		started = append(started, incident)
+	}
+	escalator.mutex.Unlock()
+
+	for _, incident := range started {
+		escalator.notify(escalator.policy(incident.PolicyID).First, incident, 1, event.Message)
+	}
+}
+
+func (escalator *Escalator) policy(policyID string) Policy {
+	escalator.mutex.Lock()
+	defer escalator.mutex.Unlock()
+	for _, policy := range escalator.policies {
+		if policy.ID == policyID {
+			return policy
+		}
+	}
+	return Policy{}
+}
+
+// escalate notifies the last level of policy and schedules next repetition.
+func (escalator *Escalator) escalate(policy Policy, key string) {
+	escalator.mutex.Lock()
+	incident, ok := escalator.incidents[key]
+	if !ok {
+		escalator.mutex.Unlock()
+		return
+	}
+	level := 2
+	channels := policy.Second
+	if len(channels) == 0 {
+		level = 1
+		channels = policy.First
+	}
+	if policy.RepeatEvery > 0 {
+		incident.timer = time.AfterFunc(policy.RepeatEvery, func() { escalator.escalate(policy, key) })
+	}
+	elapsed := time.Since(incident.Started).Round(time.Second)
+	escalator.mutex.Unlock()
+
+	message := fmt.Sprintf("%s Not acknowledged after %s.", incident.event.Message, elapsed)
+	escalator.notify(channels, incident, level, message)
+}
+
+func (escalator *Escalator) notify(channels []string, incident *Incident, level int, message string) {
+	escalator.mutex.Lock()
+	incident.Level = level
+	incident.Notifications++
+	incident.LastNotified = time.Now()
+	event := incident.event
+	escalator.mutex.Unlock()
+
+	escalationEvent := events.Event{Type: events.Escalated, Source: "escalation " + incident.PolicyID, DeviceID: incident.DeviceID, Success: true, Message: fmt.Sprintf("Level %d notified.", level), Data: map[string]string{"level": fmt.Sprintf("%d", level)}}
+	if notifyErr := escalator.notifier.NotifyChannels(channels, event, message); notifyErr != nil {
+		logger.Error("Escalation notification failed.", "policy", incident.PolicyID, "level", level, "error", notifyErr)
+		escalationEvent.Success = false
+		escalationEvent.Message = notifyErr.Error()
+	}
+	escalator.history.Record(escalationEvent)
+}
+
+// stop ends every escalation of deviceID and returns them.
+func (escalator *Escalator) stop(deviceID string) []Incident {
+	escalator.mutex.Lock()
+	defer escalator.mutex.Unlock()
+	var stopped []Incident
+	for key, incident := range escalator.incidents {
+		if incident.DeviceID != deviceID {
+			continue
+		}
+		incident.timer.Stop()
+		delete(escalator.incidents, key)
+		stopped = append(stopped, *incident)
+	}
+	return stopped
+}
+
+// Acknowledge stops escalations of deviceID and records who acknowledged
+// them and the identity of the client which did it, it returns false when
+// there is nothing to acknowledge.
+func (escalator *Escalator) Acknowledge(deviceID string, by string, actor string) ([]Incident, bool) {
+	stopped := escalator.stop(deviceID)
+	if len(stopped) == 0 {
+		return nil, false
+	}
+	if by == "" {
+		by = "unknown"
+	}
+	escalator.history.Record(events.Event{Type: events.Acknowledged, Source: "api", DeviceID: deviceID, Success: true, Message: fmt.Sprintf("Alarm acknowledged by %s.", by), Data: map[string]string{"by": by}, Actor: actor})
+	return stopped, true
+}
+
+// Incidents returns alarms being escalated, oldest first.
+func (escalator *Escalator) Incidents() []Incident {
+	escalator.mutex.Lock()
+	defer escalator.mutex.Unlock()
+	incidents := []Incident{}
+	for _, incident := range escalator.incidents {
+		incidents = append(incidents, *incident)
+	}
+	sort.Slice(incidents, func(i, j int) bool { return incidents[i].Started.Before(incidents[j].Started) })
+	return incidents
+}
+
+func (escalator *Escalator) Routes() chi.Router {
+	router := chi.NewRouter()
+	router.Get("/", escalator.ListIncidents)
+	router.Route("/{id}", func(r chi.Router) {
+		r.Use(device_manager.DeviceCtx)
+		r.Post("/ack", escalator.AcknowledgeAlarm)
+	})
+	return router
+}
+
+type IncidentListResponse struct {
+	Success bool       `json:"success"`
+	Message string     `json:"msg"`
+	Data    []Incident `json:"data"`
+}
+
+func (escalator *Escalator) ListIncidents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	response := IncidentListResponse{Success: true, Data: escalator.Incidents()}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+type Acknowledgement struct {
+	By string `json:"by"`
+}
+
+// AcknowledgeAlarm records the client certificate identity as acknowledger,
+// by is only used when the client has not sent a certificate.
+func (escalator *Escalator) AcknowledgeAlarm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	deviceID := r.Context().Value("id").(string)
+	var acknowledgement Acknowledgement
+	var response IncidentListResponse
+	var decodeErr error
+	if r.ContentLength != 0 {
+		decodeErr = json.NewDecoder(r.Body).Decode(&acknowledgement)
+	}
+	identity := webtls.Identity(r)
+	if identity != "" {
+		acknowledgement.By = identity
+	}
+	if decodeErr != nil {
+		response.Message = "Failed to decode Response"
+		w.WriteHeader(400)
+	} else if incidents, ok := escalator.Acknowledge(deviceID, acknowledgement.By, identity); !ok {
+		response.Message = fmt.Sprintf("Device id '%s' has no alarm to acknowledge.", deviceID)
+		w.WriteHeader(404)
+	} else {
+		response.Success = true
+		response.Message = "Alarm acknowledged."
+		response.Data = incidents
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
@@ -0,0 +1,136 @@
+package escalation
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/events"
+)
+
+type NotifierMock struct {
+	Notified []string
+	mutex    sync.Mutex
+}
+
+func (nm *NotifierMock) NotifyChannels(channelNames []string, event events.Event, message string) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	nm.Notified = append(nm.Notified, strings.Join(channelNames, ","))
+	return nil
+}
+
+func (nm *NotifierMock) notified() []string {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	return append([]string{}, nm.Notified...)
+}
+
+func testEscalator(t *testing.T) (*Escalator, *NotifierMock, *events.History) {
+	history := events.NewHistory(20)
+	notifier := &NotifierMock{}
+	escalator := New(history, notifier)
+	if policyErr := escalator.AddPolicy(Policy{ID: "night", DeviceIDs: []string{"home123"}, First: []string{"phone"}, Second: []string{"neighbour", "email"}, EscalateAfter: 30 * time.Millisecond, RepeatEvery: 30 * time.Millisecond}); policyErr != nil {
+		t.Fatalf("AddPolicy should not fail, error was '%s'.", policyErr)
+	}
+	return escalator, notifier, history
+}
+
+var firing = events.Event{Type: events.FiringStarted, DeviceID: "home123", Message: "Home Alarm is firing."}
+
+func TestEscalation(t *testing.T) {
+
+	escalator, notifier, _ := testEscalator(t)
+
+	escalator.Handle(events.Event{Type: events.FiringStarted, DeviceID: "office123"})
+	escalator.Handle(firing)
+	if notified := notifier.notified(); len(notified) != 1 || notified[0] != "phone" {
+		t.Fatalf("First level should be notified immediately, notified %v.", notified)
+	}
+	time.Sleep(100 * time.Millisecond)
+	notified := notifier.notified()
+	if len(notified) < 3 || notified[1] != "neighbour,email" || notified[2] != "neighbour,email" {
+		t.Fatalf("Second level should be notified and repeated, notified %v.", notified)
+	}
+
+	escalator.Handle(events.Event{Type: events.ModeChanged, DeviceID: "home123", Data: map[string]string{"from": "arm", "to": "disarmed"}})
+	count := len(notifier.notified())
+	time.Sleep(70 * time.Millisecond)
+	if len(notifier.notified()) != count {
+		t.Errorf("Disarmed alarms should not be escalated, notified %v.", notifier.notified())
+	}
+	if len(escalator.Incidents()) != 0 {
+		t.Errorf("Disarmed alarms should not be escalated, incidents are %+v.", escalator.Incidents())
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+
+	escalator, notifier, history := testEscalator(t)
+	escalator.Handle(firing)
+
+	recorder := httptest.NewRecorder()
+	escalator.Routes().ServeHTTP(recorder, httptest.NewRequest("POST", "/home123/ack", bytes.NewBufferString(`{"by": "Alice"}`)))
+	if recorder.Code != 200 {
+		t.Fatalf("Acknowledging a firing alarm should return 200, not %d.", recorder.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if notified := notifier.notified(); len(notified) != 1 {
+		t.Errorf("Acknowledged alarms should not be escalated, notified %v.", notified)
+	}
+	acknowledgements := history.Recent(10, events.Event{Type: events.Acknowledged})
+	if len(acknowledgements) != 1 || acknowledgements[0].Message != "Alarm acknowledged by Alice." || acknowledgements[0].DeviceID != "home123" {
+		t.Errorf("Acknowledgement should be recorded, events were %+v.", acknowledgements)
+	}
+
+	recorder = httptest.NewRecorder()
+	escalator.Routes().ServeHTTP(recorder, httptest.NewRequest("POST", "/home123/ack", nil))
+	if recorder.Code != 404 {
+		t.Errorf("Acknowledging twice should return 404, not %d.", recorder.Code)
+	}
+}
+
+func TestAcknowledgeByClientIdentity(t *testing.T) {
+
+	escalator, _, history := testEscalator(t)
+	escalator.Handle(firing)
+
+	request := httptest.NewRequest("POST", "/home123/ack", bytes.NewBufferString(`{"by": "Alice"}`))
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alarm-panel"}}}}
+	recorder := httptest.NewRecorder()
+	escalator.Routes().ServeHTTP(recorder, request)
+	if recorder.Code != 200 {
+		t.Fatalf("Acknowledging a firing alarm should return 200, not %d.", recorder.Code)
+	}
+	acknowledgements := history.Recent(10, events.Event{Type: events.Acknowledged})
+	if len(acknowledgements) != 1 || acknowledgements[0].Message != "Alarm acknowledged by alarm-panel." || acknowledgements[0].Actor != "alarm-panel" {
+		t.Errorf("Acknowledgement should be recorded with client identity, events were %+v.", acknowledgements)
+	}
+}
+
+func TestListIncidents(t *testing.T) {
+
+	escalator, _, _ := testEscalator(t)
+	escalator.Handle(firing)
+	defer escalator.Acknowledge("home123", "", "")
+
+	recorder := httptest.NewRecorder()
+	escalator.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"policy":"night","device_id":"home123"`) {
+		t.Errorf("GET should list the night incident, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestAddPolicyInvalid(t *testing.T) {
+
+	escalator := New(events.NewHistory(10), &NotifierMock{})
+	if policyErr := escalator.AddPolicy(Policy{ID: "night", First: []string{"phone"}, Second: []string{"email"}}); policyErr == nil || policyErr.Error() != "Escalation policy 'night' requires a positive escalation delay." {
+		t.Errorf("AddPolicy error should be \"Escalation policy 'night' requires a positive escalation delay.\", error was '%v'.", policyErr)
+	}
+}
@@ -0,0 +1,174 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/logging"
+	chi "github.com/go-chi/chi/v5"
+)
+
+var logger = logging.New("events")
+
+// Event types
+const (
+	ScheduleExecuted = "schedule_executed"
+	ModeChanged      = "mode_changed"
+	FiringStarted    = "firing_started"
+	FiringStopped    = "firing_stopped"
+	DeviceOffline    = "device_offline"
+	DeviceOnline     = "device_online"
+	LowBattery       = "low_battery"
+	BatteryOK        = "battery_ok"
+	MainsLost        = "mains_lost"
+	MainsRestored    = "mains_restored"
+	RuleTriggered    = "rule_triggered"
+	RuleDryRun       = "rule_dry_run"
+	Escalated        = "escalation_notified"
+	Acknowledged     = "alarm_acknowledged"
+)
+
+const DefaultHistorySize = 1000
+
+// Events are dropped for subscribers which fall this far behind.
+const subscriberBuffer = 256
+
+type Event struct {
+	ID       int64             `json:"id"`
+	Time     time.Time         `json:"time"`
+	Type     string            `json:"type"`
+	Source   string            `json:"source"`
+	DeviceID string            `json:"device_id,omitempty"`
+	GroupID  string            `json:"group_id,omitempty"`
+	Success  bool              `json:"success"`
+	Message  string            `json:"msg"`
+	Data     map[string]string `json:"data,omitempty"`
+	// Actor is the client certificate identity of the API request which
+	// caused the event
+	Actor string `json:"actor,omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the identity events caused
+// through it are attributed to.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the identity set by WithActor, it is empty when there
+// is none.
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// History keeps the last recorded events in memory and forwards them to its
+// subscribers.
+type History struct {
+	events      []Event
+	size        int
+	lastID      int64
+	subscribers []chan Event
+	mutex       sync.Mutex
+}
+
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &History{size: size}
+}
+
+// Record assigns ID and time to event and stores it, oldest events are
+// discarded once history is full.
+func (history *History) Record(event Event) Event {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	history.lastID++
+	event.ID = history.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	history.events = append(history.events, event)
+	if len(history.events) > history.size {
+		history.events = history.events[len(history.events)-history.size:]
+	}
+	for _, subscriber := range history.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			logger.Warn("Event subscriber is full, event dropped.", "event", event.ID, "type", event.Type)
+		}
+	}
+	return event
+}
+
+// Subscribe returns a channel which receives every event recorded from now
+// on. Recording never blocks, slow subscribers lose events.
+func (history *History) Subscribe() <-chan Event {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	subscriber := make(chan Event, subscriberBuffer)
+	history.subscribers = append(history.subscribers, subscriber)
+	return subscriber
+}
+
+// Recent returns up to limit events matching filter, newest first. Empty
+// filter fields match any value.
+func (history *History) Recent(limit int, filter Event) []Event {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	recent := []Event{}
+	for index := len(history.events) - 1; index >= 0 && len(recent) < limit; index-- {
+		event := history.events[index]
+		if filter.Type != "" && event.Type != filter.Type {
+			continue
+		}
+		if filter.DeviceID != "" && event.DeviceID != filter.DeviceID {
+			continue
+		}
+		if filter.GroupID != "" && event.GroupID != filter.GroupID {
+			continue
+		}
+		recent = append(recent, event)
+	}
+	return recent
+}
+
+func (history *History) Routes() chi.Router {
+	router := chi.NewRouter()
+	router.Get("/", history.ListEvents)
+	return router
+}
+
+type EventListResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"msg"`
+	Data    []Event `json:"data"`
+}
+
+func (history *History) ListEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var response EventListResponse
+	limit := 50
+	var limitErr error
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		limit, limitErr = strconv.Atoi(limitString)
+	}
+	if limitErr != nil || limit <= 0 {
+		response.Success = false
+		response.Message = "limit must be a positive integer."
+		w.WriteHeader(400)
+	} else {
+		filter := Event{Type: r.URL.Query().Get("type"), DeviceID: r.URL.Query().Get("device_id"), GroupID: r.URL.Query().Get("group_id")}
+		response.Success = true
+		response.Data = history.Recent(limit, filter)
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistoryDiscardsOldestEvents(t *testing.T) {
+
+	history := NewHistory(2)
+	history.Record(Event{Type: ScheduleExecuted, Message: "first"})
+	history.Record(Event{Type: ScheduleExecuted, Message: "second"})
+	third := history.Record(Event{Type: ScheduleExecuted, Message: "third"})
+
+	if third.ID != 3 || third.Time.IsZero() {
+		t.Errorf("Recorded event should get ID 3 and a time, got %+v.", third)
+	}
+	recent := history.Recent(10, Event{})
+	if len(recent) != 2 || recent[0].Message != "third" || recent[1].Message != "second" {
+		t.Errorf("History should keep last two events newest first, kept %+v.", recent)
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+
+	history := NewHistory(10)
+	history.Record(Event{Type: ScheduleExecuted, DeviceID: "home123"})
+	history.Record(Event{Type: ScheduleExecuted, GroupID: "premises"})
+	history.Record(Event{Type: "other", DeviceID: "home123"})
+
+	if recent := history.Recent(10, Event{DeviceID: "home123"}); len(recent) != 2 {
+		t.Errorf("Two events should match device home123, %d matched.", len(recent))
+	}
+	if recent := history.Recent(10, Event{Type: ScheduleExecuted, GroupID: "premises"}); len(recent) != 1 {
+		t.Errorf("One event should match group premises, %d matched.", len(recent))
+	}
+	if recent := history.Recent(1, Event{}); len(recent) != 1 || recent[0].Type != "other" {
+		t.Errorf("Limit should return only the newest event, returned %+v.", recent)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+
+	history := NewHistory(10)
+	history.Record(Event{Type: ScheduleExecuted, DeviceID: "home123", Success: true})
+
+	recorder := httptest.NewRecorder()
+	history.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/?device_id=home123", nil))
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"type":"schedule_executed"`) {
+		t.Errorf("GET events should return recorded event, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	history.Routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/?limit=none", nil))
+	if recorder.Code != 400 {
+		t.Errorf("GET events with invalid limit should return 400, not %d.", recorder.Code)
+	}
+}
@@ -0,0 +1,128 @@
+// Package listeners opens the sockets the API is served on.
+package listeners
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/a-castellano/AlarmManager/systemd"
+)
+
+// Listener types
+const (
+	TCPListener     = "tcp"
+	UnixListener    = "unix"
+	SystemdListener = "systemd"
+)
+
+// Config describes a listener, each type only reads its own settings.
+type Config struct {
+	Type string
+	// Address is the host:port of TCP listeners
+	Address string
+	// Path, Mode, Owner and Group set the socket file of Unix listeners
+	Path  string
+	Mode  os.FileMode
+	Owner string
+	Group string
+}
+
+// Open opens every listener in configs, listeners already opened are
+// closed when one fails.
+func Open(configs []Config) ([]net.Listener, error) {
+	var opened []net.Listener
+	for _, config := range configs {
+		listeners, openErr := open(config)
+		if openErr != nil {
+			for _, listener := range opened {
+				listener.Close()
+			}
+			return nil, openErr
+		}
+		opened = append(opened, listeners...)
+	}
+	return opened, nil
+}
+
+func open(config Config) ([]net.Listener, error) {
+	switch config.Type {
+	case TCPListener:
+		listener, listenErr := net.Listen("tcp", config.Address)
+		if listenErr != nil {
+			return nil, fmt.Errorf("Failed to listen on %s, error was '%w'.", config.Address, listenErr)
+		}
+		return []net.Listener{listener}, nil
+	case UnixListener:
+		listener, listenErr := openUnix(config)
+		if listenErr != nil {
+			return nil, listenErr
+		}
+		return []net.Listener{listener}, nil
+	case SystemdListener:
+		listeners, listenErr := systemd.Listeners()
+		if listenErr != nil {
+			return nil, listenErr
+		}
+		if len(listeners) == 0 {
+			return nil, errors.New("No sockets were passed by systemd.")
+		}
+		return listeners, nil
+	}
+	errorString := fmt.Sprintf("Listener type '%s' is not supported.", config.Type)
+	return nil, errors.New(errorString)
+}
+
+// openUnix creates the socket file, replacing stale sockets left by a
+// previous run. The file is removed when the listener is closed.
+func openUnix(config Config) (net.Listener, error) {
+	if info, statErr := os.Lstat(config.Path); statErr == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			errorString := fmt.Sprintf("Failed to listen on %s, file exists and is not a socket.", config.Path)
+			return nil, errors.New(errorString)
+		}
+		os.Remove(config.Path)
+	}
+	listener, listenErr := net.Listen("unix", config.Path)
+	if listenErr != nil {
+		return nil, fmt.Errorf("Failed to listen on %s, error was '%w'.", config.Path, listenErr)
+	}
+	if permissionsErr := setPermissions(config); permissionsErr != nil {
+		listener.Close()
+		return nil, permissionsErr
+	}
+	return listener, nil
+}
+
+func setPermissions(config Config) error {
+	if config.Mode != 0 {
+		if chmodErr := os.Chmod(config.Path, config.Mode); chmodErr != nil {
+			return fmt.Errorf("Failed to set mode of %s, error was '%w'.", config.Path, chmodErr)
+		}
+	}
+	if config.Owner == "" && config.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if config.Owner != "" {
+		owner, lookupErr := user.Lookup(config.Owner)
+		if lookupErr != nil {
+			return fmt.Errorf("Failed to find owner of %s, error was '%w'.", config.Path, lookupErr)
+		}
+		uid, _ = strconv.Atoi(owner.Uid)
+	}
+	if config.Group != "" {
+		group, lookupErr := user.LookupGroup(config.Group)
+		if lookupErr != nil {
+			return fmt.Errorf("Failed to find group of %s, error was '%w'.", config.Path, lookupErr)
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+	if chownErr := os.Chown(config.Path, uid, gid); chownErr != nil {
+		return fmt.Errorf("Failed to set owner of %s, error was '%w'.", config.Path, chownErr)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package listeners
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	current, _ := user.Current()
+	group, _ := user.LookupGroupId(current.Gid)
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	listeners, openErr := Open([]Config{{Type: TCPListener, Address: "127.0.0.1:0"}, {Type: UnixListener, Path: socketPath, Mode: 0660, Owner: current.Username, Group: group.Name}})
+	if openErr != nil {
+		t.Fatalf("Listeners should be opened, error was '%s'.", openErr)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("Two listeners should be opened, %d were opened.", len(listeners))
+	}
+	if address := listeners[0].Addr().(*net.TCPAddr); !address.IP.IsLoopback() {
+		t.Errorf("TCP listener should be bound to the requested interface, it was bound to %s.", address)
+	}
+	info, statErr := os.Stat(socketPath)
+	if statErr != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Errorf("Socket file should be created with mode 0660, error was '%v'.", statErr)
+	}
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	if _, statErr := os.Stat(socketPath); !os.IsNotExist(statErr) {
+		t.Errorf("Socket file should be removed when listener is closed.")
+	}
+}
+
+func TestOpenStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	stale, _ := net.Listen("unix", socketPath)
+	// Socket file is left behind as if the process had been killed
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, openErr := Open([]Config{{Type: UnixListener, Path: socketPath}})
+	if openErr != nil {
+		t.Fatalf("Stale sockets should be replaced, error was '%s'.", openErr)
+	}
+	listeners[0].Close()
+
+	regularFile := filepath.Join(t.TempDir(), "config.toml")
+	ioutil.WriteFile(regularFile, []byte("[web_server]"), 0600)
+	if _, openErr := Open([]Config{{Type: UnixListener, Path: regularFile}}); openErr == nil {
+		t.Errorf("Files which are not sockets should not be replaced.")
+	}
+}
+
+func TestOpenClosesOnFailure(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	_, openErr := Open([]Config{{Type: UnixListener, Path: socketPath}, {Type: UnixListener, Path: filepath.Join(t.TempDir(), "api2.sock"), Owner: "user-that-does-not-exist"}})
+	if openErr == nil {
+		t.Fatalf("Unknown owners should fail.")
+	}
+	if _, statErr := os.Stat(socketPath); !os.IsNotExist(statErr) {
+		t.Errorf("Opened listeners should be closed when another one fails.")
+	}
+	if _, openErr := Open([]Config{{Type: SystemdListener}}); openErr == nil || openErr.Error() != "No sockets were passed by systemd." {
+		t.Errorf("Systemd listeners should fail without socket activation, error was '%v'.", openErr)
+	}
+}
@@ -0,0 +1,254 @@
+// Package logging writes levelled entries as logfmt or JSON to syslog and
+// stderr. Every subsystem has its own logger and secrets are redacted
+// before entries are written.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var LevelValues = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+type Format int
+
+const (
+	LogfmtFormat Format = iota
+	JSONFormat
+)
+
+var FormatValues = map[Format]string{
+	LogfmtFormat: "logfmt",
+	JSONFormat:   "json",
+}
+
+// ParseLevel returns the level called value.
+func ParseLevel(value string) (Level, error) {
+	for level, name := range LevelValues {
+		if name == value {
+			return level, nil
+		}
+	}
+	errorString := fmt.Sprintf("Log level '%s' is not valid.", value)
+	return InfoLevel, errors.New(errorString)
+}
+
+// ParseFormat returns the format called value.
+func ParseFormat(value string) (Format, error) {
+	for format, name := range FormatValues {
+		if name == value {
+			return format, nil
+		}
+	}
+	errorString := fmt.Sprintf("Log format '%s' is not valid.", value)
+	return LogfmtFormat, errors.New(errorString)
+}
+
+// Config selects which entries are written, how and where.
+type Config struct {
+	Level Level
+	// Levels overrides Level for the subsystems it contains
+	Levels map[string]Level
+	Format Format
+	// Syslog sends entries to the local syslog daemon
+	Syslog bool
+	// Writer receives entries too when it is set, usually os.Stderr
+	Writer io.Writer
+}
+
+var (
+	settings     = Config{Level: InfoLevel, Writer: os.Stderr}
+	syslogWriter *syslog.Writer
+	mutex        sync.Mutex
+	// now is replaced in tests
+	now = time.Now
+)
+
+// Configure replaces the settings of every logger. Entries are still
+// written to Writer when syslog can't be reached.
+func Configure(config Config) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if syslogWriter != nil {
+		syslogWriter.Close()
+		syslogWriter = nil
+	}
+	settings = config
+	if config.Syslog {
+		writer, syslogErr := syslog.New(syslog.LOG_NOTICE, "AlarmManager")
+		if syslogErr != nil {
+			return fmt.Errorf("Failed to connect to syslog, error was '%w'.", syslogErr)
+		}
+		syslogWriter = writer
+	}
+	return nil
+}
+
+// Logger writes entries of a subsystem.
+type Logger struct {
+	subsystem string
+}
+
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// Debug writes message followed by keyvals, which are pairs of keys and
+// values.
+func (logger *Logger) Debug(message string, keyvals ...interface{}) {
+	logger.log(DebugLevel, message, keyvals)
+}
+
+func (logger *Logger) Info(message string, keyvals ...interface{}) {
+	logger.log(InfoLevel, message, keyvals)
+}
+
+func (logger *Logger) Warn(message string, keyvals ...interface{}) {
+	logger.log(WarnLevel, message, keyvals)
+}
+
+func (logger *Logger) Error(message string, keyvals ...interface{}) {
+	logger.log(ErrorLevel, message, keyvals)
+}
+
+// Enabled tells whether entries of level are written, expensive values can
+// be skipped when they are not.
+func (logger *Logger) Enabled(level Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return level >= logger.minLevel()
+}
+
+// minLevel returns the level set for the subsystem, mutex must be held.
+func (logger *Logger) minLevel() Level {
+	if level, ok := settings.Levels[logger.subsystem]; ok {
+		return level
+	}
+	return settings.Level
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+func (logger *Logger) log(level Level, message string, keyvals []interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if level < logger.minLevel() {
+		return
+	}
+	fields := []field{{"level", LevelValues[level]}, {"subsystem", logger.subsystem}, {"msg", Redact(message)}}
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value interface{}
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fields = append(fields, field{key, redactValue(key, value)})
+	}
+	if syslogWriter != nil {
+		writeSyslog(level, encode(settings.Format, fields))
+	}
+	if settings.Writer != nil {
+		line := encode(settings.Format, append([]field{{"time", now().Format(time.RFC3339Nano)}}, fields...))
+		settings.Writer.Write(append(line, '\n'))
+	}
+}
+
+func writeSyslog(level Level, line []byte) {
+	switch level {
+	case DebugLevel:
+		syslogWriter.Debug(string(line))
+	case InfoLevel:
+		syslogWriter.Info(string(line))
+	case WarnLevel:
+		syslogWriter.Warning(string(line))
+	default:
+		syslogWriter.Err(string(line))
+	}
+}
+
+// redactValue hides values of sensitive keys and secrets found in other
+// values. Numbers and booleans are kept as they are.
+func redactValue(key string, value interface{}) interface{} {
+	if sensitiveKey(key) {
+		return redacted
+	}
+	switch typedValue := value.(type) {
+	case nil:
+		return nil
+	case bool, int, int64, uint, uint64, float32, float64:
+		return typedValue
+	case []byte:
+		return Redact(string(typedValue))
+	default:
+		return Redact(fmt.Sprint(typedValue))
+	}
+}
+
+func encode(format Format, fields []field) []byte {
+	var buffer bytes.Buffer
+	if format == JSONFormat {
+		buffer.WriteByte('{')
+		for i, field := range fields {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			key, _ := json.Marshal(field.key)
+			value, _ := json.Marshal(field.value)
+			buffer.Write(key)
+			buffer.WriteByte(':')
+			buffer.Write(value)
+		}
+		buffer.WriteByte('}')
+		return buffer.Bytes()
+	}
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(logfmtValue(field.key))
+		buffer.WriteByte('=')
+		if field.value != nil {
+			buffer.WriteString(logfmtValue(fmt.Sprint(field.value)))
+		}
+	}
+	return buffer.Bytes()
+}
+
+// logfmtValue quotes values which contain spaces, quotes, equal signs or
+// control characters.
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	for _, character := range value {
+		if character <= ' ' || character == '"' || character == '=' || character == 0x7f {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
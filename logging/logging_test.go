@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testOutput(t *testing.T, config Config) *bytes.Buffer {
+	var output bytes.Buffer
+	config.Writer = &output
+	if configureErr := Configure(config); configureErr != nil {
+		t.Fatalf("Configure should not fail, error was '%s'.", configureErr)
+	}
+	now = func() time.Time { return time.Date(2022, 6, 15, 10, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() {
+		Configure(Config{Level: InfoLevel})
+		now = time.Now
+	})
+	return &output
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("warn"); err != nil || level != WarnLevel {
+		t.Errorf("Level warn should be parsed, level was %d and error was '%v'.", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil || err.Error() != "Log level 'verbose' is not valid." {
+		t.Errorf("Unknown level should fail, error was '%v'.", err)
+	}
+	if format, err := ParseFormat("json"); err != nil || format != JSONFormat {
+		t.Errorf("Format json should be parsed, format was %d and error was '%v'.", format, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("Unknown format should fail.")
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	output := testOutput(t, Config{Level: InfoLevel, Format: LogfmtFormat})
+	New("tuya").Info("Changing device mode.", "device", "Home Alarm", "mode", "armed", "attempt", 2, "error", errors.New("device is offline"))
+	expected := `time=2022-06-15T10:00:00Z level=info subsystem=tuya msg="Changing device mode." device="Home Alarm" mode=armed attempt=2 error="device is offline"` + "\n"
+	if output.String() != expected {
+		t.Errorf("Entry should be %q, it was %q.", expected, output.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	output := testOutput(t, Config{Level: InfoLevel, Format: JSONFormat})
+	New("scheduler").Warn("Schedule failed.", "schedule", "night", "attempt", 2)
+	expected := `{"time":"2022-06-15T10:00:00Z","level":"warn","subsystem":"scheduler","msg":"Schedule failed.","schedule":"night","attempt":2}` + "\n"
+	if output.String() != expected {
+		t.Errorf("Entry should be %q, it was %q.", expected, output.String())
+	}
+	var entry map[string]interface{}
+	if json.Unmarshal(output.Bytes(), &entry) != nil {
+		t.Errorf("Entry should be valid JSON.")
+	}
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	output := testOutput(t, Config{Level: WarnLevel, Levels: map[string]Level{"tuya": DebugLevel}})
+	New("tuya").Debug("Response received.")
+	New("rules").Info("Rule triggered.")
+	New("rules").Error("Rule failed.")
+	entries := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(entries) != 2 || !strings.Contains(entries[0], "subsystem=tuya") || !strings.Contains(entries[1], "level=error") {
+		t.Errorf("Only tuya debug entries and errors should be written, entries were %q.", entries)
+	}
+	if !New("tuya").Enabled(DebugLevel) || New("rules").Enabled(InfoLevel) {
+		t.Errorf("Enabled should follow subsystem levels.")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	output := testOutput(t, Config{Level: InfoLevel})
+	response := `{"result":{"access_token":"3f4e5d","expire_time":7200,"refresh_token":"9a8b7c","uid":"bay123"},"success":true}`
+	New("tuya").Info("Token retrieved.", "response", response, "secret", "s3cr3t", "client_secret", 1234)
+	entry := output.String()
+	for _, secret := range []string{"3f4e5d", "9a8b7c", "s3cr3t", "1234"} {
+		if strings.Contains(entry, secret) {
+			t.Errorf("Entry should not contain '%s', it was %q.", secret, entry)
+		}
+	}
+	if !strings.Contains(entry, "bay123") || !strings.Contains(entry, "7200") {
+		t.Errorf("Values which are not secret should be kept, entry was %q.", entry)
+	}
+
+	cases := map[string]string{
+		`{"local_key":"a1b2c3","ip":"1.2.3.4"}`:                  `{"local_key":"[REDACTED]","ip":"1.2.3.4"}`,
+		"GET /v1.0/token?access_token=abc&grant_type=1":          "GET /v1.0/token?access_token=[REDACTED]&grant_type=1",
+		"Authorization: Bearer eyJhbGciOi.x-y":                   "Authorization: Bearer [REDACTED]",
+		"SMS sent to +34 600 123 456 at 10:00:00":                "SMS sent to [REDACTED] at 10:00:00",
+		"Device device123 reports mode 'armed' since 1654000000": "Device device123 reports mode 'armed' since 1654000000",
+	}
+	for text, expected := range cases {
+		if redactedText := Redact(text); redactedText != expected {
+			t.Errorf("Redacted text should be %q, it was %q.", expected, redactedText)
+		}
+	}
+}
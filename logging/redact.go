@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Keys containing any of these words hold secrets.
+var sensitiveKeys = []string{"token", "secret", "password", "local_key", "localkey", "phone", "mobile"}
+
+var (
+	// "access_token":"value" in JSON documents
+	jsonSecret = regexp.MustCompile(`(?i)("[a-z_]*(?:token|secret|password|local_?key|phone|mobile)[a-z_]*"\s*:\s*)("(?:[^"\\]|\\.)*"|[0-9]+)`)
+	// access_token=value in query strings and logfmt
+	pairSecret = regexp.MustCompile(`(?i)\b([a-z_]*(?:token|secret|password|local_?key|phone|mobile)[a-z_]*=)([^&\s"]+)`)
+	// Bearer and access_token headers
+	headerSecret = regexp.MustCompile(`(?i)\b(bearer\s+|access_token:\s*)[a-z0-9._~+/=-]+`)
+	// International phone numbers
+	phoneNumber = regexp.MustCompile(`\+[1-9][0-9 -]{6,16}[0-9]`)
+)
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact hides tokens, secrets, local keys and phone numbers found in text.
+func Redact(text string) string {
+	text = jsonSecret.ReplaceAllString(text, `${1}"`+redacted+`"`)
+	text = pairSecret.ReplaceAllString(text, "${1}"+redacted)
+	text = headerSecret.ReplaceAllString(text, "${1}"+redacted)
+	return phoneNumber.ReplaceAllString(text, redacted)
+}
@@ -1,84 +1,399 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"log/syslog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	api_docs "github.com/a-castellano/AlarmManager/api_docs"
 	config_reader "github.com/a-castellano/AlarmManager/config_reader"
+	"github.com/a-castellano/AlarmManager/dashboard"
 	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/escalation"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/listeners"
+	"github.com/a-castellano/AlarmManager/logging"
+	"github.com/a-castellano/AlarmManager/notifier"
+	"github.com/a-castellano/AlarmManager/rules"
+	"github.com/a-castellano/AlarmManager/scheduler"
+	"github.com/a-castellano/AlarmManager/systemd"
 	"github.com/a-castellano/AlarmManager/tuyadevice"
+	"github.com/a-castellano/AlarmManager/webtls"
 	chi "github.com/go-chi/chi/v5"
 	middleware "github.com/go-chi/chi/v5/middleware"
 )
 
-func updateStatus(deviceManager *device_manager.DeviceManager, client http.Client) {
-	for range time.Tick(time.Second * 20) {
-		log.Println("Updating deviceManager status.")
-		deviceManager.RetrieveInfo(client)
+var (
+	logger    = logging.New("main")
+	apiLogger = logging.New("api")
+	// auditLogger logs requests which may change state
+	auditLogger = logging.New("audit")
+)
+
+// updateStatus polls devices every pollInterval until ctx is done, polling
+// slows down when request budgets run low. Progress is reported to systemd.
+func updateStatus(ctx context.Context, deviceManager *device_manager.DeviceManager, client *http.Client, pollInterval time.Duration, progress *pollerProgress) {
+	for {
+		interval := deviceManager.PollInterval(pollInterval)
+		if interval != pollInterval {
+			logger.Warn("Request budget is running low, slowing down status updates.", "interval", interval)
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		logger.Debug("Updating device status.")
+		progress.begin(time.Now())
+		retrieveErr := deviceManager.RetrieveInfo(ctx, client)
+		progress.end(deviceManager.Health(), retrieveErr)
+	}
+}
+
+// logRequests logs every API request once it has been served with the
+// identity of its client certificate, requests which may change state are
+// logged by the audit logger.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(wrapped, r)
+		status := wrapped.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requestLogger := apiLogger
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			requestLogger = auditLogger
+		}
+		requestLogger.Info("Request served.", "method", r.Method, "uri", r.RequestURI, "status", status, "bytes", wrapped.BytesWritten(), "duration", time.Since(start), "remote", r.RemoteAddr, "identity", webtls.Identity(r))
+	})
+}
+
+// requestTimeout cancels requests which take longer than timeout, status
+// long-polls get their wait on top of it.
+func requestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := timeout
+			if wait, waitErr := device_manager.ParseWait(r); waitErr == nil {
+				limit += wait
+			}
+			middleware.Timeout(limit)(next).ServeHTTP(w, r)
+		})
 	}
 }
 
+// apiServices groups every subsystem exposed through the API.
+type apiServices struct {
+	deviceManager *device_manager.DeviceManager
+	history       *events.History
+	scheduler     *scheduler.Scheduler
+	escalator     *escalation.Escalator
+	// pollInterval is the time between status updates with enough budget
+	pollInterval time.Duration
+	// authorizer checks client certificates, every client is allowed when
+	// nil
+	authorizer *webtls.Authorizer
+	// pin is required to change state when it is not empty
+	pin string
+}
+
+func newRouter(version string, services apiServices) *chi.Mux {
+	apiRouter := chi.NewRouter()
+	apiRouter.Use(logRequests)
+	if services.authorizer != nil {
+		apiRouter.Use(services.authorizer.Middleware)
+	}
+	if services.pin != "" {
+		apiRouter.Use(requirePIN(services.pin))
+	}
+	apiRouter.Group(func(router chi.Router) {
+		router.Use(requestTimeout(10 * time.Second))
+		router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"success": true, "msg": "Service up"}`))
+		})
+		router.Get("/version", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			jsonResponde := fmt.Sprintf("{\"success\": true, \"version\": \"%s\"}", version)
+			w.Write([]byte(jsonResponde))
+		})
+		router.Get("/whoami", showClient(services.authorizer, services.pin))
+		router.Get("/openapi.json", api_docs.ShowSpec)
+		router.Get("/docs", api_docs.RedirectUI)
+		router.Get("/docs/*", api_docs.UI())
+		router.Get("/dashboard", dashboard.Redirect)
+		router.Get("/dashboard/*", dashboard.Handler().ServeHTTP)
+		router.Mount("/devices", services.deviceManager.Routes())
+		router.Mount("/jobs", services.deviceManager.Jobs.Routes())
+		router.Mount("/schedules", services.scheduler.Routes())
+		router.Mount("/events", services.history.Routes())
+		router.Mount("/escalations", services.escalator.Routes())
+		if services.deviceManager.HasSimulatedDevices() {
+			router.Mount("/simulator", services.deviceManager.SimulatorRoutes())
+		}
+		router.Mount("/admin/budgets", device_manager.BudgetRoutes())
+		router.Get("/metrics", showMetrics(services.deviceManager, services.pollInterval))
+	})
+	// group mode changes wait for every member to confirm its new mode
+	apiRouter.With(requestTimeout(10*time.Second+services.deviceManager.GroupChangeTimeout())).Mount("/groups", services.deviceManager.GroupRoutes())
+	return apiRouter
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// run serves the API until SIGINT or SIGTERM is received, then shuts down
+// in order and returns the exit code.
+func run() int {
 
 	var version string = "0.2"
 
-	client := http.Client{
+	// client is shared by every request sent to Tuya cloud
+	client := &http.Client{
 		Timeout: time.Second * 5, // Maximum of 5 secs
 	}
-
-	logwriter, e := syslog.New(syslog.LOG_NOTICE, "AlarmManager")
-	if e == nil {
-		log.SetOutput(logwriter)
-		// Remove date prefix
-		log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
-	}
+	// ctx is done when a shutdown signal is received
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	config, errConfig := config_reader.ReadConfig()
 	if errConfig != nil {
-		log.Fatal(errConfig)
-		return
+		logger.Error("Failed to read config.", "error", errConfig)
+		return 1
+	}
+	loggingConfig := logging.Config{Level: config.Logging.Level, Levels: config.Logging.Levels, Format: config.Logging.Format, Syslog: config.Logging.Syslog}
+	if config.Logging.Stderr {
+		loggingConfig.Writer = os.Stderr
+	}
+	if loggingErr := logging.Configure(loggingConfig); loggingErr != nil {
+		// Entries are not lost when syslog is not available
+		loggingConfig.Syslog = false
+		loggingConfig.Writer = os.Stderr
+		logging.Configure(loggingConfig)
+		logger.Warn("Syslog is not available, logging to stderr.", "error", loggingErr)
 	}
 
-	log.Println("Initiating Device Manager.")
-	deviceManager := device_manager.DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), AlarmsInfo: make(map[string]device_manager.Alarm)}
+	logger.Info("Initiating device manager.")
+	deviceManager := device_manager.DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device), ConfirmationTimeout: config.ConfirmationTimeout, ConfirmationInterval: config.ConfirmationInterval, OfflineDebounce: config.OfflineDebounce, Client: client}
 	for _, deviceConfig := range config.Devices {
-		device := device_manager.CreateTuyaDeviceFromConfig(deviceConfig)
-		deviceRef := &device
-		addDeviceError := deviceManager.AddDevice(deviceRef)
+		device := device_manager.CreateDeviceFromConfig(deviceConfig)
+		addDeviceError := deviceManager.AddDevice(device)
 		if addDeviceError != nil {
-			log.Fatal(addDeviceError)
+			logger.Error("Failed to add device.", "error", addDeviceError)
+			return 1
 		}
 	}
-	log.Println("Collecting initial tokens from all devices")
-	deviceManager.Start(client)
-	log.Println("Obtaining info from all devices")
-	deviceManager.RetrieveInfo(client)
-	//	fmt.Println(deviceManager.AlarmsInfo)
-	//	fmt.Println(deviceManager.AlarmsInfo)
-	//changeModeErr := deviceManager.ChangeMode(client, "Home Alarm", "Disarmed")
-	//	if changeModeErr != nil {
-	//		log.Fatal(changeModeErr)
-	//	}
-
-	log.Println("Starting API")
-	apiRouter := chi.NewRouter()
-	apiRouter.Use(middleware.Logger)
-	apiRouter.Use(middleware.Timeout(10 * time.Second))
-	apiRouter.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"success": true, "msg": "Service up"}`))
-	})
-	apiRouter.Get("/version", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		jsonResponde := fmt.Sprintf("{\"success\": true, \"version\": \"%s\"}", version)
-		w.Write([]byte(jsonResponde))
-	})
-	apiRouter.Mount("/devices", deviceManager.Routes())
+	for groupID, groupConfig := range config.Groups {
+		addGroupError := deviceManager.AddGroup(groupID, device_manager.Group{Name: groupConfig.Name, DeviceIDs: groupConfig.DeviceIDs})
+		if addGroupError != nil {
+			logger.Error("Failed to add group.", "error", addGroupError)
+			return 1
+		}
+	}
+	for clientID, budgetConfig := range config.Budgets {
+		tuyadevice.BudgetFor(clientID).SetLimits(budgetConfig.RequestsPerSecond, budgetConfig.DailyLimit, budgetConfig.MonthlyLimit)
+	}
+	history := events.NewHistory(events.DefaultHistorySize)
+	deviceManager.History = history
+	// Subscriptions are created before devices are first polled, so alarms
+	// already firing at startup are notified, escalated and matched by rules
+	notifierEvents := history.Subscribe()
+	escalatorEvents := history.Subscribe()
+	rulesEvents := history.Subscribe()
+	// progress tells systemd when service is ready and alive
+	progress := newPollerProgress()
+	logger.Info("Collecting initial tokens from all devices.")
+	startErr := deviceManager.Start(ctx, client)
+	if startErr == nil {
+		logger.Info("Obtaining info from all devices.")
+		startErr = deviceManager.RetrieveInfo(ctx, client)
+	}
+	if startErr != nil {
+		logger.Warn("Devices could not be reached, service will be ready after next status update.", "error", startErr)
+	}
+	// Jobs are not bound to ctx, pending mode changes are drained on shutdown
+	deviceManager.Jobs = device_manager.NewJobManager(context.Background(), &deviceManager, client)
 
-	go updateStatus(&deviceManager, client)
-	listenString := fmt.Sprintf(":%d", config.WebPort)
-	http.ListenAndServe(listenString, apiRouter)
+	alarmScheduler, schedulerErr := scheduler.New(&deviceManager, client, history, config.Holidays)
+	if schedulerErr != nil {
+		logger.Error("Failed to create scheduler.", "error", schedulerErr)
+		return 1
+	}
+	for scheduleID, scheduleConfig := range config.Schedules {
+		schedule := scheduler.Schedule{ID: scheduleID, Cron: scheduleConfig.Cron, TimeZone: scheduleConfig.TimeZone, DeviceID: scheduleConfig.DeviceID, GroupID: scheduleConfig.GroupID, Mode: scheduleConfig.Mode, Rollback: scheduleConfig.Rollback, Holidays: scheduleConfig.Holidays}
+		if _, addScheduleErr := alarmScheduler.AddSchedule(schedule); addScheduleErr != nil {
+			logger.Error("Failed to add schedule.", "error", addScheduleErr)
+			return 1
+		}
+	}
+
+	deviceNames := make(map[string]string)
+	for deviceName, deviceConfig := range config.Devices {
+		deviceNames[deviceConfig.DeviceID] = deviceName
+	}
+	// sendCtx cancels notifications still being sent when shutdown timeout
+	// passes
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	defer cancelSends()
+	alarmNotifier := notifier.New(sendCtx, history, deviceNames)
+	for channelName, channelConfig := range config.NotificationChannels {
+		channel, channelErr := notifier.NewChannel(client, notifier.ChannelConfig(channelConfig))
+		if channelErr != nil {
+			logger.Error("Failed to create notification channel.", "error", channelErr)
+			return 1
+		}
+		alarmNotifier.AddChannel(channelName, channel)
+	}
+	for _, routeConfig := range config.NotificationRoutes {
+		route := notifier.Route{EventTypes: routeConfig.EventTypes, DeviceIDs: routeConfig.DeviceIDs, Channels: routeConfig.Channels, Title: routeConfig.Title, Template: routeConfig.Template}
+		if addRouteErr := alarmNotifier.AddRoute(route); addRouteErr != nil {
+			logger.Error("Failed to add notification route.", "error", addRouteErr)
+			return 1
+		}
+	}
+
+	escalator := escalation.New(history, alarmNotifier)
+	for policyID, escalationConfig := range config.Escalations {
+		policy := escalation.Policy{ID: policyID, DeviceIDs: escalationConfig.DeviceIDs, First: escalationConfig.First, Second: escalationConfig.Second, EscalateAfter: escalationConfig.EscalateAfter, RepeatEvery: escalationConfig.RepeatEvery}
+		if addPolicyErr := escalator.AddPolicy(policy); addPolicyErr != nil {
+			logger.Error("Failed to add escalation policy.", "error", addPolicyErr)
+			return 1
+		}
+	}
+
+	rulesEngine := rules.New(&deviceManager, client, history)
+	rulesEngine.Notifier = alarmNotifier
+	for ruleID, ruleConfig := range config.Rules {
+		rule := rules.Rule{ID: ruleID, Trigger: ruleConfig.Trigger, DeviceID: ruleConfig.DeviceID, For: ruleConfig.For, TimeWindow: ruleConfig.TimeWindow, TimeZone: ruleConfig.TimeZone, Mode: ruleConfig.Mode, ModeDeviceID: ruleConfig.ModeDeviceID, DryRun: ruleConfig.DryRun}
+		for _, actionConfig := range ruleConfig.Actions {
+			rule.Actions = append(rule.Actions, rules.Action{Type: actionConfig.Type, DeviceID: actionConfig.DeviceID, GroupID: actionConfig.GroupID, Mode: actionConfig.Mode, URL: actionConfig.URL, Message: actionConfig.Message})
+		}
+		if addRuleErr := rulesEngine.AddRule(rule); addRuleErr != nil {
+			logger.Error("Failed to add rule.", "error", addRuleErr)
+			return 1
+		}
+	}
+
+	logger.Info("Starting API.")
+	services := apiServices{deviceManager: &deviceManager, history: history, scheduler: alarmScheduler, escalator: escalator, pollInterval: config.PollInterval, pin: config.WebPIN}
+	if config.TLS.ClientCAFile != "" {
+		services.authorizer = &webtls.Authorizer{Permissions: config.TLS.Identities}
+	}
+	apiRouter := newRouter(version, services)
+
+	server := &http.Server{Handler: apiRouter}
+	if config.TLS.CertFile != "" {
+		tlsConfig, tlsErr := webtls.NewConfig(webtls.Options{CertFile: config.TLS.CertFile, KeyFile: config.TLS.KeyFile, MinVersion: config.TLS.MinVersion, ClientCAFile: config.TLS.ClientCAFile})
+		if tlsErr != nil {
+			logger.Error("Failed to configure TLS.", "error", tlsErr)
+			return 1
+		}
+		server.TLSConfig = tlsConfig
+	}
+	var listenerConfigs []listeners.Config
+	for _, listenerConfig := range config.Listeners {
+		listenerConfigs = append(listenerConfigs, listeners.Config(listenerConfig))
+	}
+	apiListeners, listenErr := listeners.Open(listenerConfigs)
+	if listenErr != nil {
+		logger.Error("Failed to listen.", "error", listenErr)
+		return 1
+	}
+	// Service is ready once API accepts connections
+	progress.end(deviceManager.Health(), startErr)
+
+	// notifyCtx outlives every other subsystem so their last events are
+	// notified too
+	notifyCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierDone := make(chan struct{})
+	go func() {
+		alarmNotifier.Run(notifyCtx, notifierEvents)
+		close(notifierDone)
+	}()
+	var workers sync.WaitGroup
+	if watchdogTimeout := systemd.WatchdogInterval(); watchdogTimeout > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			runWatchdog(ctx, progress, watchdogTimeout)
+		}()
+	}
+	for _, worker := range []func(context.Context){
+		func(ctx context.Context) { updateStatus(ctx, &deviceManager, client, config.PollInterval, progress) },
+		alarmScheduler.Run,
+		func(ctx context.Context) { rulesEngine.Run(ctx, rulesEvents) },
+		func(ctx context.Context) { escalator.Run(ctx, escalatorEvents) },
+	} {
+		workers.Add(1)
+		go func(worker func(context.Context)) {
+			defer workers.Done()
+			worker(ctx)
+		}(worker)
+	}
+
+	serverErr := make(chan error, len(apiListeners))
+	for _, listener := range apiListeners {
+		logger.Info("Serving API.", "address", listener.Addr().Network()+":"+listener.Addr().String())
+		go func(listener net.Listener) {
+			if server.TLSConfig != nil {
+				serverErr <- server.ServeTLS(listener, "", "")
+				return
+			}
+			serverErr <- server.Serve(listener)
+		}(listener)
+	}
+
+	exitCode := 0
+	select {
+	case serveErr := <-serverErr:
+		logger.Error("API stopped.", "error", serveErr)
+		exitCode = 1
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received, stopping API.")
+	}
+	progress.send(systemd.Stopping)
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.Error("API requests were not finished in time.", "error", shutdownErr)
+		server.Close()
+		exitCode = 1
+	}
+	logger.Info("Waiting for pending mode changes.")
+	if jobsErr := deviceManager.Jobs.Shutdown(shutdownCtx); jobsErr != nil {
+		logger.Error("Pending mode changes were not finished in time.", "error", jobsErr)
+		exitCode = 1
+	}
+	workers.Wait()
+	stopNotifier()
+	select {
+	case <-notifierDone:
+	case <-shutdownCtx.Done():
+		logger.Error("Pending notifications were not sent in time.")
+		cancelSends()
+		<-notifierDone
+		exitCode = 1
+	}
+	// There is no persisted state to flush: schedules created through the
+	// API, escalations, jobs and budget usage only live in memory. Budget
+	// usage is logged so it can be set again through /admin/budgets.
+	logger.Info("No state is persisted, runtime schedules, escalations and jobs are discarded.")
+	for _, usage := range tuyadevice.BudgetUsages() {
+		logger.Info("Request budget usage.", "client_id", usage.ClientID, "daily_used", usage.DailyUsed, "monthly_used", usage.MonthlyUsed)
+	}
+	logger.Info("Shutdown completed.")
+	return exitCode
 }
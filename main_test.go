@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	api_docs "github.com/a-castellano/AlarmManager/api_docs"
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/escalation"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/notifier"
+	"github.com/a-castellano/AlarmManager/scheduler"
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+	"github.com/a-castellano/AlarmManager/tuyadevice/tuyatest"
+	"github.com/a-castellano/AlarmManager/webtls"
+	chi "github.com/go-chi/chi/v5"
+)
+
+// Routes serving the documentation itself are not part of the spec.
+var undocumentedRoutes = map[string]bool{
+	"GET /openapi.json": true,
+	"GET /docs":         true,
+	"GET /docs/*":       true,
+	"GET /dashboard":    true,
+	"GET /dashboard/*":  true,
+}
+
+type openAPISpec struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func testServices() apiServices {
+	deviceManager := device_manager.DeviceManager{DevicesInfo: make(map[string]tuyadevice.Device)}
+	deviceManager.Jobs = device_manager.NewJobManager(context.Background(), &deviceManager, &http.Client{})
+	history := events.NewHistory(events.DefaultHistorySize)
+	alarmScheduler, _ := scheduler.New(&deviceManager, &http.Client{}, history, nil)
+	escalator := escalation.New(history, notifier.New(context.Background(), history, nil))
+	return apiServices{deviceManager: &deviceManager, history: history, scheduler: alarmScheduler, escalator: escalator}
+}
+
+func testRouter() *chi.Mux {
+	return newRouter("test", testServices())
+}
+
+func routerRoutes(t *testing.T, router chi.Routes) []string {
+	var routes []string
+	walkErr := chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		route = strings.Replace(route, "/*/", "/", -1)
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		routeString := method + " " + route
+		if !undocumentedRoutes[routeString] {
+			routes = append(routes, routeString)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		t.Fatalf("Walking router should not fail, error was '%s'.", walkErr)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func specRoutes(t *testing.T) []string {
+	var spec openAPISpec
+	if unmarshalErr := json.Unmarshal(api_docs.Spec(), &spec); unmarshalErr != nil {
+		t.Fatalf("OpenAPI spec should be valid JSON, error was '%s'.", unmarshalErr)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("OpenAPI spec version should be 3.x, not '%s'.", spec.OpenAPI)
+	}
+	var routes []string
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func TestSpecMatchesRoutes(t *testing.T) {
+	services := testServices()
+	// Simulator routes are only served when there are simulated devices
+	services.deviceManager.AddDevice(tuyadevice.NewSimulatedDevice("simulated1", "simulated1", "arm", nil))
+	routes := routerRoutes(t, newRouter("test", services))
+	documented := specRoutes(t)
+
+	if strings.Join(routes, "\n") != strings.Join(documented, "\n") {
+		t.Errorf("OpenAPI spec and router are out of sync.\nRouter:\n%s\nSpec:\n%s", strings.Join(routes, "\n"), strings.Join(documented, "\n"))
+	}
+}
+
+func TestServeSpec(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	if recorder.Code != 200 {
+		t.Errorf("GET /openapi.json should return 200, not %d.", recorder.Code)
+	}
+	if recorder.Body.String() != string(api_docs.Spec()) {
+		t.Errorf("GET /openapi.json should return the embedded spec.")
+	}
+}
+
+func TestServeDocs(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/docs", nil))
+
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("GET /docs should redirect, returned %d.", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/docs/index.html", nil))
+
+	if recorder.Code != 200 {
+		t.Errorf("GET /docs/index.html should return 200, not %d.", recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), "/openapi.json") {
+		t.Errorf("Swagger UI should load /openapi.json.")
+	}
+}
+
+func TestRouterWithoutSimulatedDevices(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/simulator/", nil))
+
+	if recorder.Code != 404 {
+		t.Errorf("GET /simulator/ should return 404 without simulated devices, not %d.", recorder.Code)
+	}
+}
+
+func TestServeDashboard(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/dashboard", nil))
+
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("GET /dashboard should redirect, returned %d.", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/dashboard/", nil))
+
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), "dashboard.js") {
+		t.Errorf("GET /dashboard/ should return the dashboard page, response was %d.", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/dashboard/dashboard.js", nil))
+
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), "/devices/status/") {
+		t.Errorf("Dashboard script should read device status from the API, response was %d.", recorder.Code)
+	}
+}
+
+const shutdownConfig = `[web_server]
+port = %d
+
+[tuya_devices]
+[tuya_devices.test_alarm]
+name = "Test Alarm"
+type = "simulated"
+device_id = "simulated1"
+mode = "arm"
+latency = "300ms"
+
+[mode_change]
+confirmation_interval = "50ms"
+
+[polling]
+interval = "10s"
+`
+
+func freePort(t *testing.T) int {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatalf("A free port should be found, error was '%s'.", listenErr)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestShutdownOnSignal runs main in a child process and sends SIGTERM
+// while a mode change is being sent.
+func TestShutdownOnSignal(t *testing.T) {
+	if os.Getenv("ALARM_MANAGER_RUN_MAIN") == "1" {
+		main()
+		return
+	}
+
+	port := freePort(t)
+	configDir := t.TempDir()
+	if writeErr := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(fmt.Sprintf(shutdownConfig, port)), 0600); writeErr != nil {
+		t.Fatalf("Config should be written, error was '%s'.", writeErr)
+	}
+
+	notifySocket, notifyErr := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(configDir, "notify.sock"), Net: "unixgram"})
+	if notifyErr != nil {
+		t.Fatalf("Notify socket should be created, error was '%s'.", notifyErr)
+	}
+	defer notifySocket.Close()
+	notifications := make(chan string, 10)
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			read, readErr := notifySocket.Read(buffer)
+			if readErr != nil {
+				close(notifications)
+				return
+			}
+			notifications <- string(buffer[:read])
+		}
+	}()
+
+	command := exec.Command(os.Args[0], "-test.run=^TestShutdownOnSignal$")
+	command.Env = append(os.Environ(), "ALARM_MANAGER_RUN_MAIN=1", "ALARM_MANAGER_CONFIG_FILE_LOCATION="+configDir+"/", "NOTIFY_SOCKET="+filepath.Join(configDir, "notify.sock"))
+	var output bytes.Buffer
+	command.Stderr = &output
+	if startErr := command.Start(); startErr != nil {
+		t.Fatalf("Service should start, error was '%s'.", startErr)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- command.Wait()
+	}()
+	defer command.Process.Kill()
+
+	select {
+	case state := <-notifications:
+		if !strings.HasPrefix(state, "READY=1\nSTATUS=1 devices, 1 online") {
+			t.Fatalf("Service should notify systemd it is ready, state was %q.", state)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Service should notify systemd it is ready.")
+	}
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	client := &http.Client{Timeout: 5 * time.Second}
+	if response, getErr := client.Get(url + "/"); getErr != nil {
+		t.Fatalf("Service should serve requests once ready, error was '%s'.", getErr)
+	} else {
+		response.Body.Close()
+	}
+
+	changed := make(chan int, 1)
+	go func() {
+		request, _ := http.NewRequest("PUT", url+"/devices/status/simulated1", strings.NewReader(`{"mode": "Disarmed"}`))
+		response, putErr := client.Do(request)
+		if putErr != nil {
+			changed <- 0
+			return
+		}
+		response.Body.Close()
+		changed <- response.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if signalErr := command.Process.Signal(syscall.SIGTERM); signalErr != nil {
+		t.Fatalf("SIGTERM should be sent, error was '%s'.", signalErr)
+	}
+
+	if code := <-changed; code != 200 {
+		t.Errorf("Mode change in progress should finish with 200 after SIGTERM, it returned %d.", code)
+	}
+	select {
+	case waitErr := <-exited:
+		if waitErr != nil {
+			t.Errorf("Service should exit with code 0, error was '%s'.", waitErr)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Service should exit after SIGTERM.")
+	}
+	if response, getErr := client.Get(url + "/"); getErr == nil {
+		response.Body.Close()
+		t.Errorf("Service should not accept requests after shutdown.")
+	}
+	stopping := false
+	for len(notifications) > 0 {
+		if <-notifications == "STOPPING=1" {
+			stopping = true
+		}
+	}
+	if !stopping {
+		t.Errorf("Service should notify systemd it is stopping.")
+	}
+	if !strings.Contains(output.String(), "No state is persisted") {
+		t.Errorf("Service should log that runtime state is discarded, output was '%s'.", output.String())
+	}
+}
+
+const firingConfig = `[web_server]
+port = %d
+
+[tuya_devices]
+[tuya_devices.home_alarm]
+name = "Home Alarm"
+type = "99AST"
+host = "%s"
+client_id = "client123"
+secret = "secret123"
+device_id = "device123"
+
+[notifications.channels.log]
+type = "http"
+url = "%s/route"
+
+[notifications.channels.phone]
+type = "http"
+url = "%s/escalation"
+
+[[notifications.routes]]
+events = ["firing_started"]
+channels = ["log"]
+
+[escalations.intrusion]
+first = ["phone"]
+
+[polling]
+interval = "10s"
+`
+
+// TestStartupFiringIsEscalated runs main in a child process against a fake
+// cloud whose alarm is already firing when the service starts.
+func TestStartupFiringIsEscalated(t *testing.T) {
+	if os.Getenv("ALARM_MANAGER_RUN_MAIN") == "1" {
+		main()
+		return
+	}
+
+	cloud := tuyatest.NewServer("client123", "secret123")
+	defer cloud.Close()
+	cloud.AddDevice(tuyatest.AlarmDevice("device123", "Home Alarm", "arm"))
+	cloud.SetStatus("device123", "master_state", "alarm")
+	notified := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification notifier.Notification
+		json.NewDecoder(r.Body).Decode(&notification)
+		notified <- r.URL.Path + " " + notification.Event.Type
+	}))
+	defer receiver.Close()
+	configDir := t.TempDir()
+	if writeErr := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(fmt.Sprintf(firingConfig, freePort(t), cloud.URL, receiver.URL, receiver.URL)), 0600); writeErr != nil {
+		t.Fatalf("Config should be written, error was '%s'.", writeErr)
+	}
+
+	command := exec.Command(os.Args[0], "-test.run=^TestStartupFiringIsEscalated$")
+	command.Env = append(os.Environ(), "ALARM_MANAGER_RUN_MAIN=1", "ALARM_MANAGER_CONFIG_FILE_LOCATION="+configDir+"/")
+	if startErr := command.Start(); startErr != nil {
+		t.Fatalf("Service should start, error was '%s'.", startErr)
+	}
+	defer command.Wait()
+	defer command.Process.Signal(syscall.SIGTERM)
+
+	expected := map[string]bool{"/route firing_started": true, "/escalation firing_started": true}
+	timeout := time.After(10 * time.Second)
+	for len(expected) > 0 {
+		select {
+		case notification := <-notified:
+			delete(expected, notification)
+		case <-timeout:
+			t.Fatalf("Alarm firing at startup should be notified and escalated, missing %v.", expected)
+		}
+	}
+}
+
+func TestRouterAuthorizesClients(t *testing.T) {
+	services := testServices()
+	services.authorizer = &webtls.Authorizer{Permissions: map[string]string{"kitchen-panel": webtls.ReadPermission}}
+	router := newRouter("test", services)
+
+	cases := []struct {
+		method      string
+		path        string
+		identity    string
+		expected    int
+		description string
+	}{
+		{"GET", "/version", "", 401, "Requests without client certificate should be refused"},
+		{"GET", "/version", "kitchen-panel", 200, "Read identities should send GET requests"},
+		{"GET", "/dashboard/", "", 401, "Dashboard should require a client certificate"},
+		{"PUT", "/devices/status/home123", "kitchen-panel", 403, "Read identities should not change devices"},
+	}
+	for _, testCase := range cases {
+		request := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader(`{"mode": "Armed"}`))
+		if testCase.identity != "" {
+			request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: testCase.identity}}}}
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.expected {
+			t.Errorf("%s, status was %d.", testCase.description, recorder.Code)
+		}
+	}
+}
+
+// TestRouterDuringPolling serves the API while status updates run, run with
+// -race to detect unsynchronized state.
+func TestRouterDuringPolling(t *testing.T) {
+	services := testServices()
+	for _, deviceID := range []string{"simulated1", "simulated2"} {
+		services.deviceManager.AddDevice(tuyadevice.NewSimulatedDevice(deviceID, deviceID, "arm", []string{"Hall"}))
+	}
+	router := newRouter("test", services)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		updateStatus(ctx, services.deviceManager, &http.Client{}, time.Millisecond, newPollerProgress())
+	}()
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/devices/", ""},
+		{"GET", "/devices/status/simulated1", ""},
+		{"GET", "/devices/offline", ""},
+		{"GET", "/events/", ""},
+		{"GET", "/metrics", ""},
+		{"PUT", "/devices/status/simulated2", `{"mode": "HomeArmed"}`},
+		{"PUT", "/devices/status/simulated2", `{"mode": "Disarmed"}`},
+		{"POST", "/simulator/simulated1/intrusion", ""},
+	}
+	finished := make(chan struct{})
+	for _, testRequest := range requests {
+		go func(method string, path string, body string) {
+			defer func() { finished <- struct{}{} }()
+			for ctx.Err() == nil {
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+				if recorder.Code >= 500 && recorder.Code != 503 {
+					t.Errorf("%s %s should not fail, response was %d %s.", method, path, recorder.Code, recorder.Body.String())
+				}
+			}
+		}(testRequest.method, testRequest.path, testRequest.body)
+	}
+	for range requests {
+		<-finished
+	}
+	<-done
+	if health := services.deviceManager.Health(); health.Online != 2 {
+		t.Errorf("Every device should be polled, health was '%s'.", health)
+	}
+}
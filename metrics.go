@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+// showMetrics serves Tuya cloud usage in Prometheus text format.
+func showMetrics(deviceManager *device_manager.DeviceManager, pollInterval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		var metrics strings.Builder
+		usages := tuyadevice.BudgetUsages()
+
+		metrics.WriteString("# HELP alarmmanager_tuya_requests Requests sent to Tuya cloud in current period.\n")
+		metrics.WriteString("# TYPE alarmmanager_tuya_requests gauge\n")
+		for _, usage := range usages {
+			fmt.Fprintf(&metrics, "alarmmanager_tuya_requests{client_id=%q,period=\"day\"} %d\n", usage.ClientID, usage.DailyUsed)
+			fmt.Fprintf(&metrics, "alarmmanager_tuya_requests{client_id=%q,period=\"month\"} %d\n", usage.ClientID, usage.MonthlyUsed)
+		}
+		metrics.WriteString("# HELP alarmmanager_tuya_request_limit Requests allowed in current period, 0 when there is no limit.\n")
+		metrics.WriteString("# TYPE alarmmanager_tuya_request_limit gauge\n")
+		for _, usage := range usages {
+			fmt.Fprintf(&metrics, "alarmmanager_tuya_request_limit{client_id=%q,period=\"day\"} %d\n", usage.ClientID, usage.DailyLimit)
+			fmt.Fprintf(&metrics, "alarmmanager_tuya_request_limit{client_id=%q,period=\"month\"} %d\n", usage.ClientID, usage.MonthlyLimit)
+		}
+		metrics.WriteString("# HELP alarmmanager_tuya_budget_remaining Fraction left of the most used request limit.\n")
+		metrics.WriteString("# TYPE alarmmanager_tuya_budget_remaining gauge\n")
+		for _, usage := range usages {
+			fmt.Fprintf(&metrics, "alarmmanager_tuya_budget_remaining{client_id=%q} %g\n", usage.ClientID, usage.Remaining)
+		}
+		metrics.WriteString("# HELP alarmmanager_poll_interval_seconds Time between device status updates.\n")
+		metrics.WriteString("# TYPE alarmmanager_poll_interval_seconds gauge\n")
+		fmt.Fprintf(&metrics, "alarmmanager_poll_interval_seconds %g\n", deviceManager.PollInterval(pollInterval).Seconds())
+		w.Write([]byte(metrics.String()))
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func TestServeMetrics(t *testing.T) {
+	budget := tuyadevice.BudgetFor("metrics-client")
+	budget.SetLimits(1, 500, 0)
+	budget.SetUsage(125, 300)
+
+	recorder := httptest.NewRecorder()
+	testRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("GET /metrics should return 200, not %d.", recorder.Code)
+	}
+	for _, metric := range []string{`alarmmanager_tuya_requests{client_id="metrics-client",period="day"} 125`, `alarmmanager_tuya_requests{client_id="metrics-client",period="month"} 300`, `alarmmanager_tuya_request_limit{client_id="metrics-client",period="day"} 500`, `alarmmanager_tuya_budget_remaining{client_id="metrics-client"} 0.75`} {
+		if !strings.Contains(recorder.Body.String(), metric) {
+			t.Errorf("Metrics should contain '%s', they were:\n%s", metric, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Channel types
+const (
+	ChannelEmail  = "email"
+	ChannelNtfy   = "ntfy"
+	ChannelGotify = "gotify"
+	ChannelHTTP   = "http"
+)
+
+// Channel delivers notifications to people, requests are cancelled when ctx
+// is done.
+type Channel interface {
+	Send(ctx context.Context, notification Notification) error
+}
+
+// ChannelConfig holds settings of every channel type, each type only reads
+// its own ones.
+type ChannelConfig struct {
+	Type        string
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	To          []string
+	URL         string
+	Token       string
+	Priority    int
+	Template    string
+	ContentType string
+}
+
+// NewChannel creates a channel of config.Type, HTTP based channels use client.
+func NewChannel(client *http.Client, config ChannelConfig) (Channel, error) {
+	switch config.Type {
+	case ChannelEmail:
+		if config.Host == "" || config.From == "" || len(config.To) == 0 {
+			return nil, errors.New("Email channels require host, from and to.")
+		}
+		port := config.Port
+		if port == 0 {
+			port = 25
+		}
+		return &EmailChannel{Address: fmt.Sprintf("%s:%d", config.Host, port), Host: config.Host, Username: config.Username, Password: config.Password, From: config.From, To: config.To}, nil
+	case ChannelNtfy, ChannelGotify:
+		if config.URL == "" {
+			errorString := fmt.Sprintf("%s channels require url.", config.Type)
+			return nil, errors.New(errorString)
+		}
+		return &PushChannel{Style: config.Type, URL: config.URL, Token: config.Token, Priority: config.Priority, Client: client}, nil
+	case ChannelHTTP:
+		if config.URL == "" {
+			return nil, errors.New("http channels require url.")
+		}
+		channel := &HTTPChannel{URL: config.URL, ContentType: config.ContentType, Client: client}
+		if config.Template != "" {
+			bodyTemplate, templateErr := template.New("body").Parse(config.Template)
+			if templateErr != nil {
+				errorString := fmt.Sprintf("http channel template is not valid: %s", templateErr)
+				return nil, errors.New(errorString)
+			}
+			channel.Template = bodyTemplate
+		}
+		return channel, nil
+	default:
+		errorString := fmt.Sprintf("Channel type '%s' is not defined.", config.Type)
+		return nil, errors.New(errorString)
+	}
+}
+
+// EmailChannel sends notifications through an SMTP server, PLAIN
+// authentication is used when Username is set.
+type EmailChannel struct {
+	Address  string
+	Host     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (channel *EmailChannel) Send(ctx context.Context, notification Notification) error {
+	var auth smtp.Auth
+	if channel.Username != "" {
+		auth = smtp.PlainAuth("", channel.Username, channel.Password, channel.Host)
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", channel.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(channel.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", encodeSubject(notification.Title))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(notification.Message, "\n", "\r\n"))
+	message.WriteString("\r\n")
+	return channel.sendMail(ctx, auth, message.Bytes())
+}
+
+// sendMail works like smtp.SendMail, the connection is closed when ctx is
+// done.
+func (channel *EmailChannel) sendMail(ctx context.Context, auth smtp.Auth, message []byte) error {
+	var dialer net.Dialer
+	connection, dialErr := dialer.DialContext(ctx, "tcp", channel.Address)
+	if dialErr != nil {
+		return dialErr
+	}
+	sent := make(chan struct{})
+	defer close(sent)
+	go func() {
+		select {
+		case <-ctx.Done():
+			connection.Close()
+		case <-sent:
+		}
+	}()
+	client, clientErr := smtp.NewClient(connection, channel.Host)
+	if clientErr != nil {
+		connection.Close()
+		return clientErr
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if tlsErr := client.StartTLS(&tls.Config{ServerName: channel.Host}); tlsErr != nil {
+			return tlsErr
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if authErr := client.Auth(auth); authErr != nil {
+			return authErr
+		}
+	}
+	if mailErr := client.Mail(channel.From); mailErr != nil {
+		return mailErr
+	}
+	for _, to := range channel.To {
+		if rcptErr := client.Rcpt(to); rcptErr != nil {
+			return rcptErr
+		}
+	}
+	writer, dataErr := client.Data()
+	if dataErr != nil {
+		return dataErr
+	}
+	if _, writeErr := writer.Write(message); writeErr != nil {
+		return writeErr
+	}
+	if closeErr := writer.Close(); closeErr != nil {
+		return closeErr
+	}
+	return client.Quit()
+}
+
+// encodeSubject turns title into a single line header value, non ASCII
+// characters are Q-encoded.
+func encodeSubject(title string) string {
+	title = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(title)
+	return mime.QEncoding.Encode("utf-8", title)
+}
+
+// PushChannel publishes notifications to an ntfy topic URL or to a Gotify
+// server, depending on Style.
+type PushChannel struct {
+	Style    string
+	URL      string
+	Token    string
+	Priority int
+	Client   *http.Client
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+func (channel *PushChannel) Send(ctx context.Context, notification Notification) error {
+	var request *http.Request
+	var requestErr error
+	if channel.Style == ChannelGotify {
+		body, _ := json.Marshal(gotifyMessage{Title: notification.Title, Message: notification.Message, Priority: channel.Priority})
+		request, requestErr = http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(channel.URL, "/")+"/message", bytes.NewBuffer(body))
+		if requestErr != nil {
+			return requestErr
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if channel.Token != "" {
+			request.Header.Set("X-Gotify-Key", channel.Token)
+		}
+	} else {
+		request, requestErr = http.NewRequestWithContext(ctx, "POST", channel.URL, bytes.NewBufferString(notification.Message))
+		if requestErr != nil {
+			return requestErr
+		}
+		request.Header.Set("Title", notification.Title)
+		if channel.Priority > 0 {
+			request.Header.Set("Priority", fmt.Sprintf("%d", channel.Priority))
+		}
+		if channel.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+channel.Token)
+		}
+	}
+	return send(channel.Client, request)
+}
+
+// HTTPChannel posts notifications to URL. Body is rendered from Template
+// using the notification, notifications are sent as JSON when it is nil.
+type HTTPChannel struct {
+	URL         string
+	Template    *template.Template
+	ContentType string
+	Client      *http.Client
+}
+
+func (channel *HTTPChannel) Send(ctx context.Context, notification Notification) error {
+	var body bytes.Buffer
+	contentType := channel.ContentType
+	if channel.Template != nil {
+		if templateErr := channel.Template.Execute(&body, notification); templateErr != nil {
+			return templateErr
+		}
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+	} else {
+		json.NewEncoder(&body).Encode(notification)
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+	request, requestErr := http.NewRequestWithContext(ctx, "POST", channel.URL, &body)
+	if requestErr != nil {
+		return requestErr
+	}
+	request.Header.Set("Content-Type", contentType)
+	return send(channel.Client, request)
+}
+
+func send(client *http.Client, request *http.Request) error {
+	response, responseErr := client.Do(request)
+	if responseErr != nil {
+		return responseErr
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		errorString := fmt.Sprintf("%s returned status %d.", request.URL.Host, response.StatusCode)
+		return errors.New(errorString)
+	}
+	return nil
+}
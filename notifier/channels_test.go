@@ -0,0 +1,191 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn accepts one mail and sends its DATA to the returned channel.
+func smtpStandIn(t *testing.T) (string, chan string) {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatalf("SMTP stand-in should listen, error was '%s'.", listenErr)
+	}
+	mails := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		connection, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer connection.Close()
+		reader := bufio.NewReader(connection)
+		reply := func(line string) { connection.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, readErr := reader.ReadString('\n')
+			if readErr != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					dataLine, _ := reader.ReadString('\n')
+					if dataLine == ".\r\n" || dataLine == "" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				mails <- data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), mails
+}
+
+func TestEmailChannel(t *testing.T) {
+
+	address, mails := smtpStandIn(t)
+	channel := &EmailChannel{Address: address, Host: "127.0.0.1", From: "alarm@example.com", To: []string{"admin@example.com"}}
+
+	if sendErr := channel.Send(context.Background(), Notification{Title: "Home Alarm: firing_started", Message: "Home Alarm is firing."}); sendErr != nil {
+		t.Fatalf("Email should be sent, error was '%s'.", sendErr)
+	}
+	mail := <-mails
+	if !strings.Contains(mail, "Subject: Home Alarm: firing_started\r\n") || !strings.Contains(mail, "Home Alarm is firing.") {
+		t.Errorf("Mail should contain title and message, mail was '%s'.", mail)
+	}
+}
+
+func TestEmailChannelSubject(t *testing.T) {
+
+	address, mails := smtpStandIn(t)
+	channel := &EmailChannel{Address: address, Host: "127.0.0.1", From: "alarm@example.com", To: []string{"admin@example.com"}}
+
+	if sendErr := channel.Send(context.Background(), Notification{Title: "Alarma del salón\r\nBcc: victim@example.com", Message: "Alarma del salón is firing."}); sendErr != nil {
+		t.Fatalf("Email should be sent, error was '%s'.", sendErr)
+	}
+	mail := <-mails
+	if !strings.Contains(mail, "Subject: =?utf-8?q?Alarma_del_sal=C3=B3n_Bcc:_victim@example.com?=\r\n") || strings.Contains(mail, "\r\nBcc:") {
+		t.Errorf("Mail subject should be a single encoded line, mail was '%s'.", mail)
+	}
+}
+
+func TestPushChannels(t *testing.T) {
+
+	var request *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ := ioutil.ReadAll(r.Body)
+		request, body = r, string(requestBody)
+	}))
+	defer server.Close()
+	notification := Notification{Title: "Home Alarm: firing_started", Message: "Home Alarm is firing."}
+
+	ntfy, _ := NewChannel(http.DefaultClient, ChannelConfig{Type: ChannelNtfy, URL: server.URL + "/alarms", Token: "secret", Priority: 5})
+	if sendErr := ntfy.Send(context.Background(), notification); sendErr != nil {
+		t.Fatalf("ntfy notification should be sent, error was '%s'.", sendErr)
+	}
+	if request.URL.Path != "/alarms" || request.Header.Get("Title") != notification.Title || request.Header.Get("Priority") != "5" || request.Header.Get("Authorization") != "Bearer secret" || body != notification.Message {
+		t.Errorf("ntfy request is not valid: %s %v '%s'.", request.URL.Path, request.Header, body)
+	}
+
+	gotify, _ := NewChannel(http.DefaultClient, ChannelConfig{Type: ChannelGotify, URL: server.URL, Token: "apptoken"})
+	if sendErr := gotify.Send(context.Background(), notification); sendErr != nil {
+		t.Fatalf("Gotify notification should be sent, error was '%s'.", sendErr)
+	}
+	if request.URL.Path != "/message" || request.Header.Get("X-Gotify-Key") != "apptoken" || body != `{"title":"Home Alarm: firing_started","message":"Home Alarm is firing."}` {
+		t.Errorf("Gotify request is not valid: %s %v '%s'.", request.URL.Path, request.Header, body)
+	}
+}
+
+func TestHTTPChannelTemplate(t *testing.T) {
+
+	var contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ := ioutil.ReadAll(r.Body)
+		contentType, body = r.Header.Get("Content-Type"), string(requestBody)
+	}))
+	defer server.Close()
+
+	channel, channelErr := NewChannel(http.DefaultClient, ChannelConfig{Type: ChannelHTTP, URL: server.URL, ContentType: "application/json", Template: `{"text": "{{.Device}} ({{.Reason}})"}`})
+	if channelErr != nil {
+		t.Fatalf("NewChannel should not fail, error was '%s'.", channelErr)
+	}
+	channel.Send(context.Background(), Notification{Device: "Home Alarm", Reason: "Zone 1"})
+	if contentType != "application/json" || body != `{"text": "Home Alarm (Zone 1)"}` {
+		t.Errorf("HTTP channel should post rendered template, posted %s '%s'.", contentType, body)
+	}
+}
+
+func TestChannelErrors(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	channel, _ := NewChannel(http.DefaultClient, ChannelConfig{Type: ChannelHTTP, URL: server.URL})
+	if sendErr := channel.Send(context.Background(), Notification{}); sendErr == nil {
+		t.Errorf("Send should fail when server returns 500.")
+	}
+	if _, channelErr := NewChannel(http.DefaultClient, ChannelConfig{Type: "pigeon"}); channelErr == nil || channelErr.Error() != "Channel type 'pigeon' is not defined." {
+		t.Errorf("NewChannel error should be \"Channel type 'pigeon' is not defined.\", error was '%v'.", channelErr)
+	}
+}
+
+func TestChannelsCancelled(t *testing.T) {
+
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer server.Close()
+	defer close(blocked)
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatalf("SMTP stand-in should listen, error was '%s'.", listenErr)
+	}
+	defer listener.Close()
+	go func() {
+		// Connection is accepted but greeting is never sent
+		connection, acceptErr := listener.Accept()
+		if acceptErr == nil {
+			<-blocked
+			connection.Close()
+		}
+	}()
+
+	push, _ := NewChannel(http.DefaultClient, ChannelConfig{Type: ChannelNtfy, URL: server.URL})
+	email := &EmailChannel{Address: listener.Addr().String(), Host: "127.0.0.1", From: "alarm@example.com", To: []string{"admin@example.com"}}
+	for name, channel := range map[string]Channel{"ntfy": push, "email": email} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		sendErr := channel.Send(ctx, Notification{Title: "Home Alarm: firing_started"})
+		cancel()
+		if sendErr == nil {
+			t.Errorf("%s channel should fail when context is done.", name)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s channel should stop when context is done, it took %s.", name, elapsed)
+		}
+	}
+}
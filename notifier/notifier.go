@@ -0,0 +1,218 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/logging"
+)
+
+var logger = logging.New("notifier")
+
+// RuleNotification routes receive messages of rule notify actions instead
+// of events of a given type.
+const RuleNotification = "rule_notification"
+
+const (
+	DefaultTitle    = "{{.Device}}: {{.Event.Type}}"
+	DefaultTemplate = "{{.Message}}{{if .Reason}} Reason: {{.Reason}}{{end}}"
+)
+
+// Notification is sent through channels and is the data available to title,
+// message and HTTP body templates. Reason is the decoded alarm message of
+// firing events.
+type Notification struct {
+	Title   string       `json:"title"`
+	Message string       `json:"message"`
+	Device  string       `json:"device,omitempty"`
+	Reason  string       `json:"reason,omitempty"`
+	Event   events.Event `json:"event"`
+}
+
+// Route sends events of EventTypes raised by DeviceIDs, or by any device
+// when empty, to Channels. Title and Template default to DefaultTitle and
+// DefaultTemplate.
+type Route struct {
+	EventTypes      []string
+	DeviceIDs       []string
+	Channels        []string
+	Title           string
+	Template        string
+	titleTemplate   *template.Template
+	messageTemplate *template.Template
+}
+
+type Notifier struct {
+	ctx         context.Context
+	history     *events.History
+	deviceNames map[string]string
+	channels    map[string]Channel
+	routes      []Route
+}
+
+// New creates a notifier, deviceNames maps device IDs to the names shown in
+// notifications. Notifications being sent are cancelled when ctx is done.
+func New(ctx context.Context, history *events.History, deviceNames map[string]string) *Notifier {
+	return &Notifier{ctx: ctx, history: history, deviceNames: deviceNames, channels: make(map[string]Channel)}
+}
+
+func (notifier *Notifier) AddChannel(name string, channel Channel) error {
+	if _, ok := notifier.channels[name]; ok {
+		errorString := fmt.Sprintf("Channel '%s' already exists.", name)
+		return errors.New(errorString)
+	}
+	notifier.channels[name] = channel
+	return nil
+}
+
+func (notifier *Notifier) AddRoute(route Route) error {
+	if len(route.EventTypes) == 0 {
+		return errors.New("Notification routes require at least one event type.")
+	}
+	if len(route.Channels) == 0 {
+		return errors.New("Notification routes require at least one channel.")
+	}
+	for _, channelName := range route.Channels {
+		if _, ok := notifier.channels[channelName]; !ok {
+			errorString := fmt.Sprintf("Channel '%s' does not exist.", channelName)
+			return errors.New(errorString)
+		}
+	}
+	if route.Title == "" {
+		route.Title = DefaultTitle
+	}
+	if route.Template == "" {
+		route.Template = DefaultTemplate
+	}
+	var templateErr error
+	if route.titleTemplate, templateErr = template.New("title").Parse(route.Title); templateErr != nil {
+		errorString := fmt.Sprintf("Notification title '%s' is not valid: %s", route.Title, templateErr)
+		return errors.New(errorString)
+	}
+	if route.messageTemplate, templateErr = template.New("message").Parse(route.Template); templateErr != nil {
+		errorString := fmt.Sprintf("Notification template '%s' is not valid: %s", route.Template, templateErr)
+		return errors.New(errorString)
+	}
+	notifier.routes = append(notifier.routes, route)
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (route *Route) matches(eventType string, deviceID string) bool {
+	return contains(route.EventTypes, eventType) && (len(route.DeviceIDs) == 0 || contains(route.DeviceIDs, deviceID))
+}
+
+func (route *Route) render(notification Notification) (Notification, error) {
+	var title, message bytes.Buffer
+	if templateErr := route.titleTemplate.Execute(&title, notification); templateErr != nil {
+		return notification, templateErr
+	}
+	if templateErr := route.messageTemplate.Execute(&message, notification); templateErr != nil {
+		return notification, templateErr
+	}
+	notification.Title = title.String()
+	notification.Message = message.String()
+	return notification, nil
+}
+
+// Notify sends event to the channels routed for its type. Messages sent by
+// rules are not empty, they replace event message and are sent through
+// RuleNotification routes instead. Every channel is used at most once, with
+// the templates of the first route which uses it.
+func (notifier *Notifier) Notify(event events.Event, message string) error {
+	eventType := event.Type
+	if message == "" {
+		message = event.Message
+	} else {
+		eventType = RuleNotification
+	}
+	var routes []*Route
+	for index := range notifier.routes {
+		if notifier.routes[index].matches(eventType, event.DeviceID) {
+			routes = append(routes, &notifier.routes[index])
+		}
+	}
+	return notifier.send(routes, event, message)
+}
+
+func (notifier *Notifier) send(routes []*Route, event events.Event, message string) error {
+	device := notifier.deviceNames[event.DeviceID]
+	if device == "" {
+		device = event.DeviceID
+	}
+	notification := Notification{Message: message, Device: device, Reason: event.Data["reason"], Event: event}
+
+	var failures []string
+	sent := make(map[string]bool)
+	for _, route := range routes {
+		rendered, renderErr := route.render(notification)
+		if renderErr != nil {
+			failures = append(failures, renderErr.Error())
+			continue
+		}
+		for _, channelName := range route.Channels {
+			if sent[channelName] {
+				continue
+			}
+			sent[channelName] = true
+			if sendErr := notifier.channels[channelName].Send(notifier.ctx, rendered); sendErr != nil {
+				logger.Error("Notification failed.", "event", event.ID, "channel", channelName, "error", sendErr)
+				failures = append(failures, fmt.Sprintf("%s: %s", channelName, sendErr))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		errorString := fmt.Sprintf("Notification failed, %s", strings.Join(failures, ", "))
+		return errors.New(errorString)
+	}
+	return nil
+}
+
+// NotifyChannels sends message about event through channelNames using
+// default templates, routes are ignored.
+func (notifier *Notifier) NotifyChannels(channelNames []string, event events.Event, message string) error {
+	route := Route{EventTypes: []string{event.Type}, Channels: channelNames}
+	for _, channelName := range channelNames {
+		if _, ok := notifier.channels[channelName]; !ok {
+			errorString := fmt.Sprintf("Channel '%s' does not exist.", channelName)
+			return errors.New(errorString)
+		}
+	}
+	route.titleTemplate = template.Must(template.New("title").Parse(DefaultTitle))
+	route.messageTemplate = template.Must(template.New("message").Parse(DefaultTemplate))
+	return notifier.send([]*Route{&route}, event, message)
+}
+
+// Run notifies every event received from subscription until ctx is done,
+// events already received by then are still notified. Subscription must be
+// created before events which have to be notified are recorded.
+func (notifier *Notifier) Run(ctx context.Context, subscription <-chan events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-subscription:
+					notifier.Notify(event, "")
+				default:
+					return
+				}
+			}
+		case event := <-subscription:
+			notifier.Notify(event, "")
+		}
+	}
+}
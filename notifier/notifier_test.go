@@ -0,0 +1,120 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/a-castellano/AlarmManager/events"
+)
+
+type ChannelMock struct {
+	Sent    []Notification
+	SendErr error
+}
+
+func (cm *ChannelMock) Send(ctx context.Context, notification Notification) error {
+	cm.Sent = append(cm.Sent, notification)
+	return cm.SendErr
+}
+
+func testNotifier(t *testing.T, routes []Route) (*Notifier, *ChannelMock, *ChannelMock) {
+	notifier := New(context.Background(), events.NewHistory(10), map[string]string{"home123": "Home Alarm"})
+	email, phone := &ChannelMock{}, &ChannelMock{}
+	notifier.AddChannel("email", email)
+	notifier.AddChannel("phone", phone)
+	for _, route := range routes {
+		if routeErr := notifier.AddRoute(route); routeErr != nil {
+			t.Fatalf("AddRoute should not fail, error was '%s'.", routeErr)
+		}
+	}
+	return notifier, email, phone
+}
+
+func TestNotifyRoutes(t *testing.T) {
+
+	notifier, email, phone := testNotifier(t, []Route{
+		{EventTypes: []string{events.FiringStarted}, Channels: []string{"email", "phone"}},
+		{EventTypes: []string{events.FiringStarted, events.DeviceOffline}, DeviceIDs: []string{"home123"}, Channels: []string{"email"}},
+	})
+
+	firing := events.Event{Type: events.FiringStarted, DeviceID: "home123", Message: "Home Alarm is firing.", Data: map[string]string{"reason": "APP Desermado"}}
+	if notifyErr := notifier.Notify(firing, ""); notifyErr != nil {
+		t.Fatalf("Notify should not fail, error was '%s'.", notifyErr)
+	}
+	if len(email.Sent) != 1 || len(phone.Sent) != 1 {
+		t.Fatalf("Every channel should receive one notification, email got %d and phone %d.", len(email.Sent), len(phone.Sent))
+	}
+	if email.Sent[0].Title != "Home Alarm: firing_started" || email.Sent[0].Message != "Home Alarm is firing. Reason: APP Desermado" {
+		t.Errorf("Default templates should include device and reason, notification was %+v.", email.Sent[0])
+	}
+
+	notifier.Notify(events.Event{Type: events.DeviceOffline, DeviceID: "office123"}, "")
+	notifier.Notify(events.Event{Type: events.LowBattery, DeviceID: "home123"}, "")
+	if len(email.Sent) != 1 {
+		t.Errorf("Events without routes should not be notified, email got %d notifications.", len(email.Sent))
+	}
+}
+
+func TestNotifyRuleMessage(t *testing.T) {
+
+	notifier, email, phone := testNotifier(t, []Route{
+		{EventTypes: []string{events.LowBattery}, Channels: []string{"email"}},
+		{EventTypes: []string{RuleNotification}, Channels: []string{"phone"}, Title: "Rule", Template: "{{.Message}} ({{.Device}})"},
+	})
+
+	notifier.Notify(events.Event{Type: events.LowBattery, DeviceID: "home123"}, "Replace battery")
+	if len(email.Sent) != 0 {
+		t.Errorf("Rule messages should not use event type routes.")
+	}
+	if len(phone.Sent) != 1 || phone.Sent[0].Message != "Replace battery (Home Alarm)" {
+		t.Errorf("Rule messages should use rule_notification routes, notifications were %+v.", phone.Sent)
+	}
+}
+
+func TestNotifyFailure(t *testing.T) {
+
+	notifier, email, _ := testNotifier(t, []Route{{EventTypes: []string{events.FiringStarted}, Channels: []string{"email"}}})
+	email.SendErr = errors.New("connection refused")
+
+	notifyErr := notifier.Notify(events.Event{Type: events.FiringStarted, DeviceID: "home123"}, "")
+	if notifyErr == nil || notifyErr.Error() != "Notification failed, email: connection refused" {
+		t.Errorf("Notify error should be \"Notification failed, email: connection refused\", error was '%v'.", notifyErr)
+	}
+}
+
+func TestAddRouteInvalid(t *testing.T) {
+
+	notifier := New(context.Background(), events.NewHistory(10), nil)
+	if routeErr := notifier.AddRoute(Route{EventTypes: []string{events.FiringStarted}, Channels: []string{"email"}}); routeErr == nil || routeErr.Error() != "Channel 'email' does not exist." {
+		t.Errorf("AddRoute error should be \"Channel 'email' does not exist.\", error was '%v'.", routeErr)
+	}
+}
+
+func TestNotifyChannels(t *testing.T) {
+
+	notifier, email, phone := testNotifier(t, nil)
+
+	notifier.NotifyChannels([]string{"phone"}, events.Event{Type: events.FiringStarted, DeviceID: "home123"}, "Home Alarm is still firing.")
+	if len(email.Sent) != 0 || len(phone.Sent) != 1 || phone.Sent[0].Message != "Home Alarm is still firing." {
+		t.Errorf("Only phone should be notified, notifications were %+v and %+v.", email.Sent, phone.Sent)
+	}
+	if notifyErr := notifier.NotifyChannels([]string{"pager"}, events.Event{}, ""); notifyErr == nil {
+		t.Errorf("NotifyChannels should fail with unknown channels.")
+	}
+}
+
+func TestRunFlushesEvents(t *testing.T) {
+
+	notifier, email, _ := testNotifier(t, []Route{{EventTypes: []string{events.FiringStarted, events.ModeChanged}, Channels: []string{"email"}}})
+	subscription := make(chan events.Event, 2)
+	subscription <- events.Event{Type: events.FiringStarted, DeviceID: "home123"}
+	subscription <- events.Event{Type: events.ModeChanged, DeviceID: "home123"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	notifier.Run(ctx, subscription)
+	if len(email.Sent) != 2 {
+		t.Errorf("Recorded events should be notified before stopping, %d were notified.", len(email.Sent))
+	}
+}
@@ -0,0 +1,431 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	// Time zones do not depend on host tzdata
+	_ "time/tzdata"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/logging"
+)
+
+var logger = logging.New("rules")
+
+// Triggers
+const (
+	TriggerFiring     = "firing_started"
+	TriggerOffline    = "device_offline"
+	TriggerLowBattery = "low_battery"
+	// TriggerLeftArmed fires when a device leaves fully armed mode
+	TriggerLeftArmed = "left_armed"
+)
+
+var triggerEvents = map[string]string{
+	TriggerFiring:     events.FiringStarted,
+	TriggerOffline:    events.DeviceOffline,
+	TriggerLowBattery: events.LowBattery,
+	TriggerLeftArmed:  events.ModeChanged,
+}
+
+// Actions
+const (
+	ActionChangeMode = "change_mode"
+	ActionWebhook    = "webhook"
+	ActionNotify     = "notify"
+)
+
+// Manager is implemented by device_manager.DeviceManager.
+type Manager interface {
+	HasDevice(deviceID string) bool
+	HasGroup(groupID string) bool
+	ChangeMode(ctx context.Context, client *http.Client, deviceID string, newMode string) error
+	ChangeGroupMode(ctx context.Context, client *http.Client, groupID string, newMode string, rollback bool) ([]device_manager.GroupMemberResult, error)
+	DeviceInfo(deviceID string) (device_manager.AlarmInfo, bool)
+}
+
+// Notifier delivers notify actions.
+type Notifier interface {
+	Notify(event events.Event, message string) error
+}
+
+type Action struct {
+	Type     string
+	DeviceID string
+	GroupID  string
+	Mode     string
+	URL      string
+	Message  string
+}
+
+// Rule runs its actions when an event matching Trigger is recorded for
+// DeviceID, or for any device when DeviceID is empty. Offline triggers may
+// wait For the device to stay offline. TimeWindow ("22:00-07:00") and Mode
+// conditions are checked when the rule fires, Mode is compared with
+// ModeDeviceID or with the device which triggered the rule.
+type Rule struct {
+	ID           string
+	Trigger      string
+	DeviceID     string
+	For          time.Duration
+	TimeWindow   string
+	TimeZone     string
+	Mode         string
+	ModeDeviceID string
+	DryRun       bool
+	Actions      []Action
+	location     *time.Location
+	windowStart  int
+	windowEnd    int
+}
+
+type Engine struct {
+	manager Manager
+	client  *http.Client
+	history *events.History
+	// Notifier receives notify actions, messages are only logged when nil
+	Notifier Notifier
+	rules    map[string]*Rule
+	pending  map[string]*time.Timer
+	mutex    sync.Mutex
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func New(manager Manager, client *http.Client, history *events.History) *Engine {
+	return &Engine{manager: manager, client: client, history: history, rules: make(map[string]*Rule), pending: make(map[string]*time.Timer), now: time.Now}
+}
+
+// parseTimeWindow returns window start and end as minutes of day.
+func parseTimeWindow(timeWindow string) (int, int, error) {
+	bounds := strings.Split(timeWindow, "-")
+	if len(bounds) != 2 {
+		return 0, 0, errors.New("time window must be HH:MM-HH:MM")
+	}
+	var minutes [2]int
+	for index, bound := range bounds {
+		boundTime, parseErr := time.Parse("15:04", strings.TrimSpace(bound))
+		if parseErr != nil {
+			return 0, 0, parseErr
+		}
+		minutes[index] = boundTime.Hour()*60 + boundTime.Minute()
+	}
+	return minutes[0], minutes[1], nil
+}
+
+func (engine *Engine) validateAction(ruleID string, action Action) error {
+	switch action.Type {
+	case ActionChangeMode:
+		if (action.DeviceID == "") == (action.GroupID == "") {
+			errorString := fmt.Sprintf("Rule '%s' change_mode action must target either a device or a group.", ruleID)
+			return errors.New(errorString)
+		}
+		if action.DeviceID != "" && !engine.manager.HasDevice(action.DeviceID) {
+			errorString := fmt.Sprintf("Device id '%s' is not a managed device.", action.DeviceID)
+			return errors.New(errorString)
+		}
+		if action.GroupID != "" && !engine.manager.HasGroup(action.GroupID) {
+			errorString := fmt.Sprintf("Group '%s' does not exist.", action.GroupID)
+			return errors.New(errorString)
+		}
+		if _, ok := device_manager.AlarmModeMap[action.Mode]; !ok {
+			errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", action.Mode)
+			return errors.New(errorString)
+		}
+	case ActionWebhook:
+		if !strings.HasPrefix(action.URL, "http://") && !strings.HasPrefix(action.URL, "https://") {
+			errorString := fmt.Sprintf("Rule '%s' webhook url '%s' is not valid.", ruleID, action.URL)
+			return errors.New(errorString)
+		}
+	case ActionNotify:
+	default:
+		errorString := fmt.Sprintf("Rule '%s' action type '%s' is not defined.", ruleID, action.Type)
+		return errors.New(errorString)
+	}
+	return nil
+}
+
+// AddRule validates rule and starts evaluating it.
+func (engine *Engine) AddRule(rule Rule) error {
+	if rule.ID == "" {
+		return errors.New("Rule id is required.")
+	}
+	if _, ok := triggerEvents[rule.Trigger]; !ok {
+		errorString := fmt.Sprintf("Rule '%s' trigger '%s' is not defined.", rule.ID, rule.Trigger)
+		return errors.New(errorString)
+	}
+	if rule.For != 0 && rule.Trigger != TriggerOffline {
+		errorString := fmt.Sprintf("Rule '%s' only %s triggers accept a duration.", rule.ID, TriggerOffline)
+		return errors.New(errorString)
+	}
+	for _, deviceID := range []string{rule.DeviceID, rule.ModeDeviceID} {
+		if deviceID != "" && !engine.manager.HasDevice(deviceID) {
+			errorString := fmt.Sprintf("Device id '%s' is not a managed device.", deviceID)
+			return errors.New(errorString)
+		}
+	}
+	if _, ok := device_manager.AlarmModeMap[rule.Mode]; rule.Mode != "" && !ok {
+		errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", rule.Mode)
+		return errors.New(errorString)
+	}
+	if rule.TimeWindow != "" {
+		windowStart, windowEnd, windowErr := parseTimeWindow(rule.TimeWindow)
+		if windowErr != nil {
+			errorString := fmt.Sprintf("Rule '%s' time window '%s' is not valid.", rule.ID, rule.TimeWindow)
+			return errors.New(errorString)
+		}
+		rule.windowStart, rule.windowEnd = windowStart, windowEnd
+	}
+	if rule.TimeZone == "" {
+		rule.TimeZone = "Local"
+	}
+	location, locationErr := time.LoadLocation(rule.TimeZone)
+	if locationErr != nil {
+		errorString := fmt.Sprintf("Rule '%s' time zone '%s' is not valid.", rule.ID, rule.TimeZone)
+		return errors.New(errorString)
+	}
+	rule.location = location
+	if len(rule.Actions) == 0 {
+		errorString := fmt.Sprintf("Rule '%s' has no actions.", rule.ID)
+		return errors.New(errorString)
+	}
+	for _, action := range rule.Actions {
+		if actionErr := engine.validateAction(rule.ID, action); actionErr != nil {
+			return actionErr
+		}
+	}
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	if _, ok := engine.rules[rule.ID]; ok {
+		errorString := fmt.Sprintf("Rule '%s' already exists.", rule.ID)
+		return errors.New(errorString)
+	}
+	engine.rules[rule.ID] = &rule
+	return nil
+}
+
+// Run evaluates rules against every event received from subscription until
+// ctx is done. Subscription must be created before devices are first polled
+// so their startup state is matched too.
+func (engine *Engine) Run(ctx context.Context, subscription <-chan events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			engine.mutex.Lock()
+			for key, timer := range engine.pending {
+				timer.Stop()
+				delete(engine.pending, key)
+			}
+			engine.mutex.Unlock()
+			return
+		case event := <-subscription:
+			engine.Handle(ctx, event)
+		}
+	}
+}
+
+func matches(rule *Rule, event events.Event) bool {
+	if triggerEvents[rule.Trigger] != event.Type {
+		return false
+	}
+	if rule.DeviceID != "" && rule.DeviceID != event.DeviceID {
+		return false
+	}
+	if rule.Trigger == TriggerLeftArmed {
+		return event.Data["from"] == device_manager.AlarmModeAlarmValues[device_manager.FullyArmed]
+	}
+	return true
+}
+
+// Handle runs rules triggered by event. Offline rules with a duration are
+// delayed until the device has been offline For since the time reported by
+// the event, and cancelled when the device comes back online. Actions are
+// cancelled when ctx is done.
+func (engine *Engine) Handle(ctx context.Context, event events.Event) {
+	engine.mutex.Lock()
+	if event.Type == events.DeviceOnline {
+		for key, timer := range engine.pending {
+			if strings.HasSuffix(key, "/"+event.DeviceID) {
+				timer.Stop()
+				delete(engine.pending, key)
+			}
+		}
+	}
+	var triggered []Rule
+	for _, rule := range engine.rules {
+		if !matches(rule, event) {
+			continue
+		}
+		if rule.For == 0 {
+			triggered = append(triggered, *rule)
+			continue
+		}
+		key := rule.ID + "/" + event.DeviceID
+		if _, ok := engine.pending[key]; ok {
+			continue
+		}
+		// devices are reported offline after the debounce, the time they
+		// have been offline since counts
+		delay := rule.For
+		if since, parseErr := time.Parse(time.RFC3339, event.Data["since"]); parseErr == nil {
+			delay -= engine.now().Sub(since)
+		}
+		delayedRule := *rule
+		engine.pending[key] = time.AfterFunc(delay, func() {
+			engine.mutex.Lock()
+			_, ok := engine.pending[key]
+			delete(engine.pending, key)
+			engine.mutex.Unlock()
+			if ok {
+				engine.fireOffline(ctx, delayedRule, event)
+			}
+		})
+	}
+	engine.mutex.Unlock()
+
+	sort.Slice(triggered, func(i, j int) bool { return triggered[i].ID < triggered[j].ID })
+	for _, rule := range triggered {
+		engine.fire(ctx, rule, event)
+	}
+}
+
+func (engine *Engine) fireOffline(ctx context.Context, rule Rule, event events.Event) {
+	if info, ok := engine.manager.DeviceInfo(event.DeviceID); ok && info.Online {
+		return
+	}
+	engine.fire(ctx, rule, event)
+}
+
+func (engine *Engine) conditionsMet(rule Rule, event events.Event) bool {
+	if rule.TimeWindow != "" {
+		now := engine.now().In(rule.location)
+		minute := now.Hour()*60 + now.Minute()
+		var inWindow bool
+		if rule.windowStart <= rule.windowEnd {
+			inWindow = minute >= rule.windowStart && minute < rule.windowEnd
+		} else {
+			inWindow = minute >= rule.windowStart || minute < rule.windowEnd
+		}
+		if !inWindow {
+			return false
+		}
+	}
+	if rule.Mode != "" {
+		deviceID := rule.ModeDeviceID
+		if deviceID == "" {
+			deviceID = event.DeviceID
+		}
+		info, ok := engine.manager.DeviceInfo(deviceID)
+		if !ok || info.Mode != device_manager.AlarmModeMap[rule.Mode] {
+			return false
+		}
+	}
+	return true
+}
+
+func describeAction(action Action) string {
+	switch action.Type {
+	case ActionChangeMode:
+		if action.DeviceID != "" {
+			return fmt.Sprintf("change device %s mode to '%s'", action.DeviceID, action.Mode)
+		}
+		return fmt.Sprintf("change group %s mode to '%s'", action.GroupID, action.Mode)
+	case ActionWebhook:
+		return fmt.Sprintf("call webhook %s", action.URL)
+	default:
+		return "send notification"
+	}
+}
+
+func (engine *Engine) fire(ctx context.Context, rule Rule, event events.Event) {
+	if !engine.conditionsMet(rule, event) {
+		return
+	}
+	var descriptions []string
+	for _, action := range rule.Actions {
+		descriptions = append(descriptions, describeAction(action))
+	}
+	ruleEvent := events.Event{Type: events.RuleTriggered, Source: "rule " + rule.ID, DeviceID: event.DeviceID, Success: true, Data: map[string]string{"trigger": event.Type}}
+	if rule.DryRun {
+		ruleEvent.Type = events.RuleDryRun
+		ruleEvent.Message = fmt.Sprintf("Rule would %s.", strings.Join(descriptions, ", "))
+		logger.Info("Rule dry run.", "rule", rule.ID, "actions", ruleEvent.Message)
+		engine.history.Record(ruleEvent)
+		return
+	}
+	logger.Info("Rule triggered.", "rule", rule.ID, "event", event.Type, "device", event.DeviceID)
+	var failures []string
+	for _, action := range rule.Actions {
+		if actionErr := engine.runAction(ctx, rule, action, event); actionErr != nil {
+			logger.Error("Rule action failed.", "rule", rule.ID, "action", describeAction(action), "error", actionErr)
+			failures = append(failures, actionErr.Error())
+		}
+	}
+	if len(failures) > 0 {
+		ruleEvent.Success = false
+		ruleEvent.Message = strings.Join(failures, " ")
+	} else {
+		ruleEvent.Message = fmt.Sprintf("Rule did %s.", strings.Join(descriptions, ", "))
+	}
+	engine.history.Record(ruleEvent)
+}
+
+type webhookPayload struct {
+	Rule  string       `json:"rule"`
+	Event events.Event `json:"event"`
+}
+
+func (engine *Engine) runAction(ctx context.Context, rule Rule, action Action, event events.Event) error {
+	switch action.Type {
+	case ActionChangeMode:
+		if action.DeviceID != "" {
+			return engine.manager.ChangeMode(ctx, engine.client, action.DeviceID, action.Mode)
+		}
+		results, changeModeErr := engine.manager.ChangeGroupMode(ctx, engine.client, action.GroupID, action.Mode, false)
+		if changeModeErr != nil {
+			return changeModeErr
+		}
+		for _, result := range results {
+			if !result.Success {
+				return errors.New("Some group members failed to change mode.")
+			}
+		}
+	case ActionWebhook:
+		payload, _ := json.Marshal(webhookPayload{Rule: rule.ID, Event: event})
+		request, requestErr := http.NewRequestWithContext(ctx, "POST", action.URL, bytes.NewBuffer(payload))
+		if requestErr != nil {
+			return requestErr
+		}
+		request.Header.Set("Content-Type", "application/json")
+		response, postErr := engine.client.Do(request)
+		if postErr != nil {
+			return postErr
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			errorString := fmt.Sprintf("Webhook %s returned status %d.", action.URL, response.StatusCode)
+			return errors.New(errorString)
+		}
+	case ActionNotify:
+		message := action.Message
+		if message == "" {
+			message = event.Message
+		}
+		if engine.Notifier == nil {
+			logger.Info("Rule notification.", "rule", rule.ID, "message", message)
+			return nil
+		}
+		return engine.Notifier.Notify(event, message)
+	}
+	return nil
+}
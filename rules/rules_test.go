@@ -0,0 +1,230 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/events"
+)
+
+type ManagerMock struct {
+	Infos   map[string]device_manager.AlarmInfo
+	Changes []string
+	mutex   sync.Mutex
+}
+
+func (mm *ManagerMock) HasDevice(deviceID string) bool {
+	return deviceID == "home123" || deviceID == "office123"
+}
+
+func (mm *ManagerMock) HasGroup(groupID string) bool {
+	return groupID == "premises"
+}
+
+func (mm *ManagerMock) ChangeMode(ctx context.Context, client *http.Client, deviceID string, newMode string) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	mm.Changes = append(mm.Changes, deviceID+" "+newMode)
+	return nil
+}
+
+func (mm *ManagerMock) ChangeGroupMode(ctx context.Context, client *http.Client, groupID string, newMode string, rollback bool) ([]device_manager.GroupMemberResult, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	mm.Changes = append(mm.Changes, groupID+" "+newMode)
+	return []device_manager.GroupMemberResult{{DeviceID: "office123", Success: true}}, nil
+}
+
+func (mm *ManagerMock) DeviceInfo(deviceID string) (device_manager.AlarmInfo, bool) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	info, ok := mm.Infos[deviceID]
+	return info, ok
+}
+
+func (mm *ManagerMock) changes() []string {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	return append([]string{}, mm.Changes...)
+}
+
+type NotifierMock struct {
+	Messages []string
+}
+
+func (nm *NotifierMock) Notify(event events.Event, message string) error {
+	nm.Messages = append(nm.Messages, message)
+	return nil
+}
+
+func testEngine(t *testing.T, manager *ManagerMock, rule Rule) (*Engine, *events.History) {
+	history := events.NewHistory(10)
+	engine := New(manager, &http.Client{}, history)
+	if addErr := engine.AddRule(rule); addErr != nil {
+		t.Fatalf("AddRule should not fail, error was '%s'.", addErr)
+	}
+	return engine, history
+}
+
+func TestFiringChangesOtherDeviceMode(t *testing.T) {
+
+	manager := &ManagerMock{}
+	engine, history := testEngine(t, manager, Rule{ID: "lock_office", Trigger: TriggerFiring, DeviceID: "home123", Actions: []Action{{Type: ActionChangeMode, DeviceID: "office123", Mode: "Armed"}}})
+
+	engine.Handle(context.Background(), events.Event{Type: events.FiringStarted, DeviceID: "office123"})
+	if len(manager.changes()) != 0 {
+		t.Errorf("Rule should only be triggered by its device, changes were %v.", manager.changes())
+	}
+	engine.Handle(context.Background(), events.Event{Type: events.FiringStarted, DeviceID: "home123"})
+	if changes := manager.changes(); len(changes) != 1 || changes[0] != "office123 Armed" {
+		t.Errorf("Rule should arm office123, changes were %v.", changes)
+	}
+	recorded := history.Recent(10, events.Event{Type: events.RuleTriggered})
+	if len(recorded) != 1 || !recorded[0].Success || recorded[0].Source != "rule lock_office" {
+		t.Errorf("Rule execution should be recorded, events were %+v.", recorded)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+
+	manager := &ManagerMock{}
+	engine, history := testEngine(t, manager, Rule{ID: "lock_office", Trigger: TriggerFiring, DryRun: true, Actions: []Action{{Type: ActionChangeMode, GroupID: "premises", Mode: "Armed"}}})
+
+	engine.Handle(context.Background(), events.Event{Type: events.FiringStarted, DeviceID: "home123"})
+	if len(manager.changes()) != 0 {
+		t.Errorf("Dry run rules should not change modes, changes were %v.", manager.changes())
+	}
+	recorded := history.Recent(10, events.Event{Type: events.RuleDryRun})
+	if len(recorded) != 1 || recorded[0].Message != "Rule would change group premises mode to 'Armed'." {
+		t.Errorf("Dry run should be recorded, events were %+v.", recorded)
+	}
+}
+
+func TestTimeWindowCondition(t *testing.T) {
+
+	manager := &ManagerMock{}
+	engine, _ := testEngine(t, manager, Rule{ID: "night", Trigger: TriggerLeftArmed, TimeWindow: "22:00-07:00", TimeZone: "Europe/Madrid", Actions: []Action{{Type: ActionChangeMode, DeviceID: "office123", Mode: "Armed"}}})
+	leftArmed := events.Event{Type: events.ModeChanged, DeviceID: "home123", Data: map[string]string{"from": "arm", "to": "disarmed"}}
+
+	// 12:00 UTC is 14:00 in Madrid
+	engine.now = func() time.Time { return time.Date(2022, 6, 3, 12, 0, 0, 0, time.UTC) }
+	engine.Handle(context.Background(), leftArmed)
+	if len(manager.changes()) != 0 {
+		t.Errorf("Rule should not run outside its time window, changes were %v.", manager.changes())
+	}
+	// 03:00 UTC is 05:00 in Madrid
+	engine.now = func() time.Time { return time.Date(2022, 6, 3, 3, 0, 0, 0, time.UTC) }
+	engine.Handle(context.Background(), events.Event{Type: events.ModeChanged, DeviceID: "home123", Data: map[string]string{"from": "home", "to": "disarmed"}})
+	if len(manager.changes()) != 0 {
+		t.Errorf("Rule should only run when leaving armed mode, changes were %v.", manager.changes())
+	}
+	engine.Handle(context.Background(), leftArmed)
+	if len(manager.changes()) != 1 {
+		t.Errorf("Rule should run inside its time window, changes were %v.", manager.changes())
+	}
+}
+
+func TestModeCondition(t *testing.T) {
+
+	manager := &ManagerMock{Infos: map[string]device_manager.AlarmInfo{"office123": {Mode: device_manager.Disarmed, Online: true}}}
+	notifier := &NotifierMock{}
+	engine, _ := testEngine(t, manager, Rule{ID: "battery", Trigger: TriggerLowBattery, Mode: "Armed", ModeDeviceID: "office123", Actions: []Action{{Type: ActionNotify, Message: "Replace battery"}}})
+	engine.Notifier = notifier
+
+	engine.Handle(context.Background(), events.Event{Type: events.LowBattery, DeviceID: "home123"})
+	if len(notifier.Messages) != 0 {
+		t.Errorf("Rule should not run while office123 is disarmed.")
+	}
+	manager.Infos["office123"] = device_manager.AlarmInfo{Mode: device_manager.FullyArmed, Online: true}
+	engine.Handle(context.Background(), events.Event{Type: events.LowBattery, DeviceID: "home123"})
+	if len(notifier.Messages) != 1 || notifier.Messages[0] != "Replace battery" {
+		t.Errorf("Rule should notify when office123 is armed, messages were %v.", notifier.Messages)
+	}
+}
+
+func TestOfflineFor(t *testing.T) {
+
+	manager := &ManagerMock{Infos: map[string]device_manager.AlarmInfo{"home123": {Online: false}}}
+	engine, _ := testEngine(t, manager, Rule{ID: "offline", Trigger: TriggerOffline, For: 30 * time.Millisecond, Actions: []Action{{Type: ActionChangeMode, DeviceID: "office123", Mode: "Armed"}}})
+
+	engine.Handle(context.Background(), events.Event{Type: events.DeviceOffline, DeviceID: "home123"})
+	engine.Handle(context.Background(), events.Event{Type: events.DeviceOnline, DeviceID: "home123"})
+	time.Sleep(60 * time.Millisecond)
+	if len(manager.changes()) != 0 {
+		t.Errorf("Rule should not run when device recovers in time, changes were %v.", manager.changes())
+	}
+
+	engine.Handle(context.Background(), events.Event{Type: events.DeviceOffline, DeviceID: "home123"})
+	time.Sleep(60 * time.Millisecond)
+	if len(manager.changes()) != 1 {
+		t.Errorf("Rule should run when device stays offline, changes were %v.", manager.changes())
+	}
+}
+
+func TestOfflineForSince(t *testing.T) {
+
+	manager := &ManagerMock{Infos: map[string]device_manager.AlarmInfo{"home123": {Online: false}}}
+	engine, _ := testEngine(t, manager, Rule{ID: "offline", Trigger: TriggerOffline, For: time.Hour, Actions: []Action{{Type: ActionChangeMode, DeviceID: "office123", Mode: "Armed"}}})
+	now := time.Date(2022, 2, 17, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+
+	engine.Handle(context.Background(), events.Event{Type: events.DeviceOffline, DeviceID: "home123", Data: map[string]string{"since": now.Add(-30 * time.Minute).Format(time.RFC3339)}})
+	time.Sleep(30 * time.Millisecond)
+	if len(manager.changes()) != 0 {
+		t.Errorf("Rule should wait until device has been offline for an hour, changes were %v.", manager.changes())
+	}
+	engine.Handle(context.Background(), events.Event{Type: events.DeviceOnline, DeviceID: "home123"})
+
+	engine.Handle(context.Background(), events.Event{Type: events.DeviceOffline, DeviceID: "home123", Data: map[string]string{"since": now.Add(-time.Hour).Format(time.RFC3339)}})
+	time.Sleep(30 * time.Millisecond)
+	if len(manager.changes()) != 1 {
+		t.Errorf("Rule should run when device was already offline for an hour when reported, changes were %v.", manager.changes())
+	}
+}
+
+func TestWebhookAction(t *testing.T) {
+
+	var payload webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+
+	engine, history := testEngine(t, &ManagerMock{}, Rule{ID: "hook", Trigger: TriggerFiring, Actions: []Action{{Type: ActionWebhook, URL: server.URL}}})
+	engine.Handle(context.Background(), events.Event{Type: events.FiringStarted, DeviceID: "home123"})
+
+	if payload.Rule != "hook" || payload.Event.DeviceID != "home123" {
+		t.Errorf("Webhook should receive rule and event, payload was %+v.", payload)
+	}
+	if recorded := history.Recent(1, events.Event{}); len(recorded) != 1 || !recorded[0].Success {
+		t.Errorf("Webhook execution should succeed, events were %+v.", recorded)
+	}
+}
+
+func TestAddRuleInvalid(t *testing.T) {
+
+	engine := New(&ManagerMock{}, &http.Client{}, events.NewHistory(10))
+	notify := []Action{{Type: ActionNotify}}
+	invalidRules := map[string]Rule{
+		"Rule 'r' trigger 'exploded' is not defined.":                         {ID: "r", Trigger: "exploded", Actions: notify},
+		"Rule 'r' only device_offline triggers accept a duration.":            {ID: "r", Trigger: TriggerFiring, For: time.Minute, Actions: notify},
+		"Device id 'garage123' is not a managed device.":                      {ID: "r", Trigger: TriggerFiring, DeviceID: "garage123", Actions: notify},
+		"Rule 'r' time window '22:00' is not valid.":                          {ID: "r", Trigger: TriggerFiring, TimeWindow: "22:00", Actions: notify},
+		"Rule 'r' has no actions.":                                            {ID: "r", Trigger: TriggerFiring},
+		"Rule 'r' action type 'explode' is not defined.":                      {ID: "r", Trigger: TriggerFiring, Actions: []Action{{Type: "explode"}}},
+		"Rule 'r' change_mode action must target either a device or a group.": {ID: "r", Trigger: TriggerFiring, Actions: []Action{{Type: ActionChangeMode, Mode: "Armed"}}},
+	}
+	for expected, rule := range invalidRules {
+		if addErr := engine.AddRule(rule); addErr == nil || addErr.Error() != expected {
+			t.Errorf("AddRule error should be \"%s\", error was '%v'.", expected, addErr)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	// Time zones do not depend on host tzdata
+	_ "time/tzdata"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/events"
+	"github.com/a-castellano/AlarmManager/logging"
+	chi "github.com/go-chi/chi/v5"
+	"github.com/robfig/cron/v3"
+)
+
+var logger = logging.New("scheduler")
+
+const dateLayout = "2006-01-02"
+
+// A schedule whose next runs all fall on holidays is considered broken
+// after this many attempts.
+const maxHolidaySkips = 1000
+
+// ModeChanger is implemented by device_manager.DeviceManager.
+type ModeChanger interface {
+	HasDevice(deviceID string) bool
+	HasGroup(groupID string) bool
+	ChangeMode(ctx context.Context, client *http.Client, deviceID string, newMode string) error
+	ChangeGroupMode(ctx context.Context, client *http.Client, groupID string, newMode string, rollback bool) ([]device_manager.GroupMemberResult, error)
+}
+
+type Schedule struct {
+	ID       string    `json:"id"`
+	Cron     string    `json:"cron"`
+	TimeZone string    `json:"time_zone"`
+	DeviceID string    `json:"device_id,omitempty"`
+	GroupID  string    `json:"group_id,omitempty"`
+	Mode     string    `json:"mode"`
+	Rollback bool      `json:"rollback,omitempty"`
+	Holidays []string  `json:"holidays,omitempty"`
+	NextRun  time.Time `json:"next_run"`
+	location *time.Location
+	spec     cron.Schedule
+	holidays map[string]bool
+}
+
+type Scheduler struct {
+	manager   ModeChanger
+	client    *http.Client
+	history   *events.History
+	schedules map[string]*Schedule
+	holidays  map[string]bool
+	mutex     sync.Mutex
+	wakeup    chan struct{}
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// New creates a scheduler, holidays are skipped by every schedule.
+func New(manager ModeChanger, client *http.Client, history *events.History, holidays []string) (*Scheduler, error) {
+	scheduler := &Scheduler{manager: manager, client: client, history: history, schedules: make(map[string]*Schedule), wakeup: make(chan struct{}, 1), now: time.Now}
+	holidayMap, holidaysErr := parseHolidays(holidays)
+	if holidaysErr != nil {
+		return nil, holidaysErr
+	}
+	scheduler.holidays = holidayMap
+	return scheduler, nil
+}
+
+func parseHolidays(holidays []string) (map[string]bool, error) {
+	holidayMap := make(map[string]bool)
+	for _, holiday := range holidays {
+		if _, parseErr := time.Parse(dateLayout, holiday); parseErr != nil {
+			errorString := fmt.Sprintf("Holiday '%s' is not a valid YYYY-MM-DD date.", holiday)
+			return nil, errors.New(errorString)
+		}
+		holidayMap[holiday] = true
+	}
+	return holidayMap, nil
+}
+
+// AddSchedule validates schedule and computes its next run.
+func (scheduler *Scheduler) AddSchedule(schedule Schedule) (Schedule, error) {
+	if schedule.ID == "" {
+		return schedule, errors.New("Schedule id is required.")
+	}
+	if (schedule.DeviceID == "") == (schedule.GroupID == "") {
+		errorString := fmt.Sprintf("Schedule '%s' must target either a device or a group.", schedule.ID)
+		return schedule, errors.New(errorString)
+	}
+	if schedule.DeviceID != "" && !scheduler.manager.HasDevice(schedule.DeviceID) {
+		errorString := fmt.Sprintf("Device id '%s' is not a managed device.", schedule.DeviceID)
+		return schedule, errors.New(errorString)
+	}
+	if schedule.GroupID != "" && !scheduler.manager.HasGroup(schedule.GroupID) {
+		errorString := fmt.Sprintf("Group '%s' does not exist.", schedule.GroupID)
+		return schedule, errors.New(errorString)
+	}
+	if _, ok := device_manager.AlarmModeMap[schedule.Mode]; !ok {
+		errorString := fmt.Sprintf("Alarm mode '%s' is not defined.", schedule.Mode)
+		return schedule, errors.New(errorString)
+	}
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = "Local"
+	}
+	location, locationErr := time.LoadLocation(schedule.TimeZone)
+	if locationErr != nil {
+		errorString := fmt.Sprintf("Schedule '%s' time zone '%s' is not valid.", schedule.ID, schedule.TimeZone)
+		return schedule, errors.New(errorString)
+	}
+	schedule.location = location
+	spec, specErr := cron.ParseStandard(schedule.Cron)
+	if specErr != nil || strings.HasPrefix(schedule.Cron, "CRON_TZ=") || strings.HasPrefix(schedule.Cron, "TZ=") {
+		errorString := fmt.Sprintf("Schedule '%s' cron expression '%s' is not valid.", schedule.ID, schedule.Cron)
+		return schedule, errors.New(errorString)
+	}
+	schedule.spec = spec
+	holidays, holidaysErr := parseHolidays(schedule.Holidays)
+	if holidaysErr != nil {
+		return schedule, holidaysErr
+	}
+	schedule.holidays = holidays
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if _, ok := scheduler.schedules[schedule.ID]; ok {
+		errorString := fmt.Sprintf("Schedule '%s' already exists.", schedule.ID)
+		return schedule, errors.New(errorString)
+	}
+	nextRun, nextRunErr := scheduler.nextRun(&schedule, scheduler.now())
+	if nextRunErr != nil {
+		return schedule, nextRunErr
+	}
+	schedule.NextRun = nextRun
+	scheduler.schedules[schedule.ID] = &schedule
+	scheduler.notify()
+	return schedule, nil
+}
+
+func (scheduler *Scheduler) RemoveSchedule(scheduleID string) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if _, ok := scheduler.schedules[scheduleID]; !ok {
+		return false
+	}
+	delete(scheduler.schedules, scheduleID)
+	scheduler.notify()
+	return true
+}
+
+// Schedules returns every schedule sorted by next run.
+func (scheduler *Scheduler) Schedules() []Schedule {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	schedules := []Schedule{}
+	for _, schedule := range scheduler.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].NextRun.Equal(schedules[j].NextRun) {
+			return schedules[i].ID < schedules[j].ID
+		}
+		return schedules[i].NextRun.Before(schedules[j].NextRun)
+	})
+	return schedules
+}
+
+func (scheduler *Scheduler) GetSchedule(scheduleID string) (Schedule, bool) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	schedule, ok := scheduler.schedules[scheduleID]
+	if !ok {
+		return Schedule{}, false
+	}
+	return *schedule, true
+}
+
+// nextRun returns the first run after the given time which is not a holiday
+// in the schedule time zone.
+func (scheduler *Scheduler) nextRun(schedule *Schedule, after time.Time) (time.Time, error) {
+	next := after.In(schedule.location)
+	for skips := 0; skips < maxHolidaySkips; skips++ {
+		next = schedule.spec.Next(next)
+		if next.IsZero() {
+			break
+		}
+		date := next.Format(dateLayout)
+		if !scheduler.holidays[date] && !schedule.holidays[date] {
+			return next, nil
+		}
+	}
+	errorString := fmt.Sprintf("Schedule '%s' has no next run.", schedule.ID)
+	return time.Time{}, errors.New(errorString)
+}
+
+func (scheduler *Scheduler) notify() {
+	select {
+	case scheduler.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Run executes schedules when they are due until ctx is done.
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	for {
+		wait := time.Hour
+		if schedules := scheduler.Schedules(); len(schedules) > 0 {
+			wait = schedules[0].NextRun.Sub(scheduler.now())
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-scheduler.wakeup:
+			timer.Stop()
+		case <-timer.C:
+			scheduler.RunDue(ctx)
+		}
+	}
+}
+
+// RunDue executes every schedule whose next run has passed, mode changes
+// are cancelled when ctx is done.
+func (scheduler *Scheduler) RunDue(ctx context.Context) {
+	now := scheduler.now()
+	var due []Schedule
+	scheduler.mutex.Lock()
+	for _, schedule := range scheduler.schedules {
+		if schedule.NextRun.After(now) {
+			continue
+		}
+		due = append(due, *schedule)
+		nextRun, nextRunErr := scheduler.nextRun(schedule, now)
+		if nextRunErr != nil {
+			logger.Error("Next run could not be computed.", "error", nextRunErr)
+			delete(scheduler.schedules, schedule.ID)
+		}
+		schedule.NextRun = nextRun
+	}
+	scheduler.mutex.Unlock()
+
+	for _, schedule := range due {
+		scheduler.execute(ctx, schedule)
+	}
+}
+
+func (scheduler *Scheduler) execute(ctx context.Context, schedule Schedule) {
+	event := events.Event{Type: events.ScheduleExecuted, Source: "schedule " + schedule.ID, DeviceID: schedule.DeviceID, GroupID: schedule.GroupID, Success: true}
+	if schedule.DeviceID != "" {
+		logger.Info("Schedule changing device mode.", "schedule", schedule.ID, "device", schedule.DeviceID, "mode", schedule.Mode)
+		if changeModeErr := scheduler.manager.ChangeMode(ctx, scheduler.client, schedule.DeviceID, schedule.Mode); changeModeErr != nil {
+			event.Success = false
+			event.Message = changeModeErr.Error()
+		}
+	} else {
+		logger.Info("Schedule changing group mode.", "schedule", schedule.ID, "group", schedule.GroupID, "mode", schedule.Mode)
+		results, changeModeErr := scheduler.manager.ChangeGroupMode(ctx, scheduler.client, schedule.GroupID, schedule.Mode, schedule.Rollback)
+		if changeModeErr != nil {
+			event.Success = false
+			event.Message = changeModeErr.Error()
+		}
+		for _, result := range results {
+			if !result.Success {
+				event.Success = false
+				event.Message = "Some group members failed to change mode."
+			}
+		}
+	}
+	if event.Success {
+		event.Message = fmt.Sprintf("Mode changed to '%s'.", schedule.Mode)
+	} else {
+		logger.Error("Schedule failed.", "schedule", schedule.ID, "error", event.Message)
+	}
+	if scheduler.history != nil {
+		scheduler.history.Record(event)
+	}
+}
+
+func (scheduler *Scheduler) Routes() chi.Router {
+	router := chi.NewRouter()
+	router.Get("/", scheduler.ListSchedules)
+	router.Post("/", scheduler.CreateSchedule)
+	router.Route("/{id}", func(r chi.Router) {
+		r.Use(device_manager.DeviceCtx)
+		r.Get("/", scheduler.ShowSchedule)
+		r.Delete("/", scheduler.DeleteSchedule)
+	})
+	return router
+}
+
+type ScheduleListResponse struct {
+	Success bool       `json:"success"`
+	Data    []Schedule `json:"data"`
+}
+
+type ScheduleResponse struct {
+	Success  bool      `json:"success"`
+	Message  string    `json:"msg"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+func (scheduler *Scheduler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonResponse := ScheduleListResponse{Success: true, Data: scheduler.Schedules()}
+	jsonString, _ := json.Marshal(jsonResponse)
+	w.Write([]byte(jsonString))
+}
+
+func (scheduler *Scheduler) ShowSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	scheduleID := r.Context().Value("id").(string)
+	var response ScheduleResponse
+	if schedule, ok := scheduler.GetSchedule(scheduleID); !ok {
+		response.Message = fmt.Sprintf("Schedule '%s' does not exist.", scheduleID)
+		w.WriteHeader(404)
+	} else {
+		response.Success = true
+		response.Schedule = &schedule
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+func (scheduler *Scheduler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var response ScheduleResponse
+	var schedule Schedule
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&schedule); err != nil {
+		response.Message = "Failed to decode Response"
+		w.WriteHeader(400)
+	} else if addedSchedule, addErr := scheduler.AddSchedule(schedule); addErr != nil {
+		response.Message = addErr.Error()
+		w.WriteHeader(400)
+	} else {
+		response.Success = true
+		response.Schedule = &addedSchedule
+		w.WriteHeader(201)
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
+
+func (scheduler *Scheduler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	scheduleID := r.Context().Value("id").(string)
+	var response ScheduleResponse
+	if !scheduler.RemoveSchedule(scheduleID) {
+		response.Message = fmt.Sprintf("Schedule '%s' does not exist.", scheduleID)
+		w.WriteHeader(404)
+	} else {
+		response.Success = true
+	}
+	jsonString, _ := json.Marshal(response)
+	w.Write([]byte(jsonString))
+}
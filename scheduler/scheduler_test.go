@@ -0,0 +1,172 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/events"
+)
+
+type ModeChangerMock struct {
+	Changes   []string
+	ChangeErr error
+}
+
+func (mcm *ModeChangerMock) HasDevice(deviceID string) bool {
+	return deviceID == "office123"
+}
+
+func (mcm *ModeChangerMock) HasGroup(groupID string) bool {
+	return groupID == "premises"
+}
+
+func (mcm *ModeChangerMock) ChangeMode(ctx context.Context, client *http.Client, deviceID string, newMode string) error {
+	mcm.Changes = append(mcm.Changes, deviceID+" "+newMode)
+	return mcm.ChangeErr
+}
+
+func (mcm *ModeChangerMock) ChangeGroupMode(ctx context.Context, client *http.Client, groupID string, newMode string, rollback bool) ([]device_manager.GroupMemberResult, error) {
+	mcm.Changes = append(mcm.Changes, groupID+" "+newMode)
+	return []device_manager.GroupMemberResult{{DeviceID: "office123", Success: mcm.ChangeErr == nil}}, nil
+}
+
+func testScheduler(t *testing.T, manager ModeChanger, now time.Time, holidays []string) (*Scheduler, *events.History) {
+	history := events.NewHistory(10)
+	scheduler, schedulerErr := New(manager, &http.Client{}, history, holidays)
+	if schedulerErr != nil {
+		t.Fatalf("New scheduler should not fail, error was '%s'.", schedulerErr)
+	}
+	scheduler.now = func() time.Time { return now }
+	return scheduler, history
+}
+
+func TestNextRunTimeZone(t *testing.T) {
+
+	// Friday 18:30 UTC is 20:30 in Madrid, weekday 20:00 schedule runs on Monday
+	now := time.Date(2022, 6, 3, 18, 30, 0, 0, time.UTC)
+	scheduler, _ := testScheduler(t, &ModeChangerMock{}, now, nil)
+
+	schedule, addErr := scheduler.AddSchedule(Schedule{ID: "office_arm", Cron: "0 20 * * 1-5", TimeZone: "Europe/Madrid", DeviceID: "office123", Mode: "Armed"})
+	if addErr != nil {
+		t.Fatalf("AddSchedule should not fail, error was '%s'.", addErr)
+	}
+	expected := time.Date(2022, 6, 6, 18, 0, 0, 0, time.UTC)
+	if !schedule.NextRun.Equal(expected) {
+		t.Errorf("Next run should be %s, not %s.", expected, schedule.NextRun.UTC())
+	}
+}
+
+func TestNextRunSkipsHolidays(t *testing.T) {
+
+	now := time.Date(2022, 6, 3, 18, 30, 0, 0, time.UTC)
+	scheduler, _ := testScheduler(t, &ModeChangerMock{}, now, []string{"2022-06-06"})
+
+	schedule, _ := scheduler.AddSchedule(Schedule{ID: "office_arm", Cron: "0 20 * * 1-5", TimeZone: "Europe/Madrid", DeviceID: "office123", Mode: "Armed", Holidays: []string{"2022-06-07"}})
+	expected := time.Date(2022, 6, 8, 18, 0, 0, 0, time.UTC)
+	if !schedule.NextRun.Equal(expected) {
+		t.Errorf("Next run should skip global and schedule holidays and be %s, not %s.", expected, schedule.NextRun.UTC())
+	}
+}
+
+func TestAddScheduleInvalid(t *testing.T) {
+
+	scheduler, _ := testScheduler(t, &ModeChangerMock{}, time.Now(), nil)
+
+	invalidSchedules := map[string]Schedule{
+		"Schedule 'a' must target either a device or a group.":                {ID: "a", Cron: "0 20 * * *", DeviceID: "office123", GroupID: "premises", Mode: "Armed"},
+		"Device id 'garage' is not a managed device.":                         {ID: "a", Cron: "0 20 * * *", DeviceID: "garage", Mode: "Armed"},
+		"Alarm mode 'Away' is not defined.":                                   {ID: "a", Cron: "0 20 * * *", GroupID: "premises", Mode: "Away"},
+		"Schedule 'a' cron expression '0 25 * * *' is not valid.":             {ID: "a", Cron: "0 25 * * *", GroupID: "premises", Mode: "Armed"},
+		"Schedule 'a' time zone 'Mars/Olympus' is not valid.":                 {ID: "a", Cron: "0 20 * * *", TimeZone: "Mars/Olympus", GroupID: "premises", Mode: "Armed"},
+		"Holiday '25/12/2022' is not a valid YYYY-MM-DD date.":                {ID: "a", Cron: "0 20 * * *", GroupID: "premises", Mode: "Armed", Holidays: []string{"25/12/2022"}},
+		"Schedule 'a' cron expression 'CRON_TZ=UTC 0 20 * * *' is not valid.": {ID: "a", Cron: "CRON_TZ=UTC 0 20 * * *", GroupID: "premises", Mode: "Armed"},
+	}
+	for expectedError, schedule := range invalidSchedules {
+		_, addErr := scheduler.AddSchedule(schedule)
+		if addErr == nil || addErr.Error() != expectedError {
+			t.Errorf("AddSchedule error should be \"%s\" but error was '%v'.", expectedError, addErr)
+		}
+	}
+}
+
+func TestRunDue(t *testing.T) {
+
+	manager := &ModeChangerMock{}
+	now := time.Date(2022, 6, 6, 17, 59, 0, 0, time.UTC)
+	scheduler, history := testScheduler(t, manager, now, nil)
+	scheduler.AddSchedule(Schedule{ID: "office_arm", Cron: "0 20 * * 1-5", TimeZone: "Europe/Madrid", DeviceID: "office123", Mode: "Armed"})
+	scheduler.AddSchedule(Schedule{ID: "premises_disarm", Cron: "30 7 * * 1-5", TimeZone: "Europe/Madrid", GroupID: "premises", Mode: "Disarmed"})
+
+	scheduler.RunDue(context.Background())
+	if len(manager.Changes) != 0 {
+		t.Errorf("No schedule should run before its time, %v were run.", manager.Changes)
+	}
+
+	scheduler.now = func() time.Time { return now.Add(time.Minute) }
+	scheduler.RunDue(context.Background())
+	if len(manager.Changes) != 1 || manager.Changes[0] != "office123 Armed" {
+		t.Errorf("office_arm schedule should have been run, changes were %v.", manager.Changes)
+	}
+	schedule, _ := scheduler.GetSchedule("office_arm")
+	if !schedule.NextRun.Equal(time.Date(2022, 6, 7, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("office_arm next run should be next day, not %s.", schedule.NextRun.UTC())
+	}
+
+	recorded := history.Recent(10, events.Event{Type: events.ScheduleExecuted})
+	if len(recorded) != 1 || !recorded[0].Success || recorded[0].Source != "schedule office_arm" || recorded[0].DeviceID != "office123" {
+		t.Errorf("Schedule execution should have been recorded, events were %+v.", recorded)
+	}
+}
+
+func TestRunDueFailed(t *testing.T) {
+
+	manager := &ModeChangerMock{ChangeErr: errors.New("Device has not retrieved devices info yet.")}
+	now := time.Date(2022, 6, 7, 5, 30, 0, 0, time.UTC)
+	scheduler, history := testScheduler(t, manager, now.Add(-time.Minute), nil)
+	scheduler.AddSchedule(Schedule{ID: "premises_disarm", Cron: "30 7 * * 1-5", TimeZone: "Europe/Madrid", GroupID: "premises", Mode: "Disarmed"})
+
+	scheduler.now = func() time.Time { return now }
+	scheduler.RunDue(context.Background())
+
+	recorded := history.Recent(10, events.Event{})
+	if len(recorded) != 1 || recorded[0].Success || recorded[0].GroupID != "premises" {
+		t.Errorf("Failed schedule execution should have been recorded, events were %+v.", recorded)
+	}
+}
+
+func TestCreateAndListSchedules(t *testing.T) {
+
+	scheduler, _ := testScheduler(t, &ModeChangerMock{}, time.Date(2022, 6, 3, 18, 30, 0, 0, time.UTC), nil)
+	router := scheduler.Routes()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"id": "office_arm", "cron": "0 20 * * 1-5", "time_zone": "Europe/Madrid", "device_id": "office123", "mode": "Armed"}`)))
+	if recorder.Code != 201 {
+		t.Fatalf("POST schedule should return 201, returned %d '%s'.", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(recorder.Body.String(), `"next_run":"2022-06-06T20:00:00+02:00"`) {
+		t.Errorf("Schedule list should show next run, returned '%s'.", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/office_arm", nil))
+	if recorder.Code != 200 {
+		t.Errorf("DELETE schedule should return 200, not %d.", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/office_arm", nil))
+	if recorder.Code != 404 {
+		t.Errorf("GET deleted schedule should return 404, not %d.", recorder.Code)
+	}
+}
@@ -0,0 +1,51 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// Listeners returns sockets passed by systemd socket activation, none when
+// the service was not activated by a socket. Environment variables are
+// unset so child processes don't inherit them.
+func Listeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, parseErr := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if parseErr != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var listeners []net.Listener
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fd), name)
+		listener, listenerErr := net.FileListener(file)
+		// FileListener duplicates the descriptor
+		file.Close()
+		if listenerErr != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("Socket %s passed by systemd can't be used, error was '%w'.", name, listenerErr)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
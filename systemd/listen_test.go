@@ -0,0 +1,67 @@
+package systemd
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestListenersWithoutActivation(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, listenErr := Listeners()
+	if listenErr != nil || len(listeners) != 0 {
+		t.Errorf("Sockets of another process should be ignored, %d were returned and error was '%v'.", len(listeners), listenErr)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("LISTEN_FDS should be unset.")
+	}
+}
+
+// TestListeners passes a socket to a child process the way systemd does.
+func TestListeners(t *testing.T) {
+	if os.Getenv("ALARM_MANAGER_TEST_ACTIVATION") == "1" {
+		// LISTEN_PID can't be known before the child is started
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listeners, listenErr := Listeners()
+		if listenErr != nil || len(listeners) != 1 {
+			t.Fatalf("Passed socket should be returned, %d were returned and error was '%v'.", len(listeners), listenErr)
+		}
+		conn, acceptErr := listeners[0].Accept()
+		if acceptErr != nil {
+			t.Fatalf("Connection should be accepted, error was '%s'.", acceptErr)
+		}
+		conn.Write([]byte("activated\n"))
+		conn.Close()
+		return
+	}
+
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatalf("Socket should be opened, error was '%s'.", listenErr)
+	}
+	defer listener.Close()
+	file, _ := listener.(*net.TCPListener).File()
+	defer file.Close()
+
+	command := exec.Command(os.Args[0], "-test.run=^TestListeners$")
+	command.Env = append(os.Environ(), "ALARM_MANAGER_TEST_ACTIVATION=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=api")
+	command.ExtraFiles = []*os.File{file}
+	if startErr := command.Start(); startErr != nil {
+		t.Fatalf("Child process should start, error was '%s'.", startErr)
+	}
+	defer command.Wait()
+
+	conn, dialErr := net.Dial("tcp", listener.Addr().String())
+	if dialErr != nil {
+		t.Fatalf("Passed socket should accept connections, error was '%s'.", dialErr)
+	}
+	defer conn.Close()
+	line, readErr := bufio.NewReader(conn).ReadString('\n')
+	if readErr != nil || line != "activated\n" {
+		t.Errorf("Child process should answer through passed socket, answer was %q and error was '%v'.", line, readErr)
+	}
+}
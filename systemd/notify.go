@@ -0,0 +1,55 @@
+// Package systemd implements the sd_notify protocol used by services of
+// Type=notify to report readiness, status and watchdog pings.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns the state showing text in systemctl status.
+func Status(text string) string {
+	return "STATUS=" + text
+}
+
+// Notify sends state to the socket set in $NOTIFY_SOCKET, several states
+// can be sent at once separated by newlines. Nothing is sent when service
+// is not run by systemd.
+func Notify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	// Abstract sockets are prefixed by @
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+	conn, dialErr := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if dialErr != nil {
+		return dialErr
+	}
+	defer conn.Close()
+	_, writeErr := conn.Write([]byte(state))
+	return writeErr
+}
+
+// WatchdogInterval returns the watchdog timeout set in $WATCHDOG_USEC, 0 when
+// watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, parseErr := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if parseErr != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
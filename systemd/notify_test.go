@@ -0,0 +1,59 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, listenErr := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if listenErr != nil {
+		t.Fatalf("Notify socket should be created, error was '%s'.", listenErr)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socketPath)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if notifyErr := Notify(Ready + "\n" + Status("1 device, 1 online")); notifyErr != nil {
+		t.Fatalf("Notify should not fail, error was '%s'.", notifyErr)
+	}
+	buffer := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	read, readErr := conn.Read(buffer)
+	if readErr != nil {
+		t.Fatalf("State should be received, error was '%s'.", readErr)
+	}
+	if state := string(buffer[:read]); state != "READY=1\nSTATUS=1 device, 1 online" {
+		t.Errorf("Received state should be READY and STATUS, it was '%s'.", state)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if notifyErr := Notify(Ready); notifyErr != nil {
+		t.Errorf("Notify should do nothing without NOTIFY_SOCKET, error was '%s'.", notifyErr)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Errorf("Watchdog should be disabled without WATCHDOG_USEC, interval was %s.", interval)
+	}
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	if interval := WatchdogInterval(); interval != 30*time.Second {
+		t.Errorf("Watchdog interval should be 30s, it was %s.", interval)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Errorf("Watchdog of another process should be ignored, interval was %s.", interval)
+	}
+}
@@ -0,0 +1,211 @@
+package tuyadevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrBudgetExhausted = errors.New("request budget exhausted")
+
+// Budget limits requests sent to Tuya cloud with the credentials of a
+// client ID. Usage is counted per UTC day and month, limits set to 0 are
+// not enforced.
+type Budget struct {
+	ClientID          string
+	requestsPerSecond float64
+	dailyLimit        int
+	monthlyLimit      int
+	day               string
+	month             string
+	dailyUsed         int
+	monthlyUsed       int
+	next              time.Time
+	clock             func() time.Time
+	mutex             sync.Mutex
+}
+
+// BudgetUsage is the current usage of a budget.
+type BudgetUsage struct {
+	ClientID          string  `json:"client_id"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	DailyLimit        int     `json:"daily_limit"`
+	DailyUsed         int     `json:"daily_used"`
+	MonthlyLimit      int     `json:"monthly_limit"`
+	MonthlyUsed       int     `json:"monthly_used"`
+	Remaining         float64 `json:"remaining"`
+}
+
+var (
+	budgets      = make(map[string]*Budget)
+	budgetsMutex sync.Mutex
+)
+
+// BudgetFor returns the budget of clientID, budgets without limits are
+// created on first use so usage is always tracked.
+func BudgetFor(clientID string) *Budget {
+	budgetsMutex.Lock()
+	defer budgetsMutex.Unlock()
+	budget, ok := budgets[clientID]
+	if !ok {
+		budget = &Budget{ClientID: clientID, clock: time.Now}
+		budgets[clientID] = budget
+	}
+	return budget
+}
+
+// LookupBudget returns the budget of clientID, ok is false when no request
+// has been sent with it and no limits have been set.
+func LookupBudget(clientID string) (budget *Budget, ok bool) {
+	budgetsMutex.Lock()
+	defer budgetsMutex.Unlock()
+	budget, ok = budgets[clientID]
+	return budget, ok
+}
+
+// BudgetUsages returns usage of every budget sorted by client ID.
+func BudgetUsages() []BudgetUsage {
+	budgetsMutex.Lock()
+	clientIDs := make([]string, 0, len(budgets))
+	for clientID := range budgets {
+		clientIDs = append(clientIDs, clientID)
+	}
+	budgetsMutex.Unlock()
+	sort.Strings(clientIDs)
+	usages := make([]BudgetUsage, 0, len(clientIDs))
+	for _, clientID := range clientIDs {
+		usages = append(usages, BudgetFor(clientID).Usage())
+	}
+	return usages
+}
+
+// SetLimits sets how many requests per second, day and month can be sent.
+func (budget *Budget) SetLimits(requestsPerSecond float64, dailyLimit int, monthlyLimit int) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.requestsPerSecond = requestsPerSecond
+	budget.dailyLimit = dailyLimit
+	budget.monthlyLimit = monthlyLimit
+}
+
+// SetUsage replaces requests counted in current day and month, usage is
+// only kept in memory so it has to be restored after restarts.
+func (budget *Budget) SetUsage(dailyUsed int, monthlyUsed int) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.resetPeriods()
+	budget.dailyUsed = dailyUsed
+	budget.monthlyUsed = monthlyUsed
+}
+
+// resetPeriods starts counting again when day or month changes, mutex
+// must be held.
+func (budget *Budget) resetPeriods() {
+	now := budget.clock().UTC()
+	if day := now.Format("2006-01-02"); day != budget.day {
+		budget.day = day
+		budget.dailyUsed = 0
+	}
+	if month := now.Format("2006-01"); month != budget.month {
+		budget.month = month
+		budget.monthlyUsed = 0
+	}
+}
+
+// Take counts a request, waiting until the requests per second limit
+// allows to send it. It fails without waiting when daily or monthly budget
+// is exhausted, requests cancelled while waiting are not counted.
+func (budget *Budget) Take(ctx context.Context) error {
+	budget.mutex.Lock()
+	budget.resetPeriods()
+	if budget.dailyLimit > 0 && budget.dailyUsed >= budget.dailyLimit {
+		budget.mutex.Unlock()
+		return fmt.Errorf("Client '%s' has used its daily budget of %d requests, error was '%w'.", budget.ClientID, budget.dailyLimit, ErrBudgetExhausted)
+	}
+	if budget.monthlyLimit > 0 && budget.monthlyUsed >= budget.monthlyLimit {
+		budget.mutex.Unlock()
+		return fmt.Errorf("Client '%s' has used its monthly budget of %d requests, error was '%w'.", budget.ClientID, budget.monthlyLimit, ErrBudgetExhausted)
+	}
+	budget.dailyUsed++
+	budget.monthlyUsed++
+	var wait time.Duration
+	if budget.requestsPerSecond > 0 {
+		now := budget.clock()
+		if budget.next.Before(now) {
+			budget.next = now
+		}
+		wait = budget.next.Sub(now)
+		budget.next = budget.next.Add(time.Duration(float64(time.Second) / budget.requestsPerSecond))
+	}
+	budget.mutex.Unlock()
+	if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+		budget.mutex.Lock()
+		budget.dailyUsed--
+		budget.monthlyUsed--
+		budget.mutex.Unlock()
+		return sleepErr
+	}
+	return nil
+}
+
+// sleepContext waits for duration unless ctx is done before.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// remaining returns the fraction left of the most used limit, 1 when there
+// are no limits. Mutex must be held.
+func (budget *Budget) remaining() float64 {
+	remaining := 1.0
+	for _, period := range [][2]int{{budget.dailyLimit, budget.dailyUsed}, {budget.monthlyLimit, budget.monthlyUsed}} {
+		limit, used := period[0], period[1]
+		if limit <= 0 {
+			continue
+		}
+		if left := float64(limit-used) / float64(limit); left < remaining {
+			remaining = left
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+func (budget *Budget) Usage() BudgetUsage {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.resetPeriods()
+	return BudgetUsage{ClientID: budget.ClientID, RequestsPerSecond: budget.requestsPerSecond, DailyLimit: budget.dailyLimit, DailyUsed: budget.dailyUsed, MonthlyLimit: budget.monthlyLimit, MonthlyUsed: budget.monthlyUsed, Remaining: budget.remaining()}
+}
+
+// PollInterval stretches base as budget runs low: twice when less than a
+// half remains, four times under a quarter and eight times under a tenth.
+func (budget *Budget) PollInterval(base time.Duration) time.Duration {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.resetPeriods()
+	remaining := budget.remaining()
+	switch {
+	case remaining < 0.1:
+		return base * 8
+	case remaining < 0.25:
+		return base * 4
+	case remaining < 0.5:
+		return base * 2
+	}
+	return base
+}
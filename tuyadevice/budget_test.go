@@ -0,0 +1,92 @@
+package tuyadevice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBudgetLimits(t *testing.T) {
+	day := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	budget := BudgetFor("budget-limits")
+	budget.clock = func() time.Time { return day }
+	budget.SetLimits(0, 2, 3)
+	for request := 0; request < 2; request++ {
+		if takeErr := budget.Take(context.Background()); takeErr != nil {
+			t.Errorf("Requests within budget should not fail, error was '%s'.", takeErr)
+		}
+	}
+	takeErr := budget.Take(context.Background())
+	if !errors.Is(takeErr, ErrBudgetExhausted) || takeErr.Error() != "Client 'budget-limits' has used its daily budget of 2 requests, error was 'request budget exhausted'." {
+		t.Errorf("Requests over daily budget should fail, error was '%v'.", takeErr)
+	}
+
+	// next day daily usage starts again but monthly usage is kept
+	day = day.AddDate(0, 0, 1)
+	if takeErr := budget.Take(context.Background()); takeErr != nil {
+		t.Errorf("Requests should be allowed next day, error was '%s'.", takeErr)
+	}
+	if takeErr := budget.Take(context.Background()); !errors.Is(takeErr, ErrBudgetExhausted) {
+		t.Errorf("Requests over monthly budget should fail, error was '%v'.", takeErr)
+	}
+	if usage := budget.Usage(); usage.DailyUsed != 1 || usage.MonthlyUsed != 3 || usage.Remaining != 0 {
+		t.Errorf("Budget usage is not valid: %+v.", usage)
+	}
+}
+
+func TestBudgetRequestsPerSecond(t *testing.T) {
+	budget := BudgetFor("budget-rps")
+	budget.SetLimits(20, 0, 0)
+	start := time.Now()
+	for request := 0; request < 3; request++ {
+		budget.Take(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests at 20 per second should take 100ms, they took %s.", elapsed)
+	}
+}
+
+func TestBudgetPollInterval(t *testing.T) {
+	budget := BudgetFor("budget-poll")
+	budget.SetLimits(0, 0, 100)
+	intervals := map[int]time.Duration{10: 20 * time.Second, 60: 40 * time.Second, 80: 80 * time.Second, 95: 160 * time.Second}
+	for used, interval := range intervals {
+		budget.SetUsage(0, used)
+		if pollInterval := budget.PollInterval(20 * time.Second); pollInterval != interval {
+			t.Errorf("Poll interval with %d%% of budget used should be %s, it was %s.", used, interval, pollInterval)
+		}
+	}
+}
+
+func TestBudgetExhaustedRequest(t *testing.T) {
+	transport := &SequenceRoundTripperMock{Bodies: []string{`{"result":{"online":true},"success":true}`}}
+	device := TuyaDevice{Name: "Test", ClientID: "budget-device", Token: "token", TokenExpireTime: time.Now().Unix() + 3600}
+	device.Budget().SetLimits(0, 1, 0)
+
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), &http.Client{Transport: transport}); deviceInfoErr != nil {
+		t.Errorf("First request should not fail, error was '%s'.", deviceInfoErr)
+	}
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), &http.Client{Transport: transport}); !errors.Is(deviceInfoErr, ErrBudgetExhausted) {
+		t.Errorf("Second request should exhaust budget, error was '%v'.", deviceInfoErr)
+	}
+	if transport.Requests != 1 {
+		t.Errorf("Requests over budget should not be sent, %d were sent.", transport.Requests)
+	}
+}
+
+func TestBudgetTakeCancelled(t *testing.T) {
+	budget := BudgetFor("budget-cancelled")
+	budget.SetLimits(1, 0, 0)
+	budget.Take(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if takeErr := budget.Take(ctx); !errors.Is(takeErr, context.DeadlineExceeded) {
+		t.Errorf("Take should stop waiting when context is done, error was '%v'.", takeErr)
+	}
+	if usage := budget.Usage(); usage.DailyUsed != 1 {
+		t.Errorf("Cancelled requests should not be counted, %d were counted.", usage.DailyUsed)
+	}
+}
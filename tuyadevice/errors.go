@@ -0,0 +1,67 @@
+package tuyadevice
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Tuya cloud error codes.
+const (
+	CodeTokenInvalid     = 1010
+	CodeTokenExpired     = 1011
+	CodePermissionDenied = 1106
+	CodeParamIllegal     = 1109
+	CodeRateLimited      = 1110
+	CodeDeviceOffline    = 2001
+)
+
+var (
+	ErrTokenInvalid     = errors.New("token invalid")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrDeviceOffline    = errors.New("device offline")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrParamIllegal     = errors.New("param illegal")
+)
+
+var codeErrors = map[int]error{
+	CodeTokenInvalid:     ErrTokenInvalid,
+	CodeTokenExpired:     ErrTokenExpired,
+	CodePermissionDenied: ErrPermissionDenied,
+	CodeParamIllegal:     ErrParamIllegal,
+	CodeRateLimited:      ErrRateLimited,
+	CodeDeviceOffline:    ErrDeviceOffline,
+}
+
+// APIError is a failure reported by Tuya cloud, errors.Is matches it with
+// the error of its code.
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
+func (apiError *APIError) Error() string {
+	return apiError.Message
+}
+
+func (apiError *APIError) Unwrap() error {
+	return codeErrors[apiError.Code]
+}
+
+// isTokenError reports whether err is solved retrieving a new token.
+func isTokenError(err error) bool {
+	return errors.Is(err, ErrTokenInvalid) || errors.Is(err, ErrTokenExpired)
+}
+
+// checkResponse returns the APIError of unsuccessful responses. Responses
+// which are not JSON are left to callers.
+func checkResponse(body []byte) error {
+	var response struct {
+		APIError
+		Success bool `json:"success"`
+	}
+	if json.Unmarshal(body, &response) != nil || response.Success {
+		return nil
+	}
+	return &APIError{Code: response.Code, Message: response.Message}
+}
@@ -0,0 +1,81 @@
+package tuyadevice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// SequenceRoundTripperMock answers requests with Bodies in order.
+type SequenceRoundTripperMock struct {
+	Bodies   []string
+	Requests int
+}
+
+func (srtm *SequenceRoundTripperMock) RoundTrip(*http.Request) (*http.Response, error) {
+	body := srtm.Bodies[srtm.Requests]
+	srtm.Requests++
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+const tokenJSON = `{"result":{"access_token":"newtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`
+const tokenInvalidJSON = `{"code":1010,"msg":"token invalid","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`
+
+func TestCheckResponse(t *testing.T) {
+	if checkErr := checkResponse([]byte(`{"result":true,"success":true}`)); checkErr != nil {
+		t.Errorf("Successful responses should not fail, error was '%s'.", checkErr)
+	}
+	checkErr := checkResponse([]byte(`{"code":2001,"msg":"device is offline","success":false}`))
+	var apiErr *APIError
+	if !errors.As(checkErr, &apiErr) || apiErr.Code != CodeDeviceOffline || checkErr.Error() != "device is offline" {
+		t.Errorf("Failed responses should return APIError, error was '%v'.", checkErr)
+	}
+	if !errors.Is(checkErr, ErrDeviceOffline) || errors.Is(checkErr, ErrRateLimited) {
+		t.Errorf("APIError should match only the error of its code.")
+	}
+	if checkErr := checkResponse([]byte(`{"code":1234,"msg":"unknown","success":false}`)); checkErr == nil || errors.Unwrap(checkErr) != nil {
+		t.Errorf("Unknown codes should fail without matching any error, error was '%v'.", checkErr)
+	}
+}
+
+func TestGetTokenRejected(t *testing.T) {
+	client := &http.Client{Transport: &SequenceRoundTripperMock{Bodies: []string{`{"code":1004,"msg":"sign invalid","success":false,"t":1653182849837}`}}}
+	device := TuyaDevice{Name: "Test"}
+
+	if tokenErr := device.RetrieveToken(context.Background(), client); tokenErr == nil || tokenErr.Error() != "sign invalid" {
+		t.Errorf("Rejected token requests should fail, error was '%v'.", tokenErr)
+	}
+	if device.Token != "" || device.TokenExpireTime != 0 {
+		t.Errorf("Rejected token requests should not set a token.")
+	}
+}
+
+func TestGetDeviceInfoRetriesWithNewToken(t *testing.T) {
+	transport := &SequenceRoundTripperMock{Bodies: []string{tokenInvalidJSON, tokenJSON, `{"result":{"online":true},"success":true}`}}
+	device := TuyaDevice{Name: "Test", Token: "oldtoken", TokenExpireTime: 1}
+
+	deviceInfo, deviceInfoErr := device.GetDeviceInfo(context.Background(), &http.Client{Transport: transport})
+	if deviceInfoErr != nil || !strings.Contains(string(deviceInfo), `"online":true`) {
+		t.Errorf("Device info should be retrieved with a new token, error was '%v'.", deviceInfoErr)
+	}
+	if device.Token != "newtoken" || transport.Requests != 3 {
+		t.Errorf("Token should be renewed once, token is '%s' after %d requests.", device.Token, transport.Requests)
+	}
+}
+
+func TestChangeModeRetriesOnce(t *testing.T) {
+	transport := &SequenceRoundTripperMock{Bodies: []string{tokenInvalidJSON, tokenJSON, tokenInvalidJSON}}
+	device := TuyaDevice{Name: "Test", Token: "oldtoken", TokenExpireTime: 1}
+
+	changeModeErr := device.ChangeMode(context.Background(), &http.Client{Transport: transport}, "arm")
+	if !errors.Is(changeModeErr, ErrTokenInvalid) || changeModeErr.Error() != "Device 'Test' failed to change state to arm, error was 'token invalid'." {
+		t.Errorf("Mode change should fail with token invalid error, error was '%v'.", changeModeErr)
+	}
+	if transport.Requests != 3 {
+		t.Errorf("Mode change should be retried only once, %d requests were sent.", transport.Requests)
+	}
+}
@@ -0,0 +1,219 @@
+package tuyadevice
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// SimulatedDeviceType devices report 99AST status codes.
+const SimulatedDeviceType = "simulated"
+
+var simulatedModes = map[string]bool{"arm": true, "disarmed": true, "home": true, "sos": true}
+
+// SimulatedDevice keeps alarm state in memory instead of using Tuya cloud.
+// Every call waits Latency and fails with FailureRate probability, from 0
+// to 1.
+type SimulatedDevice struct {
+	Name        string
+	DeviceID    string
+	Latency     time.Duration
+	FailureRate float64
+	mode        string
+	firing      bool
+	online      bool
+	reason      string
+	sensors     map[string]string
+	mutex       sync.Mutex
+}
+
+// SimulatedState is the state of a simulated device.
+type SimulatedState struct {
+	Mode        string            `json:"mode"`
+	Firing      bool              `json:"firing"`
+	Online      bool              `json:"online"`
+	Reason      string            `json:"reason,omitempty"`
+	Sensors     map[string]string `json:"sensors"`
+	Latency     string            `json:"latency"`
+	FailureRate float64           `json:"failure_rate"`
+}
+
+// NewSimulatedDevice creates an online simulated device in mode, disarmed
+// when empty, whose sensors are all in normal state.
+func NewSimulatedDevice(name string, deviceID string, mode string, sensors []string) *SimulatedDevice {
+	if mode == "" {
+		mode = "disarmed"
+	}
+	device := &SimulatedDevice{Name: name, DeviceID: deviceID, mode: mode, online: true, sensors: make(map[string]string)}
+	for _, sensor := range sensors {
+		device.sensors[sensor] = "normal"
+	}
+	return device
+}
+
+func (device *SimulatedDevice) GetDeviceType() string {
+	return SimulatedDeviceType
+}
+
+func (device *SimulatedDevice) GetDeviceID() string {
+	return device.DeviceID
+}
+
+func (device *SimulatedDevice) GetDeviceName() string {
+	return device.Name
+}
+
+// simulateCall waits device latency and returns an error when a failure is
+// injected.
+func (device *SimulatedDevice) simulateCall(ctx context.Context, action string) error {
+	device.mutex.Lock()
+	latency, failureRate := device.Latency, device.FailureRate
+	device.mutex.Unlock()
+	if sleepErr := sleepContext(ctx, latency); sleepErr != nil {
+		return sleepErr
+	}
+	if failureRate > 0 && rand.Float64() < failureRate {
+		errorString := fmt.Sprintf("Simulated device '%s' failed to %s.", device.Name, action)
+		return errors.New(errorString)
+	}
+	return nil
+}
+
+func (device *SimulatedDevice) RetrieveToken(ctx context.Context, client *http.Client) error {
+	return device.simulateCall(ctx, "retrieve token")
+}
+
+func encodeAlarmMessage(message string) string {
+	units := utf16.Encode([]rune(message))
+	encoded := make([]byte, 0, 2*len(units))
+	for _, unit := range units {
+		encoded = append(encoded, byte(unit>>8), byte(unit))
+	}
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+type simulatedStatus struct {
+	Code  string      `json:"code"`
+	Value interface{} `json:"value"`
+}
+
+type simulatedInfo struct {
+	Result struct {
+		Category string            `json:"category"`
+		ID       string            `json:"id"`
+		Model    string            `json:"model"`
+		Name     string            `json:"name"`
+		Online   bool              `json:"online"`
+		Status   []simulatedStatus `json:"status"`
+	} `json:"result"`
+	Success bool  `json:"success"`
+	T       int64 `json:"t"`
+}
+
+// GetDeviceInfo returns device state like Tuya cloud does for 99AST alarms.
+func (device *SimulatedDevice) GetDeviceInfo(ctx context.Context, client *http.Client) ([]byte, error) {
+	if callErr := device.simulateCall(ctx, "retrieve info"); callErr != nil {
+		return []byte(``), callErr
+	}
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	info := simulatedInfo{Success: true, T: time.Now().UnixNano() / int64(time.Millisecond)}
+	info.Result.Category = "mal"
+	info.Result.ID = device.DeviceID
+	info.Result.Model = SimulatedDeviceType
+	info.Result.Name = device.Name
+	info.Result.Online = device.online
+	masterState := "normal"
+	if device.firing {
+		masterState = "alarm"
+	}
+	info.Result.Status = []simulatedStatus{
+		{Code: "master_mode", Value: device.mode},
+		{Code: "master_state", Value: masterState},
+		{Code: "alarm_msg", Value: encodeAlarmMessage(device.reason)},
+		{Code: "charge_state", Value: true},
+		{Code: "switch_low_battery", Value: false},
+	}
+	return json.Marshal(info)
+}
+
+func (device *SimulatedDevice) ChangeMode(ctx context.Context, client *http.Client, mode string) error {
+	logger.Info("Changing simulated device mode.", "device", device.GetDeviceName(), "mode", mode)
+	if callErr := device.simulateCall(ctx, "change mode"); callErr != nil {
+		errorString := fmt.Sprintf("Device '%s' failed to change state to %s, error was '%s'.", device.GetDeviceName(), mode, callErr)
+		return errors.New(errorString)
+	}
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if !simulatedModes[mode] {
+		errorString := fmt.Sprintf("Device '%s' failed to change state to %s, error was 'param is illegal ,please check it'.", device.GetDeviceName(), mode)
+		return errors.New(errorString)
+	}
+	device.mode = mode
+	if mode == "disarmed" {
+		device.firing = false
+		device.reason = ""
+		for sensor := range device.sensors {
+			device.sensors[sensor] = "normal"
+		}
+	}
+	return nil
+}
+
+// TriggerIntrusion makes sensor detect an intrusion, device starts firing
+// unless it is disarmed.
+func (device *SimulatedDevice) TriggerIntrusion(sensor string) error {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if _, ok := device.sensors[sensor]; !ok && sensor != "" {
+		errorString := fmt.Sprintf("Simulated device '%s' has no sensor '%s'.", device.Name, sensor)
+		return errors.New(errorString)
+	}
+	if device.mode == "disarmed" {
+		errorString := fmt.Sprintf("Simulated device '%s' is disarmed.", device.Name)
+		return errors.New(errorString)
+	}
+	device.firing = true
+	device.reason = "Intrusion"
+	if sensor != "" {
+		device.sensors[sensor] = "alarm"
+		device.reason = "Intrusion " + sensor
+	}
+	return nil
+}
+
+func (device *SimulatedDevice) SetOnline(online bool) {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.online = online
+}
+
+func (device *SimulatedDevice) SetFailures(latency time.Duration, failureRate float64) {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.Latency = latency
+	device.FailureRate = failureRate
+}
+
+func (device *SimulatedDevice) State() SimulatedState {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	state := SimulatedState{Mode: device.mode, Firing: device.firing, Online: device.online, Reason: device.reason, Sensors: make(map[string]string), Latency: device.Latency.String(), FailureRate: device.FailureRate}
+	var sensors []string
+	for sensor := range device.sensors {
+		sensors = append(sensors, sensor)
+	}
+	sort.Strings(sensors)
+	for _, sensor := range sensors {
+		state.Sensors[sensor] = device.sensors[sensor]
+	}
+	return state
+}
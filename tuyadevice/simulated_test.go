@@ -0,0 +1,78 @@
+package tuyadevice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulatedDeviceInfo(t *testing.T) {
+	client := &http.Client{}
+	device := NewSimulatedDevice("Test Alarm", "simulated1", "arm", []string{"Front door"})
+
+	if triggerErr := device.TriggerIntrusion("Front door"); triggerErr != nil {
+		t.Fatalf("Armed simulated device intrusion should not fail, error was '%s'.", triggerErr)
+	}
+	deviceInfo, deviceInfoErr := device.GetDeviceInfo(context.Background(), client)
+	if deviceInfoErr != nil {
+		t.Fatalf("Simulated device info retrieval should not fail, error was '%s'.", deviceInfoErr)
+	}
+	var info simulatedInfo
+	json.Unmarshal(deviceInfo, &info)
+	status := make(map[string]interface{})
+	for _, code := range info.Result.Status {
+		status[code.Code] = code.Value
+	}
+	if !info.Success || !info.Result.Online || status["master_mode"] != "arm" || status["master_state"] != "alarm" {
+		t.Errorf("Firing armed device info should be returned, info was %s.", deviceInfo)
+	}
+	if status["alarm_msg"] != encodeAlarmMessage("Intrusion Front door") {
+		t.Errorf("Intrusion reason should be encoded in alarm_msg, info was %s.", deviceInfo)
+	}
+
+	if changeErr := device.ChangeMode(context.Background(), client, "disarmed"); changeErr != nil {
+		t.Fatalf("Simulated device mode change should not fail, error was '%s'.", changeErr)
+	}
+	state := device.State()
+	if state.Mode != "disarmed" || state.Firing || state.Sensors["Front door"] != "normal" {
+		t.Errorf("Disarming should stop firing and restore sensors, state was %+v.", state)
+	}
+}
+
+func TestSimulatedDeviceIntrusionDisarmed(t *testing.T) {
+	device := NewSimulatedDevice("Test Alarm", "simulated1", "", []string{"Front door"})
+
+	if triggerErr := device.TriggerIntrusion("Front door"); triggerErr == nil {
+		t.Errorf("Disarmed simulated device intrusion should fail.")
+	}
+	device.ChangeMode(context.Background(), &http.Client{}, "home")
+	if triggerErr := device.TriggerIntrusion("Garage"); triggerErr == nil || triggerErr.Error() != "Simulated device 'Test Alarm' has no sensor 'Garage'." {
+		t.Errorf("Unknown sensor intrusion should fail, error was '%v'.", triggerErr)
+	}
+}
+
+func TestSimulatedDeviceFailures(t *testing.T) {
+	client := &http.Client{}
+	device := NewSimulatedDevice("Test Alarm", "simulated1", "arm", nil)
+	device.SetFailures(20*time.Millisecond, 1)
+
+	start := time.Now()
+	changeErr := device.ChangeMode(context.Background(), client, "home")
+	if changeErr == nil || !strings.HasPrefix(changeErr.Error(), "Device 'Test Alarm' failed to change state to home") {
+		t.Errorf("Injected failures should make mode change fail, error was '%v'.", changeErr)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Calls should wait simulated latency, they took %s.", elapsed)
+	}
+	if device.State().Mode != "arm" {
+		t.Errorf("Failed mode changes should not change mode.")
+	}
+
+	device.SetFailures(0, 0)
+	if changeErr := device.ChangeMode(context.Background(), client, "party"); changeErr == nil {
+		t.Errorf("Unknown modes should be rejected.")
+	}
+}
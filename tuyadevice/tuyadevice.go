@@ -2,17 +2,20 @@ package tuyadevice
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/a-castellano/AlarmManager/logging"
 	"github.com/asaskevich/govalidator"
 )
 
+var logger = logging.New("tuya")
+
 type TokenResponse struct {
 	Result struct {
 		AccessToken     string `json:"access_token"`
@@ -32,13 +35,15 @@ type ChangeModeResponse struct {
 	TID       string `json:"tid"`
 }
 
+// Device is an alarm reached through client. Calls return as soon as ctx is
+// done, cancelling requests in flight.
 type Device interface {
-	GetDeviceInfo(http.Client) ([]byte, error)
+	GetDeviceInfo(context.Context, *http.Client) ([]byte, error)
 	GetDeviceID() string
-	RetrieveToken(http.Client) error
+	RetrieveToken(context.Context, *http.Client) error
 	GetDeviceType() string
 	GetDeviceName() string
-	ChangeMode(http.Client, string) error
+	ChangeMode(context.Context, *http.Client, string) error
 }
 
 type TuyaDevice struct {
@@ -65,6 +70,11 @@ func (device *TuyaDevice) GetDeviceName() string {
 	return device.Name
 }
 
+// Budget returns the request budget of device client ID.
+func (device *TuyaDevice) Budget() *Budget {
+	return BudgetFor(device.ClientID)
+}
+
 func (device *TuyaDevice) Validate() error {
 	_, err := govalidator.ValidateStruct(device)
 	if err != nil {
@@ -73,7 +83,7 @@ func (device *TuyaDevice) Validate() error {
 	return nil
 }
 
-func (device *TuyaDevice) RetrieveToken(client http.Client) error {
+func (device *TuyaDevice) RetrieveToken(ctx context.Context, client *http.Client) error {
 	var retriveNewToken bool = false
 	if device.TokenExpireTime == 0 {
 		retriveNewToken = true
@@ -81,15 +91,18 @@ func (device *TuyaDevice) RetrieveToken(client http.Client) error {
 		now := time.Now()
 		currentTimestamp := now.Unix()
 		if device.TokenExpireTime-currentTimestamp < 0 {
-			log.Println("Device " + device.Name + " token has expired, retrive new token.")
+			logger.Info("Token has expired, retrieving new token.", "device", device.Name)
 			retriveNewToken = true
 		}
 	}
 	if retriveNewToken { // New token
 		device.Token = ""
 		body := []byte(``)
-		req, _ := http.NewRequest("GET", device.Host+"/v1.0/token?grant_type=1", bytes.NewReader(body))
+		req, _ := http.NewRequestWithContext(ctx, "GET", device.Host+"/v1.0/token?grant_type=1", bytes.NewReader(body))
 
+		if budgetErr := device.Budget().Take(ctx); budgetErr != nil {
+			return budgetErr
+		}
 		device.buildHeader(req, body)
 		resp, err := client.Do(req)
 		if err != nil {
@@ -102,7 +115,10 @@ func (device *TuyaDevice) RetrieveToken(client http.Client) error {
 		if unmarshalErr != nil {
 			return unmarshalErr
 		}
-		log.Println("token GET response:", string(bs))
+		logger.Debug("Token response received.", "device", device.Name, "response", bs)
+		if apiErr := checkResponse(bs); apiErr != nil {
+			return apiErr
+		}
 		device.Token = ret.Result.AccessToken
 		now := time.Now() // current local time
 		device.TokenExpireTime = now.Unix() + int64(ret.Result.TokenExpireTime)
@@ -113,42 +129,69 @@ func (device *TuyaDevice) RetrieveToken(client http.Client) error {
 
 }
 
-func (device TuyaDevice) GetDeviceInfo(client http.Client) ([]byte, error) {
-	method := "GET"
-	body := []byte(``)
-	req, _ := http.NewRequest(method, device.Host+"/v1.0/devices/"+device.DeviceID, bytes.NewReader(body))
+// request sends a signed request to Tuya cloud and returns the response of
+// successful ones. Requests failing because of the token are sent again
+// once with a new token.
+func (device *TuyaDevice) request(ctx context.Context, client *http.Client, method string, path string, body []byte) ([]byte, error) {
+	bs, err := device.send(ctx, client, method, path, body)
+	if isTokenError(err) {
+		logger.Warn("Token was rejected, retrieving new token.", "device", device.Name)
+		device.TokenExpireTime = 0
+		if tokenErr := device.RetrieveToken(ctx, client); tokenErr != nil {
+			return []byte(``), tokenErr
+		}
+		bs, err = device.send(ctx, client, method, path, body)
+	}
+	return bs, err
+}
+
+func (device *TuyaDevice) send(ctx context.Context, client *http.Client, method string, path string, body []byte) ([]byte, error) {
+	req, _ := http.NewRequestWithContext(ctx, method, device.Host+path, bytes.NewReader(body))
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
+	// wait before signing, timestamp must be current
+	if budgetErr := device.Budget().Take(ctx); budgetErr != nil {
+		logger.Warn("Request was not sent.", "device", device.Name, "path", path, "error", budgetErr)
+		return []byte(``), budgetErr
+	}
 	device.buildHeader(req, body)
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println(err)
+		logger.Error("Request failed.", "device", device.Name, "path", path, "error", err)
 		return []byte(``), err
 	}
 	defer resp.Body.Close()
 	bs, _ := ioutil.ReadAll(resp.Body)
 
-	log.Println("resp:", string(bs))
+	logger.Debug("Response received.", "device", device.Name, "path", path, "status", resp.StatusCode, "response", bs)
+	if apiErr := checkResponse(bs); apiErr != nil {
+		return []byte(``), apiErr
+	}
 	return bs, nil
 }
 
-func (device TuyaDevice) ChangeMode(client http.Client, mode string) error {
-	log.Println("Changing device " + device.GetDeviceName() + " mode to '" + mode + "'.")
-	method := "POST"
-	commandString := fmt.Sprintf("{\"commands\":[{\"code\":\"master_mode\",\"value\":\"%s\"}]}", mode)
-	body := []byte(commandString)
-	req, _ := http.NewRequest(method, device.Host+"/v1.0/devices/"+device.DeviceID+"/commands", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
+func (device *TuyaDevice) GetDeviceInfo(ctx context.Context, client *http.Client) ([]byte, error) {
+	return device.request(ctx, client, "GET", "/v1.0/devices/"+device.DeviceID, []byte(``))
+}
 
-	device.buildHeader(req, body)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println(err)
+// GetDeviceSpecification returns the functions and status codes the device
+// supports.
+func (device *TuyaDevice) GetDeviceSpecification(ctx context.Context, client *http.Client) ([]byte, error) {
+	return device.request(ctx, client, "GET", "/v1.0/devices/"+device.DeviceID+"/specifications", []byte(``))
+}
+
+func (device *TuyaDevice) ChangeMode(ctx context.Context, client *http.Client, mode string) error {
+	logger.Info("Changing device mode.", "device", device.GetDeviceName(), "mode", mode)
+	commandString := fmt.Sprintf("{\"commands\":[{\"code\":\"master_mode\",\"value\":\"%s\"}]}", mode)
+	bs, err := device.request(ctx, client, "POST", "/v1.0/devices/"+device.DeviceID+"/commands", []byte(commandString))
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Errorf("Device '%s' failed to change state to %s, error was '%w'.", device.GetDeviceName(), mode, apiErr)
+	} else if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	bs, _ := ioutil.ReadAll(resp.Body)
-
-	log.Println("resp:", string(bs))
 
 	// retrieve Response status
 	response := ChangeModeResponse{}
@@ -156,10 +199,6 @@ func (device TuyaDevice) ChangeMode(client http.Client, mode string) error {
 	if unmarshalErr != nil {
 		return unmarshalErr
 	}
-	if !response.Success {
-		errorString := fmt.Sprintf("Device '%s' failed to change state to %s, error was '%s'.", device.GetDeviceName(), mode, response.Message)
-		return errors.New(errorString)
-	}
-	log.Println("Changing device " + device.GetDeviceName() + " mode to '" + mode + "' succeded.")
+	logger.Info("Device mode changed.", "device", device.GetDeviceName(), "mode", mode)
 	return nil
 }
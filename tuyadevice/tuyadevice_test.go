@@ -2,9 +2,16 @@ package tuyadevice
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/logging"
 )
 
 type RoundTripperMock struct {
@@ -18,11 +25,11 @@ func (rtm *RoundTripperMock) RoundTrip(*http.Request) (*http.Response, error) {
 
 func TestGetToken(t *testing.T) {
 
-	client := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593}`))}}}
 
 	device := TuyaDevice{Name: "Test"}
 
-	tokenError := device.RetrieveToken(client)
+	tokenError := device.RetrieveToken(context.Background(), client)
 
 	if tokenError != nil {
 		t.Errorf("Token retrievement should not fail. Error was %s", tokenError)
@@ -36,11 +43,11 @@ func TestGetToken(t *testing.T) {
 
 func TestGetTokenFailed(t *testing.T) {
 
-	client := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593`))}}}
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"access_token":"testtoken","expire_time":7200,"refresh_token":"refesh","uid":"bay1635003708553hilW"},"success":true,"t":1644740470593`))}}}
 
 	device := TuyaDevice{Name: "Test"}
 
-	tokenError := device.RetrieveToken(client)
+	tokenError := device.RetrieveToken(context.Background(), client)
 
 	if tokenError == nil {
 		t.Errorf("Token retrievement should fail.")
@@ -50,11 +57,11 @@ func TestGetTokenFailed(t *testing.T) {
 
 func TestGetDeviceInfo(t *testing.T) {
 
-	client := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
 	device := TuyaDevice{Name: "Test"}
 
-	_, deviceInfoErr := device.GetDeviceInfo(client)
+	_, deviceInfoErr := device.GetDeviceInfo(context.Background(), client)
 
 	if deviceInfoErr != nil {
 		t.Errorf("Device info retrievement should not fail. Error was %s", deviceInfoErr)
@@ -84,11 +91,11 @@ func TestValidateValidDevice(t *testing.T) {
 
 func TestGetDeviceType(t *testing.T) {
 
-	client := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":{"active_time":1634987857,"biz_type":18,"category":"mal","create_time":1620050314,"icon":"smart/icon/ay15427647462366edzT/153535979f068afab73c91841c844c82.png","id":"1234456789cca88fafe1","ip":"199.46.115.128","lat":"37.9988","local_key":"bc10cf0dca9aa13f","lon":"-5.0338","model":"99AST-西语","name":"Multifunction alarm","online":true,"owner_id":"11154007","product_id":"2aelhoqe23e7vxjr","product_name":"Multifunction alarm ","status":[{"code":"master_mode","value":"disarmed"},{"code":"delay_set","value":0},{"code":"alarm_time","value":1},{"code":"switch_alarm_sound","value":true},{"code":"switch_alarm_light","value":false},{"code":"switch_mode_sound","value":true},{"code":"switch_mode_light","value":true},{"code":"switch_kb_sound","value":true},{"code":"switch_kb_light","value":true},{"code":"password_set","value":""},{"code":"charge_state","value":true},{"code":"switch_low_battery","value":false},{"code":"alarm_call_number","value":"AQkAAQ=="},{"code":"alarm_sms_number","value":""},{"code":"switch_alarm_call","value":true},{"code":"switch_alarm_sms","value":true},{"code":"telnet_state","value":"sim_card_no"},{"code":"zone_attribute","value":"disarmed"},{"code":"muffling","value":false},{"code":"alarm_msg","value":"AEEAUABQACAARABlAHMAZQByAG0AYQBkAG8="},{"code":"alarm_delay_time","value":0},{"code":"switch_mode_dl_sound","value":false},{"code":"master_state","value":"normal"},{"code":"master_information","value":""},{"code":"factory_reset","value":false},{"code":"night_light_bright","value":1},{"code":"sub_class","value":"detector"},{"code":"sub_type","value":"motion_sensor"},{"code":"sub_admin","value":"CEAFEQH///8OAHAAYQBzAGkAbABsAG8="},{"code":"sub_state","value":"normal"}],"sub":false,"time_zone":"+01:00","uid":"eujJ01152904a15dpPln","update_time":1639405182,"uuid":"1531440084cca88fafe1"},"success":true,"t":1645128085588,"tid":"62fa5cb3902c11eceec15ef357c3f603"}`))}}}
 
 	device := TuyaDevice{Name: "Test", DeviceType: "TestType"}
 
-	device.GetDeviceInfo(client)
+	device.GetDeviceInfo(context.Background(), client)
 
 	deviceType := device.GetDeviceType()
 	if deviceType == "" {
@@ -112,8 +119,8 @@ func TestChangeMode(t *testing.T) {
 
 	device := TuyaDevice{Name: "Test", Host: "host.io", ClientID: "clientid", Secret: "secret", DeviceID: "deviceid", DeviceType: "alarm"}
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":true,"success":true,"t":1653184890385,"tid":"18dd6963d97311eca734f2b4cd1fee5a"}`))}}}
-	changeModeErr := device.ChangeMode(clientChangueModeResponse, "newmode")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"result":true,"success":true,"t":1653184890385,"tid":"18dd6963d97311eca734f2b4cd1fee5a"}`))}}}
+	changeModeErr := device.ChangeMode(context.Background(), clientChangueModeResponse, "newmode")
 	if changeModeErr != nil {
 		t.Errorf("Device mode change shouldn't fail. Error was %s", changeModeErr)
 	}
@@ -124,8 +131,8 @@ func TestChangeModeFailed(t *testing.T) {
 
 	device := TuyaDevice{Name: "Test", Host: "host.io", ClientID: "clientid", Secret: "secret", DeviceID: "deviceid", DeviceType: "alarm"}
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`))}}}
-	changeModeErr := device.ChangeMode(clientChangueModeResponse, "newmode")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"}`))}}}
+	changeModeErr := device.ChangeMode(context.Background(), clientChangueModeResponse, "newmode")
 	if changeModeErr == nil {
 		t.Errorf("Device mode change should fail.")
 	}
@@ -136,10 +143,74 @@ func TestChangeModeFailedBecauseJson(t *testing.T) {
 
 	device := TuyaDevice{Name: "Test", Host: "host.io", ClientID: "clientid", Secret: "secret", DeviceID: "deviceid", DeviceType: "alarm"}
 
-	clientChangueModeResponse := http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"`))}}}
-	changeModeErr := device.ChangeMode(clientChangueModeResponse, "newmode")
+	clientChangueModeResponse := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(`{"code":1109,"msg":"param is illegal ,please check it","success":false,"t":1653182849837,"tid":"589da661d96e11eca914e276ec45657f"`))}}}
+	changeModeErr := device.ChangeMode(context.Background(), clientChangueModeResponse, "newmode")
 	if changeModeErr == nil {
 		t.Errorf("Device mode change should fail.")
 	}
 
 }
+
+func TestGetDeviceSpecification(t *testing.T) {
+
+	specification := `{"result":{"category":"mal","functions":[{"code":"master_mode","type":"Enum","values":"{\"range\":[\"disarmed\",\"arm\",\"home\",\"sos\"]}"}],"status":[]},"success":true,"t":1653184890385}`
+	client := &http.Client{Transport: &RoundTripperMock{Response: &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(specification))}}}
+
+	device := TuyaDevice{Name: "Test"}
+
+	deviceSpecification, specificationErr := device.GetDeviceSpecification(context.Background(), client)
+
+	if specificationErr != nil {
+		t.Errorf("Device specification retrievement should not fail. Error was %s", specificationErr)
+	}
+	if string(deviceSpecification) != specification {
+		t.Errorf("Device specification should be returned as is, it was '%s'.", deviceSpecification)
+	}
+
+}
+
+// BlockingRoundTripperMock answers once the request is cancelled.
+type BlockingRoundTripperMock struct{}
+
+func (brtm BlockingRoundTripperMock) RoundTrip(request *http.Request) (*http.Response, error) {
+	<-request.Context().Done()
+	return nil, request.Context().Err()
+}
+
+func TestGetDeviceInfoCancelled(t *testing.T) {
+	client := &http.Client{Transport: BlockingRoundTripperMock{}}
+	device := TuyaDevice{Name: "Test", ClientID: "cancelled-client", Token: "token", TokenExpireTime: time.Now().Unix() + 3600}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, deviceInfoErr := device.GetDeviceInfo(ctx, client)
+	if !errors.Is(deviceInfoErr, context.DeadlineExceeded) {
+		t.Errorf("GetDeviceInfo should stop when context is done, error was '%v'.", deviceInfoErr)
+	}
+}
+
+func TestRequestsDoNotLogSecrets(t *testing.T) {
+	var output bytes.Buffer
+	logging.Configure(logging.Config{Level: logging.DebugLevel, Writer: &output})
+	defer logging.Configure(logging.Config{Level: logging.InfoLevel, Writer: os.Stderr})
+	transport := &SequenceRoundTripperMock{Bodies: []string{tokenJSON, `{"result":{"local_key":"4f5e6d7c","online":true},"success":true}`}}
+	device := TuyaDevice{Name: "Test", DeviceID: "device123"}
+
+	client := &http.Client{Transport: transport}
+
+	if tokenErr := device.RetrieveToken(context.Background(), client); tokenErr != nil {
+		t.Fatalf("Token should be retrieved, error was '%s'.", tokenErr)
+	}
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), client); deviceInfoErr != nil {
+		t.Fatalf("Device info should be retrieved, error was '%s'.", deviceInfoErr)
+	}
+	logged := output.String()
+	if !strings.Contains(logged, "Response received.") {
+		t.Errorf("Responses should be logged at debug level, log was %q.", logged)
+	}
+	for _, secret := range []string{"newtoken", "refesh", "4f5e6d7c"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("Log should not contain '%s', it was %q.", secret, logged)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Package tuyatest provides a fake Tuya cloud for tests.
+package tuyatest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+// Error codes returned by the fake cloud.
+const (
+	CodeSignInvalid       = 1004
+	CodeClientIDInvalid   = 1005
+	CodeTokenInvalid      = tuyadevice.CodeTokenInvalid
+	CodeTokenExpired      = tuyadevice.CodeTokenExpired
+	CodePermissionDenied  = tuyadevice.CodePermissionDenied
+	CodeParamIllegal      = tuyadevice.CodeParamIllegal
+	CodeRateLimited       = tuyadevice.CodeRateLimited
+	CodeDeviceOffline     = tuyadevice.CodeDeviceOffline
+	DefaultTokenExpiresIn = 7200
+)
+
+var codeMessages = map[int]string{
+	CodeSignInvalid:      "sign invalid",
+	CodeClientIDInvalid:  "clientId invalid",
+	CodeTokenInvalid:     "token invalid",
+	CodeTokenExpired:     "token is expired",
+	CodePermissionDenied: "permission deny",
+	CodeParamIllegal:     "param is illegal ,please check it",
+	CodeRateLimited:      "request frequency exceeds the limit",
+	CodeDeviceOffline:    "device is offline",
+}
+
+// Status is a data point of a device.
+type Status struct {
+	Code  string      `json:"code"`
+	Value interface{} `json:"value"`
+}
+
+// Function is a data point which can be changed through commands, Values
+// is the JSON encoded range of Enum functions.
+type Function struct {
+	Code   string `json:"code"`
+	Type   string `json:"type"`
+	Values string `json:"values"`
+}
+
+// Device is a device served by the fake cloud.
+type Device struct {
+	ID        string
+	Name      string
+	Category  string
+	Model     string
+	Online    bool
+	IP        string
+	LocalKey  string
+	Status    []Status
+	Functions []Function
+}
+
+// AlarmDevice returns an online 99AST alarm in mode.
+func AlarmDevice(deviceID string, name string, mode string) Device {
+	return Device{
+		ID:       deviceID,
+		Name:     name,
+		Category: "mal",
+		Model:    "99AST",
+		Online:   true,
+		IP:       "127.0.0.1",
+		LocalKey: "0123456789abcdef",
+		Status: []Status{
+			{Code: "master_mode", Value: mode},
+			{Code: "master_state", Value: "normal"},
+			{Code: "alarm_msg", Value: ""},
+			{Code: "charge_state", Value: true},
+			{Code: "switch_low_battery", Value: false},
+		},
+		Functions: []Function{
+			{Code: "master_mode", Type: "Enum", Values: `{"range":["disarmed","arm","home","sos"]}`},
+			{Code: "master_state", Type: "Enum", Values: `{"range":["normal","alarm"]}`},
+		},
+	}
+}
+
+// Command is a command accepted by the fake cloud.
+type Command struct {
+	DeviceID string
+	Code     string
+	Value    interface{}
+}
+
+// Server is a fake Tuya cloud listening on URL. Requests must be signed
+// with Secret like tuyadevice does.
+type Server struct {
+	URL       string
+	ClientID  string
+	Secret    string
+	server    *httptest.Server
+	expiresIn int
+	devices   map[string]*Device
+	tokens    map[string]time.Time
+	failures  []int
+	rateLimit int
+	window    time.Time
+	inWindow  int
+	requests  int
+	commands  []Command
+	mutex     sync.Mutex
+}
+
+// NewServer starts a fake cloud which accepts clientID and secret
+// credentials, it must be closed when tests end.
+func NewServer(clientID string, secret string) *Server {
+	server := &Server{ClientID: clientID, Secret: secret, expiresIn: DefaultTokenExpiresIn, devices: make(map[string]*Device), tokens: make(map[string]time.Time)}
+	server.server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	server.URL = server.server.URL
+	return server
+}
+
+func (server *Server) Close() {
+	server.server.Close()
+}
+
+func (server *Server) AddDevice(device Device) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.devices[device.ID] = &device
+}
+
+// SetTokenExpiration sets the lifetime of tokens issued from now on.
+func (server *Server) SetTokenExpiration(expiresIn time.Duration) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.expiresIn = int(expiresIn / time.Second)
+}
+
+// ExpireTokens makes every issued token expired.
+func (server *Server) ExpireTokens() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for token := range server.tokens {
+		server.tokens[token] = time.Time{}
+	}
+}
+
+// RevokeTokens makes every issued token invalid.
+func (server *Server) RevokeTokens() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.tokens = make(map[string]time.Time)
+}
+
+// InjectError makes the next count requests fail with code.
+func (server *Server) InjectError(code int, count int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for index := 0; index < count; index++ {
+		server.failures = append(server.failures, code)
+	}
+}
+
+// SetRateLimit makes requests beyond perSecond in the same second fail with
+// CodeRateLimited, zero disables the limit.
+func (server *Server) SetRateLimit(perSecond int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.rateLimit = perSecond
+}
+
+func (server *Server) SetOnline(deviceID string, online bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if device, ok := server.devices[deviceID]; ok {
+		device.Online = online
+	}
+}
+
+// SetStatus changes a data point as if device had reported it.
+func (server *Server) SetStatus(deviceID string, code string, value interface{}) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if device, ok := server.devices[deviceID]; ok {
+		device.setStatus(code, value)
+	}
+}
+
+// StatusValue returns the current value of a data point of deviceID.
+func (server *Server) StatusValue(deviceID string, code string) interface{} {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if device, ok := server.devices[deviceID]; ok {
+		for _, status := range device.Status {
+			if status.Code == code {
+				return status.Value
+			}
+		}
+	}
+	return nil
+}
+
+// Commands returns accepted commands, oldest first.
+func (server *Server) Commands() []Command {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]Command{}, server.commands...)
+}
+
+// Requests returns how many requests have been received.
+func (server *Server) Requests() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.requests
+}
+
+func (device *Device) setStatus(code string, value interface{}) {
+	for index := range device.Status {
+		if device.Status[index].Code == code {
+			device.Status[index].Value = value
+			return
+		}
+	}
+	device.Status = append(device.Status, Status{Code: code, Value: value})
+}
+
+// statusSpecification describes reported data points, types of data points
+// which are not functions are guessed from their values.
+func (device *Device) statusSpecification() []Function {
+	specification := []Function{}
+	for _, status := range device.Status {
+		function := Function{Code: status.Code, Type: "String", Values: "{}"}
+		for _, deviceFunction := range device.Functions {
+			if deviceFunction.Code == status.Code {
+				function = deviceFunction
+			}
+		}
+		if function.Type == "String" {
+			switch status.Value.(type) {
+			case bool:
+				function.Type = "Boolean"
+			case int, float64:
+				function.Type = "Integer"
+			}
+		}
+		specification = append(specification, function)
+	}
+	return specification
+}
+
+type response struct {
+	Result  interface{} `json:"result,omitempty"`
+	Code    int         `json:"code,omitempty"`
+	Message string      `json:"msg,omitempty"`
+	Success bool        `json:"success"`
+	T       int64       `json:"t"`
+	TID     string      `json:"tid"`
+}
+
+func randomHex(size int) string {
+	bytes := make([]byte, size)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+func (server *Server) reply(w http.ResponseWriter, result interface{}, code int) {
+	body := response{Success: code == 0, T: time.Now().UnixNano() / int64(time.Millisecond), TID: randomHex(16)}
+	if code == 0 {
+		body.Result = result
+	} else {
+		body.Code = code
+		body.Message = codeMessages[code]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	jsonString, _ := json.Marshal(body)
+	w.Write(jsonString)
+}
+
+// urlString is the signed part of request URL, see tuyadevice.
+func urlString(r *http.Request) string {
+	url := r.URL.Path
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for index, key := range keys {
+		if index == 0 {
+			url += "?"
+		} else {
+			url += "&"
+		}
+		url += key + "=" + query.Get(key)
+	}
+	return url
+}
+
+// validSign checks the request sign as Tuya cloud does, it is computed here
+// so tests catch signing mistakes of tuyadevice.
+func (server *Server) validSign(r *http.Request, body []byte) bool {
+	headers := ""
+	if signHeaderKeys := r.Header.Get("Signature-Headers"); signHeaderKeys != "" {
+		for _, key := range strings.Split(signHeaderKeys, ":") {
+			headers += key + ":" + r.Header.Get(key) + "\n"
+		}
+	}
+	contentHash := sha256.Sum256(body)
+	stringToSign := r.Method + "\n" + hex.EncodeToString(contentHash[:]) + "\n" + headers + "\n" + urlString(r)
+	mac := hmac.New(sha256.New, []byte(server.Secret))
+	mac.Write([]byte(server.ClientID + r.Header.Get("access_token") + r.Header.Get("t") + stringToSign))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil))) == r.Header.Get("sign")
+}
+
+// check returns the error code of a request, zero when it is valid. Mutex
+// must be held.
+func (server *Server) check(r *http.Request, body []byte, tokenRequired bool) int {
+	now := time.Now()
+	server.requests++
+	if server.rateLimit > 0 {
+		if now.Sub(server.window) >= time.Second {
+			server.window = now
+			server.inWindow = 0
+		}
+		server.inWindow++
+		if server.inWindow > server.rateLimit {
+			return CodeRateLimited
+		}
+	}
+	if len(server.failures) > 0 {
+		code := server.failures[0]
+		server.failures = server.failures[1:]
+		return code
+	}
+	if r.Header.Get("client_id") != server.ClientID {
+		return CodeClientIDInvalid
+	}
+	if !server.validSign(r, body) {
+		return CodeSignInvalid
+	}
+	if tokenRequired {
+		expiration, ok := server.tokens[r.Header.Get("access_token")]
+		if !ok {
+			return CodeTokenInvalid
+		}
+		if now.After(expiration) {
+			return CodeTokenExpired
+		}
+	}
+	return 0
+}
+
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1.0/token":
+		if code := server.check(r, body, false); code != 0 {
+			server.reply(w, nil, code)
+			return
+		}
+		token := randomHex(16)
+		server.tokens[token] = time.Now().Add(time.Duration(server.expiresIn) * time.Second)
+		server.reply(w, map[string]interface{}{"access_token": token, "expire_time": server.expiresIn, "refresh_token": randomHex(16), "uid": "fake-" + server.ClientID}, 0)
+	case len(path) >= 3 && path[0] == "v1.0" && path[1] == "devices":
+		if code := server.check(r, body, true); code != 0 {
+			server.reply(w, nil, code)
+			return
+		}
+		device, ok := server.devices[path[2]]
+		if !ok {
+			server.reply(w, nil, CodePermissionDenied)
+			return
+		}
+		switch {
+		case r.Method == "GET" && len(path) == 3:
+			server.reply(w, map[string]interface{}{"id": device.ID, "name": device.Name, "category": device.Category, "model": device.Model, "online": device.Online, "ip": device.IP, "local_key": device.LocalKey, "status": device.Status}, 0)
+		case r.Method == "GET" && len(path) == 4 && path[3] == "specifications":
+			server.reply(w, map[string]interface{}{"category": device.Category, "functions": device.Functions, "status": device.statusSpecification()}, 0)
+		case r.Method == "POST" && len(path) == 4 && path[3] == "commands":
+			server.reply(w, true, server.runCommands(device, body))
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// runCommands applies every command of body to device when all of them are
+// valid. Mutex must be held.
+func (server *Server) runCommands(device *Device, body []byte) int {
+	var request struct {
+		Commands []Status `json:"commands"`
+	}
+	if json.Unmarshal(body, &request) != nil || len(request.Commands) == 0 {
+		return CodeParamIllegal
+	}
+	if !device.Online {
+		return CodeDeviceOffline
+	}
+	for _, command := range request.Commands {
+		if !device.accepts(command) {
+			return CodeParamIllegal
+		}
+	}
+	for _, command := range request.Commands {
+		device.setStatus(command.Code, command.Value)
+		server.commands = append(server.commands, Command{DeviceID: device.ID, Code: command.Code, Value: command.Value})
+	}
+	return 0
+}
+
+func (device *Device) accepts(command Status) bool {
+	for _, function := range device.Functions {
+		if function.Code != command.Code {
+			continue
+		}
+		if function.Type != "Enum" {
+			return true
+		}
+		var values struct {
+			Range []string `json:"range"`
+		}
+		json.Unmarshal([]byte(function.Values), &values)
+		for _, value := range values.Range {
+			if value == command.Value {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package tuyatest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-castellano/AlarmManager/tuyadevice"
+)
+
+func testDevice(server *Server, secret string) *tuyadevice.TuyaDevice {
+	return &tuyadevice.TuyaDevice{Name: "Test Alarm", DeviceType: "99AST", Host: server.URL, ClientID: server.ClientID, Secret: secret, DeviceID: "device123"}
+}
+
+func decodeResponse(t *testing.T, request *http.Request) response {
+	httpResponse, requestErr := http.DefaultClient.Do(request)
+	if requestErr != nil {
+		t.Fatalf("Request should not fail, error was '%s'.", requestErr)
+	}
+	defer httpResponse.Body.Close()
+	var decoded response
+	if decodeErr := json.NewDecoder(httpResponse.Body).Decode(&decoded); decodeErr != nil {
+		t.Fatalf("Fake cloud response should be JSON, error was '%s'.", decodeErr)
+	}
+	return decoded
+}
+
+func TestServerDeviceInfo(t *testing.T) {
+	server := NewServer("client123", "secret123")
+	defer server.Close()
+	server.AddDevice(AlarmDevice("device123", "Test Alarm", "home"))
+	client := &http.Client{}
+
+	device := testDevice(server, "secret123")
+	if tokenErr := device.RetrieveToken(context.Background(), client); tokenErr != nil || device.Token == "" {
+		t.Fatalf("Token should be issued, error was '%v'.", tokenErr)
+	}
+	deviceInfo, deviceInfoErr := device.GetDeviceInfo(context.Background(), client)
+	if deviceInfoErr != nil || !strings.Contains(string(deviceInfo), `{"code":"master_mode","value":"home"}`) {
+		t.Errorf("Device info should be served, response was '%s'.", deviceInfo)
+	}
+}
+
+func TestServerRejectsInvalidSign(t *testing.T) {
+	server := NewServer("client123", "secret123")
+	defer server.Close()
+	server.AddDevice(AlarmDevice("device123", "Test Alarm", "home"))
+	client := &http.Client{}
+
+	device := testDevice(server, "wrongsecret")
+	var apiErr *tuyadevice.APIError
+	if tokenErr := device.RetrieveToken(context.Background(), client); !errors.As(tokenErr, &apiErr) || apiErr.Code != CodeSignInvalid || device.Token != "" {
+		t.Errorf("Tokens should not be issued to wrongly signed requests, error was '%v'.", tokenErr)
+	}
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), client); !errors.As(deviceInfoErr, &apiErr) || apiErr.Code != CodeSignInvalid {
+		t.Errorf("Wrongly signed requests should fail with code %d, error was '%v'.", CodeSignInvalid, deviceInfoErr)
+	}
+}
+
+func TestServerSignVectors(t *testing.T) {
+	server := &Server{ClientID: "client123", Secret: "secret123"}
+
+	// signs were computed with Python hmac and hashlib modules
+	cases := []struct {
+		method string
+		target string
+		token  string
+		body   string
+		sign   string
+	}{
+		{"GET", "/v1.0/token?grant_type=1", "", "", "7CA1FE90A8745B9A7FD5BE60C5AD5E042815B8381F8205AA6BCE402E7F11392B"},
+		{"POST", "/v1.0/devices/device123/commands", "token123", `{"commands":[{"code":"master_mode","value":"arm"}]}`, "03974B1E29214BFD26BD81E5D93A4522A713CBD706D16F25659E8C88368EC5E4"},
+	}
+	for _, testCase := range cases {
+		request := httptest.NewRequest(testCase.method, testCase.target, nil)
+		request.Header.Set("client_id", "client123")
+		request.Header.Set("access_token", testCase.token)
+		request.Header.Set("t", "1645128085588")
+		request.Header.Set("sign", testCase.sign)
+		if !server.validSign(request, []byte(testCase.body)) {
+			t.Errorf("Sign of %s %s should be valid.", testCase.method, testCase.target)
+		}
+		if server.validSign(request, []byte(testCase.body+" ")) {
+			t.Errorf("Sign of %s %s should not be valid for a different body.", testCase.method, testCase.target)
+		}
+		request.Header.Set("sign", strings.ToLower(testCase.sign))
+		if server.validSign(request, []byte(testCase.body)) {
+			t.Errorf("Lower case sign of %s %s should not be valid.", testCase.method, testCase.target)
+		}
+	}
+}
+
+func TestServerTokens(t *testing.T) {
+	server := NewServer("client123", "secret123")
+	defer server.Close()
+	server.AddDevice(AlarmDevice("device123", "Test Alarm", "home"))
+	client := &http.Client{}
+
+	device := testDevice(server, "secret123")
+	device.RetrieveToken(context.Background(), client)
+	token := device.Token
+	server.ExpireTokens()
+	if decoded := decodeResponse(t, signedRequest(server, "GET", "/v1.0/devices/device123", token)); decoded.Code != CodeTokenExpired {
+		t.Errorf("Expired tokens should fail with code %d, code was %d.", CodeTokenExpired, decoded.Code)
+	}
+	server.RevokeTokens()
+	if decoded := decodeResponse(t, signedRequest(server, "GET", "/v1.0/devices/device123", token)); decoded.Code != CodeTokenInvalid {
+		t.Errorf("Revoked tokens should fail with code %d, code was %d.", CodeTokenInvalid, decoded.Code)
+	}
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), client); deviceInfoErr != nil || device.Token == token {
+		t.Errorf("Devices should retrieve a new token when theirs is rejected, error was '%v'.", deviceInfoErr)
+	}
+}
+
+func TestServerCommands(t *testing.T) {
+	server := NewServer("client123", "secret123")
+	defer server.Close()
+	server.AddDevice(AlarmDevice("device123", "Test Alarm", "home"))
+	client := &http.Client{}
+
+	device := testDevice(server, "secret123")
+	device.RetrieveToken(context.Background(), client)
+	if changeErr := device.ChangeMode(context.Background(), client, "arm"); changeErr != nil {
+		t.Fatalf("Mode change should be accepted, error was '%s'.", changeErr)
+	}
+	if mode := server.StatusValue("device123", "master_mode"); mode != "arm" {
+		t.Errorf("Commands should change device state, mode is '%v'.", mode)
+	}
+	if commands := server.Commands(); len(commands) != 1 || commands[0].Code != "master_mode" {
+		t.Errorf("Accepted commands should be recorded, they were %+v.", commands)
+	}
+	if changeErr := device.ChangeMode(context.Background(), client, "party"); changeErr == nil {
+		t.Errorf("Values out of range should be rejected.")
+	}
+	server.SetOnline("device123", false)
+	if changeErr := device.ChangeMode(context.Background(), client, "disarmed"); changeErr == nil || !strings.Contains(changeErr.Error(), "device is offline") {
+		t.Errorf("Offline devices should reject commands, error was '%v'.", changeErr)
+	}
+}
+
+// signedRequest signs like tuyadevice does.
+func signedRequest(server *Server, method string, path string, token string) *http.Request {
+	request, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(nil))
+	request.Header.Set("client_id", server.ClientID)
+	request.Header.Set("access_token", token)
+	request.Header.Set("t", "1645128085588")
+	stringToSign := method + "\n" + tuyadevice.Sha256(nil) + "\n\n" + path
+	request.Header.Set("sign", strings.ToUpper(tuyadevice.HmacSha256(server.ClientID+token+"1645128085588"+stringToSign, server.Secret)))
+	return request
+}
+
+func TestServerSpecifications(t *testing.T) {
+	server := NewServer("client123", "secret123")
+	defer server.Close()
+	server.AddDevice(AlarmDevice("device123", "Test Alarm", "home"))
+
+	device := testDevice(server, "secret123")
+	device.RetrieveToken(context.Background(), &http.Client{})
+	httpResponse, requestErr := http.DefaultClient.Do(signedRequest(server, "GET", "/v1.0/devices/device123/specifications", device.Token))
+	if requestErr != nil {
+		t.Fatalf("Specification request should not fail, error was '%s'.", requestErr)
+	}
+	defer httpResponse.Body.Close()
+	var specification struct {
+		Result struct {
+			Functions []Function `json:"functions"`
+		} `json:"result"`
+		Success bool `json:"success"`
+	}
+	json.NewDecoder(httpResponse.Body).Decode(&specification)
+	if !specification.Success || len(specification.Result.Functions) != 2 || specification.Result.Functions[0].Code != "master_mode" {
+		t.Errorf("Device specification should be served, it was %+v.", specification)
+	}
+}
+
+func TestServerInjectedErrors(t *testing.T) {
+	server := NewServer("client123", "secret123")
+	defer server.Close()
+	server.AddDevice(AlarmDevice("device123", "Test Alarm", "home"))
+	client := &http.Client{}
+
+	device := testDevice(server, "secret123")
+	device.RetrieveToken(context.Background(), client)
+	server.InjectError(CodeDeviceOffline, 1)
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), client); !errors.Is(deviceInfoErr, tuyadevice.ErrDeviceOffline) {
+		t.Errorf("Injected errors should be returned, error was '%v'.", deviceInfoErr)
+	}
+	if _, deviceInfoErr := device.GetDeviceInfo(context.Background(), client); deviceInfoErr != nil {
+		t.Errorf("Only the requested number of errors should be injected, error was '%s'.", deviceInfoErr)
+	}
+
+	server.SetRateLimit(2)
+	var deviceInfoErr error
+	for index := 0; index < 3; index++ {
+		_, deviceInfoErr = device.GetDeviceInfo(context.Background(), client)
+	}
+	if !errors.Is(deviceInfoErr, tuyadevice.ErrRateLimited) {
+		t.Errorf("Requests over rate limit should fail with code %d, error was '%v'.", CodeRateLimited, deviceInfoErr)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+	"github.com/a-castellano/AlarmManager/systemd"
+)
+
+// pollerProgress reports status poller progress to systemd. Service is
+// ready once devices have been polled and alive while no poll takes longer
+// than watchdog timeout, a poller waiting for its next update is alive.
+type pollerProgress struct {
+	ready       bool
+	pollStarted time.Time
+	// notify is replaced in tests
+	notify func(state string) error
+	mutex  sync.Mutex
+}
+
+func newPollerProgress() *pollerProgress {
+	return &pollerProgress{notify: systemd.Notify}
+}
+
+func (progress *pollerProgress) send(state string) {
+	if notifyErr := progress.notify(state); notifyErr != nil {
+		logger.Warn("Failed to notify systemd.", "error", notifyErr)
+	}
+}
+
+// begin marks a poll as started at now.
+func (progress *pollerProgress) begin(now time.Time) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.pollStarted = now
+}
+
+// end marks the poll as finished, service becomes ready after the first
+// poll without errors. Device health is sent as status.
+func (progress *pollerProgress) end(health device_manager.Health, pollErr error) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.pollStarted = time.Time{}
+	status := systemd.Status(health.String())
+	if pollErr != nil {
+		status = systemd.Status(fmt.Sprintf("%s, last update failed: %s", health, pollErr))
+	}
+	if pollErr == nil && !progress.ready {
+		progress.ready = true
+		progress.send(systemd.Ready + "\n" + status)
+		return
+	}
+	progress.send(status)
+}
+
+// alive tells whether current poll, if any, started less than timeout ago.
+func (progress *pollerProgress) alive(now time.Time, timeout time.Duration) bool {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	return progress.pollStarted.IsZero() || now.Sub(progress.pollStarted) < timeout
+}
+
+// runWatchdog pings systemd watchdog twice per timeout until ctx is done,
+// pings stop while the poller is stuck so systemd restarts the service.
+func runWatchdog(ctx context.Context, progress *pollerProgress, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if progress.alive(now, timeout) {
+				progress.send(systemd.Watchdog)
+			} else {
+				logger.Error("Status update is stuck, skipping watchdog ping.", "timeout", timeout)
+			}
+		}
+	}
+}
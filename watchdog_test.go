@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	device_manager "github.com/a-castellano/AlarmManager/device_manager"
+)
+
+type notifyMock struct {
+	states []string
+	mutex  sync.Mutex
+}
+
+func (mock *notifyMock) notify(state string) error {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.states = append(mock.states, state)
+	return nil
+}
+
+func (mock *notifyMock) sent() []string {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	return append([]string{}, mock.states...)
+}
+
+func TestPollerProgressReady(t *testing.T) {
+	mock := &notifyMock{}
+	progress := &pollerProgress{notify: mock.notify}
+	health := device_manager.Health{Devices: 2, Online: 1}
+
+	progress.end(health, errors.New("device is offline"))
+	progress.end(health, nil)
+	progress.end(health, nil)
+	states := mock.sent()
+	expected := []string{
+		"STATUS=2 devices, 1 online, 0 firing, 0 low battery, last update failed: device is offline",
+		"READY=1\nSTATUS=2 devices, 1 online, 0 firing, 0 low battery",
+		"STATUS=2 devices, 1 online, 0 firing, 0 low battery",
+	}
+	if len(states) != len(expected) {
+		t.Fatalf("Sent states should be %q, they were %q.", expected, states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Errorf("State %d should be %q, it was %q.", i, expected[i], states[i])
+		}
+	}
+}
+
+func TestPollerProgressAlive(t *testing.T) {
+	progress := &pollerProgress{notify: (&notifyMock{}).notify}
+	start := time.Date(2022, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	if !progress.alive(start, time.Minute) {
+		t.Errorf("Poller waiting for next update should be alive.")
+	}
+	progress.begin(start)
+	if !progress.alive(start.Add(30*time.Second), time.Minute) {
+		t.Errorf("Poller should be alive while poll is shorter than timeout.")
+	}
+	if progress.alive(start.Add(2*time.Minute), time.Minute) {
+		t.Errorf("Poller should not be alive when poll takes longer than timeout.")
+	}
+	progress.end(device_manager.Health{}, nil)
+	if !progress.alive(start.Add(2*time.Minute), time.Minute) {
+		t.Errorf("Poller should be alive after poll ends.")
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	mock := &notifyMock{}
+	progress := &pollerProgress{notify: mock.notify}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runWatchdog(ctx, progress, 20*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(55 * time.Millisecond)
+	progress.begin(time.Now().Add(-time.Second))
+	// Let a tick checked before begin finish
+	time.Sleep(5 * time.Millisecond)
+	pings := len(mock.sent())
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	<-done
+
+	if pings == 0 || mock.sent()[0] != "WATCHDOG=1" {
+		t.Errorf("Watchdog should be pinged while poller is alive, sent states were %q.", mock.sent())
+	}
+	if len(mock.sent()) != pings {
+		t.Errorf("Watchdog should not be pinged while poller is stuck, sent states were %q.", mock.sent())
+	}
+}
@@ -0,0 +1,86 @@
+package webtls
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/a-castellano/AlarmManager/logging"
+)
+
+var logger = logging.New("tls")
+
+// Permissions granted to client identities.
+const (
+	ReadPermission  = "read"
+	WritePermission = "write"
+)
+
+// Identity returns who sent r: the common name of its client certificate,
+// or the whole subject when there is no common name. It is empty when
+// there is no client certificate.
+func Identity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	subject := r.TLS.PeerCertificates[0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName
+	}
+	return subject.String()
+}
+
+// Authorizer allows requests by the identity of their client certificate.
+type Authorizer struct {
+	// Permissions maps identities to ReadPermission or WritePermission,
+	// every verified client can write when it is empty
+	Permissions map[string]string
+}
+
+type authorizationResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"msg"`
+}
+
+// Middleware refuses requests without client certificate and requests
+// their identity is not allowed to send. Identities with ReadPermission
+// can only send GET and HEAD requests.
+func (authorizer *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := Identity(r)
+		var response authorizationResponse
+		if identity == "" {
+			response.Message = "Client certificate is required."
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(401)
+		} else if !authorizer.allowed(identity, r.Method) {
+			response.Message = fmt.Sprintf("Client '%s' is not allowed to send %s requests.", identity, r.Method)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(403)
+		} else {
+			next.ServeHTTP(w, r)
+			return
+		}
+		jsonString, _ := json.Marshal(response)
+		w.Write([]byte(jsonString))
+	})
+}
+
+// Permission returns the permission of identity, empty when it is not
+// allowed to send any request.
+func (authorizer *Authorizer) Permission(identity string) string {
+	if len(authorizer.Permissions) == 0 {
+		return WritePermission
+	}
+	return authorizer.Permissions[identity]
+}
+
+func (authorizer *Authorizer) allowed(identity string, method string) bool {
+	switch authorizer.Permission(identity) {
+	case WritePermission:
+		return true
+	case ReadPermission:
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package webtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func requestFrom(method string, subject *pkix.Name) *http.Request {
+	request := httptest.NewRequest(method, "/devices/status/home123", nil)
+	if subject != nil {
+		request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: *subject}}}
+	}
+	return request
+}
+
+func TestIdentity(t *testing.T) {
+	if identity := Identity(requestFrom("GET", nil)); identity != "" {
+		t.Errorf("Requests without certificate should have no identity, it was '%s'.", identity)
+	}
+	if identity := Identity(requestFrom("GET", &pkix.Name{CommonName: "kitchen-panel", Organization: []string{"Home"}})); identity != "kitchen-panel" {
+		t.Errorf("Identity should be the common name, it was '%s'.", identity)
+	}
+	if identity := Identity(requestFrom("GET", &pkix.Name{Organization: []string{"Home"}, OrganizationalUnit: []string{"Garage"}})); identity != "OU=Garage,O=Home" {
+		t.Errorf("Identity should be the subject without common name, it was '%s'.", identity)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	authorizer := &Authorizer{Permissions: map[string]string{"kitchen-panel": ReadPermission, "home-assistant": WritePermission}}
+	handler := authorizer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	cases := []struct {
+		method   string
+		subject  *pkix.Name
+		expected int
+	}{
+		{"GET", nil, 401},
+		{"GET", &pkix.Name{CommonName: "kitchen-panel"}, 204},
+		{"PUT", &pkix.Name{CommonName: "kitchen-panel"}, 403},
+		{"PUT", &pkix.Name{CommonName: "home-assistant"}, 204},
+		{"GET", &pkix.Name{CommonName: "stranger"}, 403},
+	}
+	for _, testCase := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, requestFrom(testCase.method, testCase.subject))
+		if recorder.Code != testCase.expected {
+			t.Errorf("%s request of %+v should return %d, it returned %d.", testCase.method, testCase.subject, testCase.expected, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	open := (&Authorizer{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	open.ServeHTTP(recorder, requestFrom("PUT", &pkix.Name{CommonName: "stranger"}))
+	if recorder.Code != 204 {
+		t.Errorf("Authorizer without permissions should allow every verified client, it returned %d.", recorder.Code)
+	}
+}
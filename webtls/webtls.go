@@ -0,0 +1,133 @@
+// Package webtls serves the API over TLS, reloading its certificate when
+// files change, and authorizes clients by the subject of their certificate.
+package webtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Versions are the accepted values of minimum TLS version.
+var Versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Options locate certificate files.
+type Options struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion uint16
+	// ClientCAFile enables client certificate authentication when set
+	ClientCAFile string
+}
+
+// reloadCheckInterval is the minimum time between checks of certificate
+// files.
+const reloadCheckInterval = 5 * time.Second
+
+// CertificateReloader serves a certificate which is loaded again when its
+// files are modified.
+type CertificateReloader struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modified    time.Time
+	checked     time.Time
+	// now is replaced in tests
+	now   func() time.Time
+	mutex sync.Mutex
+}
+
+// NewCertificateReloader loads the certificate, it fails when files can't
+// be read.
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if loadErr := reloader.load(); loadErr != nil {
+		return nil, loadErr
+	}
+	return reloader, nil
+}
+
+// lastModified returns the latest modification time of certificate files.
+func (reloader *CertificateReloader) lastModified() (time.Time, error) {
+	var modified time.Time
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, statErr := os.Stat(file)
+		if statErr != nil {
+			return modified, statErr
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+// load reads certificate files, mutex must be held.
+func (reloader *CertificateReloader) load() error {
+	modified, statErr := reloader.lastModified()
+	if statErr != nil {
+		return fmt.Errorf("Failed to read TLS certificate, error was '%w'.", statErr)
+	}
+	certificate, loadErr := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if loadErr != nil {
+		return fmt.Errorf("Failed to load TLS certificate, error was '%w'.", loadErr)
+	}
+	reloader.certificate = &certificate
+	reloader.modified = modified
+	reloader.checked = reloader.now()
+	return nil
+}
+
+// GetCertificate returns current certificate, files are checked at most
+// once every reloadCheckInterval. Previous certificate is kept when the new
+// one can't be loaded.
+func (reloader *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	if reloader.now().Sub(reloader.checked) < reloadCheckInterval {
+		return reloader.certificate, nil
+	}
+	reloader.checked = reloader.now()
+	if modified, statErr := reloader.lastModified(); statErr == nil && !modified.Equal(reloader.modified) {
+		if loadErr := reloader.load(); loadErr != nil {
+			logger.Error("Failed to reload TLS certificate, keeping previous one.", "error", loadErr)
+		} else {
+			logger.Info("TLS certificate reloaded.", "certificate", reloader.certFile)
+		}
+	}
+	return reloader.certificate, nil
+}
+
+// NewConfig returns the server TLS config, clients must present a
+// certificate signed by ClientCAFile when it is set.
+func NewConfig(options Options) (*tls.Config, error) {
+	reloader, reloaderErr := NewCertificateReloader(options.CertFile, options.KeyFile)
+	if reloaderErr != nil {
+		return nil, reloaderErr
+	}
+	config := &tls.Config{GetCertificate: reloader.GetCertificate, MinVersion: options.MinVersion}
+	if options.ClientCAFile != "" {
+		bundle, readErr := ioutil.ReadFile(options.ClientCAFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("Failed to read client CA bundle, error was '%w'.", readErr)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(bundle) {
+			errorString := fmt.Sprintf("Client CA bundle '%s' has no certificates.", options.ClientCAFile)
+			return nil, errors.New(errorString)
+		}
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
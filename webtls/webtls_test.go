@@ -0,0 +1,164 @@
+package webtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate and its key written as PEM files.
+type testCertificate struct {
+	CertFile    string
+	KeyFile     string
+	Certificate *x509.Certificate
+	Key         *ecdsa.PrivateKey
+}
+
+// writeCertificate creates a certificate of commonName signed by parent,
+// it is self signed CA when parent is nil.
+func writeCertificate(t *testing.T, dir string, commonName string, parent *testCertificate) testCertificate {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatalf("Key should be generated, error was '%s'.", keyErr)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Home"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Certificate, parent.Key
+	}
+	der, certErr := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if certErr != nil {
+		t.Fatalf("Certificate should be created, error was '%s'.", certErr)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	written := testCertificate{CertFile: filepath.Join(dir, commonName+".crt"), KeyFile: filepath.Join(dir, commonName+".key"), Certificate: certificate, Key: key}
+	ioutil.WriteFile(written.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(written.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return written
+}
+
+func (certificate testCertificate) pair(t *testing.T) tls.Certificate {
+	pair, pairErr := tls.LoadX509KeyPair(certificate.CertFile, certificate.KeyFile)
+	if pairErr != nil {
+		t.Fatalf("Certificate should be loaded, error was '%s'.", pairErr)
+	}
+	return pair
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	first := writeCertificate(t, dir, "server", nil)
+	reloader, reloaderErr := NewCertificateReloader(first.CertFile, first.KeyFile)
+	if reloaderErr != nil {
+		t.Fatalf("Certificate should be loaded, error was '%s'.", reloaderErr)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+
+	second := writeCertificate(t, dir, "server", nil)
+	modified := now.Add(time.Minute)
+	os.Chtimes(second.CertFile, modified, modified)
+	if certificate, _ := reloader.GetCertificate(nil); !sameCertificate(certificate, first) {
+		t.Errorf("Files should not be checked again before reloadCheckInterval.")
+	}
+	now = now.Add(reloadCheckInterval)
+	if certificate, _ := reloader.GetCertificate(nil); !sameCertificate(certificate, second) {
+		t.Errorf("Modified certificate should be reloaded.")
+	}
+
+	ioutil.WriteFile(second.KeyFile, []byte("broken"), 0600)
+	os.Chtimes(second.KeyFile, modified.Add(time.Minute), modified.Add(time.Minute))
+	now = now.Add(reloadCheckInterval)
+	if certificate, certificateErr := reloader.GetCertificate(nil); certificateErr != nil || !sameCertificate(certificate, second) {
+		t.Errorf("Previous certificate should be kept when the new one is invalid, error was '%v'.", certificateErr)
+	}
+}
+
+func sameCertificate(certificate *tls.Certificate, expected testCertificate) bool {
+	return len(certificate.Certificate) > 0 && string(certificate.Certificate[0]) == string(expected.Certificate.Raw)
+}
+
+func TestNewConfigClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := writeCertificate(t, dir, "server-ca", nil)
+	server := writeCertificate(t, dir, "localhost", &serverCA)
+	clientCA := writeCertificate(t, dir, "client-ca", nil)
+	client := writeCertificate(t, dir, "kitchen-panel", &clientCA)
+	stranger := writeCertificate(t, dir, "stranger", nil)
+
+	config, configErr := NewConfig(Options{CertFile: server.CertFile, KeyFile: server.KeyFile, MinVersion: tls.VersionTLS12, ClientCAFile: clientCA.CertFile})
+	if configErr != nil {
+		t.Fatalf("Config should be created, error was '%s'.", configErr)
+	}
+	api := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Identity(r)))
+	}))
+	api.TLS = config
+	api.StartTLS()
+	defer api.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.Certificate)
+	request := func(certificates []tls.Certificate, maxVersion uint16) (string, error) {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certificates, MaxVersion: maxVersion}}
+		response, getErr := (&http.Client{Transport: transport}).Get(api.URL)
+		if getErr != nil {
+			return "", getErr
+		}
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		return string(body), nil
+	}
+
+	if identity, requestErr := request([]tls.Certificate{client.pair(t)}, 0); requestErr != nil || identity != "kitchen-panel" {
+		t.Errorf("Client certificate common name should be the identity, identity was '%s' and error was '%v'.", identity, requestErr)
+	}
+	if _, requestErr := request(nil, 0); requestErr == nil {
+		t.Errorf("Clients without certificate should be refused.")
+	}
+	if _, requestErr := request([]tls.Certificate{stranger.pair(t)}, 0); requestErr == nil {
+		t.Errorf("Clients with certificates not signed by client CA should be refused.")
+	}
+	if _, requestErr := request([]tls.Certificate{client.pair(t)}, tls.VersionTLS11); requestErr == nil {
+		t.Errorf("Clients below minimum TLS version should be refused.")
+	}
+}
+
+func TestNewConfigInvalidClientCA(t *testing.T) {
+	dir := t.TempDir()
+	server := writeCertificate(t, dir, "localhost", nil)
+	ioutil.WriteFile(filepath.Join(dir, "empty.pem"), []byte("none"), 0600)
+
+	_, configErr := NewConfig(Options{CertFile: server.CertFile, KeyFile: server.KeyFile, ClientCAFile: filepath.Join(dir, "empty.pem")})
+	if configErr == nil || configErr.Error() != "Client CA bundle '"+filepath.Join(dir, "empty.pem")+"' has no certificates." {
+		t.Errorf("Bundles without certificates should fail, error was '%v'.", configErr)
+	}
+	if _, configErr := NewConfig(Options{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: server.KeyFile}); configErr == nil {
+		t.Errorf("Missing certificate files should fail.")
+	}
+}